@@ -0,0 +1,629 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// infoArgs are arguments of the /info command
+type infoArgs struct {
+	replyToPhoto bool // Show info about the photo the message replies to
+	photoIndex   int  // Number of the photo in the last sending
+}
+
+// newBotCommandRouter registers all bot commands
+func newBotCommandRouter() *CommandRouter {
+	router := NewCommandRouter()
+
+	router.Register(Command{
+		Name:        "start",
+		Description: "Start interaction with the bot",
+		Help: "/start - start interaction with the bot\n" +
+			"/start CODE - join with an invite code",
+		Permission: PermissionPublic,
+		ParseArgs:  parseStartArgs,
+		Handle:     handleStartCommand,
+	})
+
+	router.Register(Command{
+		Name:        "photo",
+		Description: "Send random photos from your library",
+		Help:        "/photo N - send N random photos from your library",
+		Permission:  PermissionViewer,
+		Limit:       photoRequests,
+		ParseArgs:   parsePhotoCountArgs,
+		Handle: func(bot Sender, update tgbotapi.Update, args any) {
+			queueSend("photo", &update, bot, func() {
+				sendRandomPhoto(args.(int), &update, bot)
+			})
+		},
+	})
+
+	router.Register(Command{
+		Name:        "memories",
+		Description: "Photos from this day 1 year ago (use /memories N for N years ago)",
+		Help: "/memories - photos taken on this day 1 year ago\n" +
+			"/memories N - photos taken on this day N years ago",
+		Permission: PermissionViewer,
+		ParseArgs:  parseMemoriesArgs,
+		Handle: func(bot Sender, update tgbotapi.Update, args any) {
+			queueSend("memories", &update, bot, func() {
+				sendMemoryPhotos(RequestTypeMemories, args.(int), &update, bot)
+			})
+		},
+	})
+
+	router.Register(Command{
+		Name:        "today",
+		Description: "View photos taken on this day across different years",
+		Help:        "/today - photos taken on this day across different years",
+		Permission:  PermissionViewer,
+		Handle: func(bot Sender, update tgbotapi.Update, _ any) {
+			queueSend("today", &update, bot, func() {
+				sendMemoryPhotos(RequestTypeToday, 0, &update, bot)
+			})
+		},
+	})
+
+	router.Register(Command{
+		Name:        "indexing",
+		Description: "Show photo indexing status, history of indexing runs or photos which failed indexing",
+		Help: "/indexing - show photo indexing status\n" +
+			"/indexing history - show latest indexing runs\n" +
+			"/indexing errors - show photos which failed indexing or EXIF extraction",
+		Permission: PermissionAdmin, // Paths of the library and its errors
+		ParseArgs:  parseIndexingArgs,
+		Handle:     handleIndexingCommand,
+	})
+
+	router.Register(Command{
+		Name:        "reindex",
+		Description: "Start photo reindexing (full/diff), control it (pause/resume/cancel) or check indexes (verify)",
+		Help: "/reindex full - full reindexing (clear and recreate indexes)\n" +
+			"/reindex diff - differential indexing (only new and modified files)\n" +
+			"/reindex verify - check indexes for stale and missing entries and repair them\n" +
+			"/reindex pause - pause running indexing\n" +
+			"/reindex resume - continue paused indexing from where it stopped\n" +
+			"/reindex cancel - stop running or paused indexing",
+		Permission: PermissionAdmin,
+		ParseArgs:  parseReindexArgs,
+		Handle:     handleReindexCommand,
+	})
+
+	router.Register(Command{
+		Name:        "formats",
+		Description: "Show photo formats found in the library and skipped files",
+		Permission:  PermissionAdmin,
+		Handle: func(bot Sender, update tgbotapi.Update, _ any) {
+			sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot, formatFormatsReport(update.Message.Chat.ID))
+		},
+	})
+
+	router.Register(Command{
+		Name:        "history",
+		Description: "Show which photos were already sent (/history reset to forget)",
+		Help: "/history - show how many photos were sent and which are shown most often\n" +
+			"/history reset - forget sent photos, so any photo can be sent again",
+		Permission: PermissionViewer,
+		ParseArgs:  parseHistoryArgs,
+		ArgsPermission: func(args any) Permission {
+			if args == "reset" {
+				return PermissionAdmin // Forgetting sent photos changes what everyone gets
+			}
+			return PermissionViewer
+		},
+		Handle: handleHistoryCommand,
+	})
+
+	router.Register(Command{
+		Name:        "info",
+		Description: "Show photo info (reply to photo or use /info N for Nth photo)",
+		Help: "/info N - show info about the Nth photo of the last sending\n" +
+			"/info - reply to a photo to show info about it",
+		Permission: PermissionViewer,
+		ParseArgs:  parseInfoArgs,
+		Handle: func(bot Sender, update tgbotapi.Update, args any) {
+			info := args.(infoArgs)
+			if info.replyToPhoto {
+				handleReplyToPhotoInfo(update, bot)
+				return
+			}
+			handleLastSendingInfo(update, info.photoIndex, bot)
+		},
+	})
+
+	router.Register(Command{
+		Name:        "favorite",
+		Description: "Add a photo to favorites or remove it (reply to photo or use /favorite N)",
+		Help: "/favorite N - add the Nth photo of the last sending to favorites or remove it\n" +
+			"/favorite - reply to a photo to add it to favorites or remove it",
+		Permission: PermissionAdmin,
+		ParseArgs:  parseFavoriteArgs,
+		Handle:     handleFavoriteCommand,
+	})
+
+	router.Register(Command{
+		Name:        "subscribe",
+		Description: "Subscribe this chat to scheduled photos: /subscribe random|memories [HH:MM]",
+		Help: "/subscribe random [HH:MM] - send random photos to this chat every day\n" +
+			"/subscribe memories [HH:MM] - send photos taken on this day in different years to this chat every day\n" +
+			"Without time photos are sent at the time of the main schedule",
+		Permission: PermissionAdmin,
+		ParseArgs:  parseSubscribeArgs,
+		Handle:     handleSubscribeCommand,
+	})
+
+	router.Register(Command{
+		Name:        "unsubscribe",
+		Description: "Unsubscribe this chat from scheduled photos: /unsubscribe [random|memories]",
+		Permission:  PermissionAdmin,
+		ParseArgs:   parseUnsubscribeArgs,
+		Handle:      handleUnsubscribeCommand,
+	})
+
+	router.Register(Command{
+		Name:        "subscriptions",
+		Description: "Show subscriptions of this chat (/subscriptions all for all chats)",
+		Help: "/subscriptions - show subscriptions of this chat\n" +
+			"/subscriptions all - show subscriptions of all chats",
+		Permission: PermissionAdmin,
+		ParseArgs:  parseSubscriptionsArgs,
+		Handle:     handleSubscriptionsCommand,
+	})
+
+	router.Register(Command{
+		Name:        "reload",
+		Description: "Reload the config file and show what changed",
+		Permission:  PermissionAdmin,
+		Handle: func(bot Sender, update tgbotapi.Update, _ any) {
+			reloadConfigAndReport(update.Message.Chat.ID, update.Message.MessageID, bot)
+		},
+	})
+
+	router.Register(Command{
+		Name:        "invite",
+		Description: "Create a one-time invite code: /invite [viewer|admin|owner] [expiry]",
+		Help: "/invite [viewer|admin|owner] [expiry] - create a one-time invite code, e.g. /invite viewer 7d\n" +
+			"Default role is viewer, the code expires in 24h",
+		Permission: PermissionAdmin,
+		ParseArgs:  parseInviteArgs,
+		Handle:     handleInviteCommand,
+	})
+
+	router.Register(Command{
+		Name:        "users",
+		Description: "Show users with their roles and pending invites",
+		Permission:  PermissionAdmin,
+		Handle:      handleUsersCommand,
+	})
+
+	router.Register(Command{
+		Name:        "promote",
+		Description: "Change the role of a user: /promote <user> viewer|admin|owner",
+		Help:        "/promote <user id|@username> viewer|admin|owner - change the role of a user added by an invite",
+		Permission:  PermissionAdmin,
+		ParseArgs:   parsePromoteArgs,
+		Handle:      handlePromoteCommand,
+	})
+
+	router.Register(Command{
+		Name:        "revoke",
+		Description: "Remove a user or an unused invite: /revoke <user|code>",
+		Help:        "/revoke <user id|@username|invite code> - remove a user added by an invite or an unused invite code",
+		Permission:  PermissionAdmin,
+		ParseArgs:   parseRevokeArgs,
+		Handle:      handleRevokeCommand,
+	})
+
+	router.Register(Command{
+		Name:        "help",
+		Description: "Show help information",
+		Permission:  PermissionPublic,
+		Handle: func(bot Sender, update tgbotapi.Update, _ any) {
+			sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot, router.HelpText())
+		},
+	})
+
+	return router
+}
+
+// parsePhotoCount parses the number of photos requested by user
+func parsePhotoCount(text string) (int, error) {
+	count, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil {
+		return 0, errors.New("Please send a number")
+	}
+	if count < 1 {
+		return 0, errors.New("Please send a number greater than 0")
+	}
+	return count, nil
+}
+
+func parsePhotoCountArgs(message *tgbotapi.Message) (any, error) {
+	return parsePhotoCount(message.CommandArguments())
+}
+
+func parseMemoriesArgs(message *tgbotapi.Message) (any, error) {
+	yearsArg := strings.TrimSpace(message.CommandArguments())
+	if yearsArg == "" {
+		return 1, nil // Default: 1 year ago
+	}
+
+	yearsAgo, err := strconv.Atoi(yearsArg)
+	if err != nil || yearsAgo < 1 {
+		return nil, errors.New("Please specify a valid number of years, for example: /memories 2")
+	}
+	return yearsAgo, nil
+}
+
+func parseInfoArgs(message *tgbotapi.Message) (any, error) {
+	infoArg := strings.TrimSpace(message.CommandArguments())
+
+	// If user replies to a specific photo message with /info and provides no argument,
+	// show that photo's info
+	if message.ReplyToMessage != nil && infoArg == "" {
+		return infoArgs{replyToPhoto: true}, nil
+	}
+
+	// "/info 2" is the 2nd photo from the last sending
+	if infoArg != "" {
+		photoIndex, err := strconv.Atoi(infoArg)
+		if err != nil {
+			return nil, errors.New("Please provide a valid number, e.g. /info 2.")
+		}
+		return infoArgs{photoIndex: photoIndex}, nil
+	}
+
+	return nil, errors.New("Please specify a photo number or reply to a specific photo with /info.")
+}
+
+func parseReindexArgs(message *tgbotapi.Message) (any, error) {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) < 1 {
+		return nil, errors.New("Usage: /reindex [full|diff|verify|pause|resume|cancel]\n" +
+			"full - full reindexing (clear and recreate indexes)\n" +
+			"diff - differential indexing (only new and modified files)\n" +
+			"verify - check indexes for stale and missing entries and repair them\n" +
+			"pause - pause running indexing\n" +
+			"resume - continue paused indexing from where it stopped\n" +
+			"cancel - stop running or paused indexing")
+	}
+
+	indexType := strings.ToLower(args[0])
+	switch indexType {
+	case "full", "diff", "verify", "pause", "resume", "cancel":
+		return indexType, nil
+	default:
+		return nil, errors.New("Unknown indexing type. Use 'full', 'diff', 'verify', 'pause', 'resume' or 'cancel'")
+	}
+}
+
+func parseIndexingArgs(message *tgbotapi.Message) (any, error) {
+	action := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if action != "" && action != "history" && action != "errors" {
+		return nil, errors.New("Usage: /indexing [history|errors]")
+	}
+	return action, nil
+}
+
+func parseHistoryArgs(message *tgbotapi.Message) (any, error) {
+	action := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if action != "" && action != "reset" {
+		return nil, errors.New("Usage: /history [reset]")
+	}
+	return action, nil
+}
+
+// historyTopPhotos is the number of most shown photos listed by /history
+const historyTopPhotos = 5
+
+// handleHistoryCommand shows or resets the history of sent photos
+func handleHistoryCommand(bot Sender, update tgbotapi.Update, args any) {
+	chatID := update.Message.Chat.ID
+	messageID := update.Message.MessageID
+
+	if args.(string) == "reset" {
+		err := ResetShowHistory()
+		if err != nil {
+			sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error resetting history: %v", err))
+			return
+		}
+		sendSafeReplyText(chatID, messageID, bot, "🧹 History of sent photos is cleared")
+		return
+	}
+
+	stats, err := GetShowHistoryStats(historyTopPhotos)
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error getting history: %v", err))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📜 History of sent photos\n\n")
+	sb.WriteString(fmt.Sprintf("Photos sent at least once: %d\n", stats.ShownPhotos))
+	sb.WriteString(fmt.Sprintf("Sent in the last %d days (not repeated): %d\n", currentConfig().repeatWindowDays,
+		stats.RecentlyShown))
+
+	if stats.LastShownPhoto != nil {
+		sb.WriteString(fmt.Sprintf("Last sent: %s\n", chatTime(chatID, stats.LastShownPhoto.LastShown).Format("02.01.2006 15:04")))
+	}
+
+	if len(stats.MostShown) > 0 {
+		sb.WriteString("\nMost often sent:\n")
+		for _, photo := range stats.MostShown {
+			sb.WriteString(fmt.Sprintf("%d× %s (last %s)\n", photo.TimesShown, photo.Path,
+				chatTime(chatID, photo.LastShown).Format("02.01.2006")))
+		}
+	}
+
+	sendSafeReplyText(chatID, messageID, bot, sb.String())
+}
+
+// formatIndexCheckReport formats the result of /reindex verify
+func formatIndexCheckReport(report *IndexCheckReport) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔍 Index check of %d photos\n\n", report.Photos))
+	sb.WriteString(fmt.Sprintf("Entries of photos not in index: %d\n", report.Orphans))
+	sb.WriteString(fmt.Sprintf("Entries under a wrong date: %d\n", report.Stale))
+	sb.WriteString(fmt.Sprintf("Photos missing from date indexes: %d\n", report.Missing))
+	sb.WriteString(fmt.Sprintf("Date entries in unknown format: %d\n", report.BrokenLists))
+	sb.WriteString(fmt.Sprintf("Random selection index problems: %d\n\n", report.IDProblems))
+
+	switch {
+	case report.Repaired:
+		sb.WriteString("✅ Indexes repaired")
+	case report.Problems() == 0:
+		sb.WriteString("✅ No problems found")
+	default:
+		sb.WriteString("⚠️ Problems found")
+	}
+	return sb.String()
+}
+
+// Limits of /indexing history and /indexing errors, Telegram messages are limited to 4096 characters
+const (
+	indexingHistoryShown     = 10
+	indexingFailedFilesShown = 20
+)
+
+// handleIndexingCommand shows indexing status and keeps it updated while indexing is active
+func handleIndexingCommand(bot Sender, update tgbotapi.Update, args any) {
+	switch args.(string) {
+	case "history":
+		sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot, formatIndexingHistory(update.Message.Chat.ID))
+		return
+	case "errors":
+		sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot, formatIndexingErrors(update.Message.Chat.ID))
+		return
+	}
+
+	active, _, _, err := GetIndexingStatus()
+	if err != nil {
+		sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
+			fmt.Sprintf("Error getting indexing status: %v", err))
+		return
+	}
+
+	// Send initial status message
+	statusMsgID, err := sendIndexingStatusMessage(update.Message.Chat.ID, update.Message.MessageID, bot)
+	if err != nil {
+		log.Printf("Error sending indexing status: %v", err)
+		return
+	}
+
+	// If indexing is active, start a goroutine to update the status message
+	if active {
+		go watchIndexingStatus(update.Message.Chat.ID, statusMsgID, bot)
+	}
+}
+
+// formatIndexingHistory lists the latest indexing runs for /indexing history
+func formatIndexingHistory(chatID int64) string {
+	reports, err := GetIndexingReports(indexingHistoryShown)
+	if err != nil {
+		return fmt.Sprintf("Error getting indexing history: %v", err)
+	}
+	if len(reports) == 0 {
+		return "No indexing runs yet"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🗂 Latest indexing runs\n")
+	for _, report := range reports {
+		sb.WriteString(fmt.Sprintf("\n#%d %s (%s), %s, %s, took %s\n", report.ID, report.Type, report.Trigger,
+			report.State, chatTime(chatID, report.StartedAt).Format("02.01.2006 15:04"),
+			formatDuration(report.FinishedAt.Sub(report.StartedAt).Seconds())))
+		sb.WriteString(fmt.Sprintf("Added %d, updated %d, skipped %d, failed %d, removed %d, without EXIF %d\n",
+			report.Added, report.Updated, report.Skipped, report.Failed, report.Removed, report.NoExif))
+	}
+	return sb.String()
+}
+
+// formatIndexingErrors lists photos which failed in the latest indexing run with failures for /indexing errors
+func formatIndexingErrors(chatID int64) string {
+	reports, err := GetIndexingReports(indexingHistoryLimit)
+	if err != nil {
+		return fmt.Sprintf("Error getting indexing history: %v", err)
+	}
+
+	for _, report := range reports {
+		if len(report.FailedFiles) == 0 {
+			continue
+		}
+
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("⚠️ Indexing run #%d (%s, %s): %d failed, %d without EXIF\n",
+			report.ID, report.Type, chatTime(chatID, report.StartedAt).Format("02.01.2006 15:04"), report.Failed, report.NoExif))
+		for i, file := range report.FailedFiles {
+			if i == indexingFailedFilesShown {
+				sb.WriteString(fmt.Sprintf("\n...and %d more, see the log", report.Failed+report.NoExif-i))
+				break
+			}
+			sb.WriteString(fmt.Sprintf("\n📂 %s\n%s\n", file.Path, file.Error))
+		}
+		return sb.String()
+	}
+
+	return "✅ No indexing errors"
+}
+
+// watchIndexingStatus updates the status message while indexing is active
+func watchIndexingStatus(chatID int64, messageID int, bot Sender) {
+	ticker := time.NewTicker(3 * time.Second) // Update every 3 seconds
+	defer ticker.Stop()
+
+	// Add counter to track changes in indexing progress
+	var lastProgress int64
+	unchangedCount := 0
+	maxUnchangedCount := 10 // Updates without changes (30 seconds) before the flag is checked for being stale
+
+	// Keep updating the status message while indexing is active
+	for {
+		select {
+		case <-appCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Check if indexing is still active
+		active, _, _, err := GetIndexingStatus()
+		if err != nil {
+			log.Printf("Error checking indexing status: %v", err)
+			return
+		}
+
+		// Check if the indexing progress has changed, any phase counts
+		var progress int64
+		if stats := runningIndexing.Load(); stats != nil {
+			progress = stats.progress()
+		}
+		if progress == lastProgress {
+			unchangedCount++
+		} else {
+			unchangedCount = 0
+			lastProgress = progress
+		}
+
+		// A flag left without a run of this process is stale, slow, throttled and paused runs keep it
+		if unchangedCount >= maxUnchangedCount {
+			reset, err := resetStaleIndexingFlag()
+			if err != nil {
+				log.Printf("Error resetting indexing flag: %v", err)
+			} else if reset {
+				log.Printf("Indexing status hasn't changed for %d seconds without a running indexing, reset stale flag",
+					3*maxUnchangedCount)
+				active = false
+			}
+		}
+
+		// Update the status message
+		err = updateIndexingStatusMessage(chatID, messageID, bot)
+		if err != nil {
+			log.Printf("Error updating indexing status: %v", err)
+			return
+		}
+
+		// If indexing is no longer active, update one last time and stop
+		if !active {
+			// Wait a moment for final stats to be updated
+			time.Sleep(1 * time.Second)
+
+			// Final update with completed status
+			err = updateIndexingStatusMessage(chatID, messageID, bot)
+			if err != nil {
+				log.Printf("Error updating final indexing status: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// handleReindexCommand starts full or differential reindexing
+func handleReindexCommand(bot Sender, update tgbotapi.Update, args any) {
+	indexType := args.(string)
+
+	// Control commands act on the running or paused indexing
+	switch indexType {
+	case "pause", "resume", "cancel":
+		sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot, controlIndexing(indexType))
+		return
+	}
+
+	// Check if indexing is already active
+	active, _, _, err := GetIndexingStatus()
+	if err != nil {
+		sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
+			fmt.Sprintf("Error checking indexing status: %v", err))
+		return
+	}
+
+	if active {
+		sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
+			"Indexing is already active, please wait for it to complete or use /reindex pause or /reindex cancel")
+		return
+	}
+
+	var responseMsg string
+	switch indexType {
+	case "full":
+		err = ForceReindexing(appCtx, cfg.photoRoots, cfg.indexWorkers, triggerUser)
+		if err != nil {
+			sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
+				fmt.Sprintf("Error starting full reindexing: %v", err))
+			return
+		}
+		responseMsg = "Full photo reindexing started"
+
+	case "diff":
+		err = StartDifferentialIndexing(appCtx, cfg.photoRoots, cfg.indexWorkers, triggerUser)
+		if err != nil {
+			sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
+				fmt.Sprintf("Error starting differential indexing: %v", err))
+			return
+		}
+		responseMsg = "Differential photo indexing started (only new and modified files)"
+
+	case "verify":
+		report, err := CheckIndex(true)
+		if err != nil {
+			sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
+				fmt.Sprintf("Error checking indexes: %v", err))
+			return
+		}
+		responseMsg = formatIndexCheckReport(report)
+	}
+
+	sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot, responseMsg)
+}
+
+// controlIndexing pauses, resumes or cancels indexing and returns the reply
+func controlIndexing(action string) string {
+	switch action {
+	case "pause":
+		err := PauseIndexing()
+		if err != nil {
+			return fmt.Sprintf("Cannot pause indexing: %v", err)
+		}
+		return "⏸ Indexing will pause after the current photos. Use /reindex resume to continue"
+
+	case "resume":
+		err := ResumeIndexing(appCtx, cfg.photoRoots, cfg.indexWorkers)
+		if err != nil {
+			return fmt.Sprintf("Cannot resume indexing: %v", err)
+		}
+		return "▶️ Indexing resumed"
+
+	default:
+		err := CancelIndexing()
+		if err != nil {
+			return fmt.Sprintf("Cannot cancel indexing: %v", err)
+		}
+		return "⏹ Indexing cancelled. Photos indexed so far stay in the index, /reindex diff picks up the rest"
+	}
+}
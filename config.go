@@ -1,17 +1,36 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/robfig/cron/v3"
 )
 
+var keyConfigFile = "FM_CONFIG_FILE"
+
 var keyChatId = "FM_CHAT_ID"
-var keyAllowedUsers = "FM_ALLOWED_USERS_ID"
+var keyAllowedUsers = "FM_ALLOWED_USERS_ID" // Users with the admin role
+var keyOwnerUsers = "FM_OWNER_USERS_ID"
+var keyViewerUsers = "FM_VIEWER_USERS_ID"
+var keyViewerPhotoLimit = "FM_VIEWER_PHOTO_LIMIT"
+var keyAdminPhotoLimit = "FM_ADMIN_PHOTO_LIMIT"
 var keyBotToken = "FM_TG_BOT_TOKEN"
 var keyPhotoCount = "FM_PHOTO_COUNT"
 var keyPhotoPath = "FM_PHOTO_PATH"
+var keyPhotoRoots = "FM_PHOTO_ROOTS"
+var keyPhotoRootPrefix = "FM_PHOTO_ROOT_" // FM_PHOTO_ROOT_<LABEL>_INCLUDE, _EXCLUDE and _ENABLED
+var keyMediaExtensions = "FM_MEDIA_EXTENSIONS"
+var keyMediaSniff = "FM_MEDIA_SNIFF"
 var keyDbPath = "FM_DB_PATH"
 var keyCronSpec = "FM_SEND_PHOTO_CRON_SPEC"
 var keySendPhotosByNumber = "FM_SEND_PHOTOS_BY_NUMBER"
@@ -19,127 +38,437 @@ var keyDebug = "FM_DEBUG"
 var keyMemoriesCronSpec = "FM_MEMORIES_CRON_SPEC"
 var keyMemoriesPhotoCount = "FM_MEMORIES_PHOTO_COUNT"
 var keyReindexCronSpec = "FM_REINDEX_CRON_SPEC"
+var keySchedules = "FM_SCHEDULES"
+var keySchedulePrefix = "FM_SCHEDULE_" // FM_SCHEDULE_<NAME>_CRON, _CHAT_ID, _COUNT, _SOURCE, _YEARS and _FOLDER
+var keySendWorkers = "FM_SEND_WORKERS"
+var keyRepeatWindowDays = "FM_REPEAT_WINDOW_DAYS"
+var keyTimeZone = "FM_TIME_ZONE"
+var keyChatTimeZones = "FM_CHAT_TIME_ZONES"
+var keyWatchMode = "FM_WATCH_MODE"
+var keyWatchDebounce = "FM_WATCH_DEBOUNCE"
+var keyWatchPollInterval = "FM_WATCH_POLL_INTERVAL"
+var keyIndexWorkers = "FM_INDEX_WORKERS"
+var keyIndexExifWorkers = "FM_INDEX_EXIF_WORKERS"
+var keyIndexHashWorkers = "FM_INDEX_HASH_WORKERS"
+var keyIndexFilesPerSec = "FM_INDEX_FILES_PER_SEC"
+var keyIndexThrottleHours = "FM_INDEX_THROTTLE_HOURS"
 
 var keyTelegramProxyURL = "FM_TELEGRAM_PROXY_URL"
 var keyTelegramProxyUser = "FM_TELEGRAM_PROXY_USER"
 var keyTelegramProxyPass = "FM_TELEGRAM_PROXY_PASS"
 
+var keyUpdateMode = "FM_UPDATE_MODE"
+var keyWebhookListenAddr = "FM_WEBHOOK_LISTEN_ADDR"
+var keyWebhookURL = "FM_WEBHOOK_URL"
+var keyWebhookSecret = "FM_WEBHOOK_SECRET"
+var keyWebhookCertFile = "FM_WEBHOOK_CERT_FILE"
+var keyWebhookKeyFile = "FM_WEBHOOK_KEY_FILE"
+
 type Config struct {
 	chatId             int64
-	allowedUserIds     []int64
+	allowedUserIds     []int64 // Admins
+	ownerUserIds       []int64
+	viewerUserIds      []int64
+	viewerPhotoLimit   int // Photo requests per hour of a viewer, 0 is unlimited
+	adminPhotoLimit    int // Photo requests per hour of an admin, 0 is unlimited
 	botToken           string
 	photoCount         int
-	photoPath          string
+	photoRoots         []photoRoot // Folders of the photo library
+	mediaExtensions    []string    // Extensions of photos in addition to the built-in formats
+	mediaSniff         bool        // Files without extension are recognized by their content
 	dbPath             string
 	cronSpec           string
 	sendPhotosByNumber bool
 	debug              bool
 	memoriesCronSpec   string
 	memoriesPhotoCount int
-	reindexCronSpec    string // Cron schedule for automatic reindexing
+	reindexCronSpec    string                   // Cron schedule for automatic reindexing
+	schedules          []photoSchedule          // Photos sent on schedule
+	sendWorkers        int                      // Number of photo sends processed at the same time
+	repeatWindowDays   int                      // Photos shown within this number of days are not sent again
+	location           *time.Location           // Time zone of schedules, memories and dates in messages
+	chatLocations      map[int64]*time.Location // Time zones of chats overriding location
+	watchMode          string                   // How library changes are detected: auto, poll or off
+	watchDebounce      time.Duration
+	watchPollInterval  time.Duration
+	indexWorkers       int     // Number of photos indexed at the same time
+	indexExifWorkers   int     // Number of photos whose EXIF is read at the same time
+	indexHashWorkers   int     // Number of photos hashed at the same time
+	indexFilesPerSec   float64 // Low priority mode: photos read by indexing per second, 0 is unlimited
+	indexThrottleFrom  int     // Hours of the low priority mode, all day if equal
+	indexThrottleTo    int
 	telegramProxyURL   string
 	telegramProxyUser  string
 	telegramProxyPass  string
+	updateMode         string // How updates are received: polling or webhook
+	webhookListenAddr  string
+	webhookURL         string
+	webhookSecret      string
+	webhookCertFile    string
+	webhookKeyFile     string
+
+	settings []configValue // Settings as they were read, for printing the config
+}
+
+// loadConfig reads settings from the environment over the config file at path, if it is set.
+// All invalid settings are reported in the error.
+func loadConfig(path string) (Config, error) {
+	p := &configParser{}
+	if path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		p.file = file
+	}
+
+	c := parseConfig(p)
+	p.checkUnknownFileKeys()
+	if len(p.errs) > 0 {
+		return c, errors.Join(p.errs...)
+	}
+	return c, nil
+}
+
+func parseConfig(p *configParser) Config {
+	var c Config
+
+	c.botToken = p.required(keyBotToken)
+	c.chatId = p.id(keyChatId, 0)
+	c.allowedUserIds = p.ids(keyAllowedUsers)
+
+	// Roles of users and their limits, owners are not limited
+	c.ownerUserIds = p.ids(keyOwnerUsers)
+	c.viewerUserIds = p.ids(keyViewerUsers)
+	checkUserRoles(p, c)
+	c.viewerPhotoLimit = p.int(keyViewerPhotoLimit, 10, 0, math.MaxInt)
+	c.adminPhotoLimit = p.int(keyAdminPhotoLimit, 0, 0, math.MaxInt)
+
+	// Telegram sends at most 10 photos in a media group, larger counts worked before and are clamped
+	c.photoCount = p.int(keyPhotoCount, 5, 1, math.MaxInt)
+	if c.photoCount > 10 {
+		log.Printf("%s is %d, but Telegram sends at most 10 photos in a media group, using 10", keyPhotoCount, c.photoCount)
+		c.photoCount = 10
+	}
+	photoLibPath := p.string(keyPhotoPath, "/photoLibrary")
+	c.photoRoots = parsePhotoRoots(p, photoLibPath)
+	c.mediaExtensions = p.list(keyMediaExtensions)
+	c.mediaSniff = p.bool(keyMediaSniff, false)
+	c.dbPath = p.string(keyDbPath, "photo_moments.db")
+
+	// Time zones of schedules and dates, default the time zone of the system
+	c.location = p.location(keyTimeZone)
+	c.chatLocations = parseChatTimeZones(p)
+
+	c.cronSpec = p.cron(keyCronSpec, "0 10 * * *")
+	c.sendPhotosByNumber = p.bool(keySendPhotosByNumber, true)
+	c.debug = p.bool(keyDebug, false)
+
+	// Settings for sending memories
+	c.memoriesCronSpec = p.cron(keyMemoriesCronSpec, "0 12 * * *") // Default at 12:00 every day
+	c.memoriesPhotoCount = p.int(keyMemoriesPhotoCount, 5, 1, math.MaxInt)
+
+	// Settings for automatic reindexing
+	c.reindexCronSpec = p.cron(keyReindexCronSpec, "0 0 * * 0") // Default at midnight every Sunday
+
+	c.schedules = parseSchedules(p, c)
+
+	c.sendWorkers = p.int(keySendWorkers, 1, 1, math.MaxInt)            // Default sends are processed one at a time
+	c.repeatWindowDays = p.int(keyRepeatWindowDays, 30, 0, math.MaxInt) // Default photos are not repeated within a month
+
+	// Settings for watching the library
+	c.watchMode = p.oneOf(keyWatchMode, watchModeAuto, watchModeAuto, watchModePoll, watchModeOff)
+	c.watchDebounce = p.duration(keyWatchDebounce, 10*time.Second)
+	c.watchPollInterval = p.duration(keyWatchPollInterval, 15*time.Minute)
+
+	// Settings for indexing
+	c.indexWorkers = p.int(keyIndexWorkers, defaultIndexWorkers(), 1, math.MaxInt)
+	c.indexExifWorkers = p.int(keyIndexExifWorkers, c.indexWorkers, 1, math.MaxInt) // Default EXIF is read by all workers
+	c.indexHashWorkers = p.int(keyIndexHashWorkers, 2, 1, math.MaxInt)              // Default hashing reads at most two whole files at a time
+	c.indexFilesPerSec = p.float(keyIndexFilesPerSec, 0)                            // Default low priority mode is off
+	c.indexThrottleFrom, c.indexThrottleTo = p.hourRange(keyIndexThrottleHours)     // Default throttle all day
+
+	c.telegramProxyURL = p.url(keyTelegramProxyURL)
+	c.telegramProxyUser = p.string(keyTelegramProxyUser, "")
+	c.telegramProxyPass = p.string(keyTelegramProxyPass, "")
+
+	// Settings for receiving updates
+	c.updateMode = p.oneOf(keyUpdateMode, updateModePolling, updateModePolling, updateModeWebhook)
+	c.webhookListenAddr = p.string(keyWebhookListenAddr, ":8000")
+	c.webhookURL = p.url(keyWebhookURL)
+	c.webhookSecret = p.string(keyWebhookSecret, "")
+	c.webhookCertFile = p.string(keyWebhookCertFile, "")
+	c.webhookKeyFile = p.string(keyWebhookKeyFile, "")
+
+	if c.updateMode == updateModeWebhook && c.webhookURL == "" {
+		p.fail(keyWebhookURL, "is required in webhook mode")
+	}
+	if c.webhookSecret != "" && !webhookSecretPattern.MatchString(c.webhookSecret) {
+		p.fail(keyWebhookSecret, "must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	if (c.webhookCertFile == "") != (c.webhookKeyFile == "") {
+		p.fail(keyWebhookCertFile, "must be set with %s to serve TLS", keyWebhookKeyFile)
+	}
+
+	c.settings = p.values
+	return c
+}
+
+const (
+	configSourceEnv     = "env"
+	configSourceFile    = "file"
+	configSourceDefault = "default"
+)
+
+// configValue is a setting as it is used, with where it comes from
+type configValue struct {
+	key    string
+	value  string
+	source string // env, file or default
+}
+
+// configParser reads settings, the environment overrides the config file.
+// Invalid settings are collected, so all of them are reported at once.
+type configParser struct {
+	file   map[string]string // Settings of the config file by environment name
+	values []configValue
+	errs   []error
+}
+
+func (p *configParser) fail(key string, format string, args ...any) {
+	p.errs = append(p.errs, fmt.Errorf("%s %s", key, fmt.Sprintf(format, args...)))
 }
 
-// TODO: rewrite configs with go-flags
-func getConfig() Config {
+// string returns the setting or def if it is not set
+func (p *configParser) string(key string, def string) string {
+	value, source := strings.TrimSpace(os.Getenv(key)), configSourceEnv
+	if value == "" {
+		value, source = strings.TrimSpace(p.file[key]), configSourceFile
+	}
+	if value == "" {
+		value, source = def, configSourceDefault
+	}
+	p.values = append(p.values, configValue{key: key, value: value, source: source})
+	return value
+}
+
+func (p *configParser) required(key string) string {
+	value := p.string(key, "")
+	if value == "" {
+		p.fail(key, "is required")
+	}
+	return value
+}
 
-	chatIdEnv := os.Getenv(keyChatId)
-	chatId, err := strconv.Atoi(chatIdEnv)
+// int returns a number from min to max
+func (p *configParser) int(key string, def int, min int, max int) int {
+	value := p.string(key, strconv.Itoa(def))
+	parsed, err := strconv.Atoi(value)
+	if err == nil && parsed >= min && parsed <= max {
+		return parsed
+	}
+	if max == math.MaxInt {
+		p.fail(key, "must be a number of at least %d, got %q", min, value)
+	} else {
+		p.fail(key, "must be a number from %d to %d, got %q", min, max, value)
+	}
+	return def
+}
+
+// float returns a number of at least 0
+func (p *configParser) float(key string, def float64) float64 {
+	value := p.string(key, strconv.FormatFloat(def, 'f', -1, 64))
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 {
+		p.fail(key, "must be a number of at least 0, got %q", value)
+		return def
+	}
+	return parsed
+}
+
+func (p *configParser) bool(key string, def bool) bool {
+	value := p.string(key, strconv.FormatBool(def))
+	parsed, err := strconv.ParseBool(value)
 	if err != nil {
-		log.Panic("Failed to parse chat id")
+		p.fail(key, "must be true or false, got %q", value)
+		return def
 	}
+	return parsed
+}
 
-	allowedUsersEnv := os.Getenv(keyAllowedUsers)
-	allowedUserIds := make([]int64, 0)
-	for _, allowedUser := range strings.Split(allowedUsersEnv, ";") {
-		if allowedUser == "" {
-			continue
+// duration returns a positive duration like 10s or 15m
+func (p *configParser) duration(key string, def time.Duration) time.Duration {
+	value := p.string(key, def.String())
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		p.fail(key, "must be a positive duration like 10s or 15m, got %q", value)
+		return def
+	}
+	return parsed
+}
+
+// cron returns a standard cron spec with 5 fields or a descriptor like @daily, required if def is empty
+func (p *configParser) cron(key string, def string) string {
+	value := p.string(key, def)
+	if value == "" {
+		p.fail(key, "is required")
+		return ""
+	}
+	if _, err := cron.ParseStandard(value); err != nil {
+		p.fail(key, "must be a cron spec like \"0 10 * * *\": %v", err)
+		return def
+	}
+	return value
+}
+
+// location returns an IANA time zone like Europe/Berlin, the local time zone if it is not set
+func (p *configParser) location(key string) *time.Location {
+	value := p.string(key, "")
+	if value == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		p.fail(key, "must be an IANA time zone like Europe/Berlin, got %q", value)
+		return time.Local
+	}
+	return loc
+}
+
+// oneOf returns one of values in lower case
+func (p *configParser) oneOf(key string, def string, values ...string) string {
+	value := strings.ToLower(p.string(key, def))
+	for _, v := range values {
+		if value == v {
+			return value
 		}
-		allowedId, err := strconv.ParseInt(allowedUser, 10, 64)
-		if err != nil {
-			log.Panic("Failed to parse allowed user ids")
+	}
+	p.fail(key, "must be one of %s, got %q", strings.Join(values, ", "), value)
+	return def
+}
+
+// list returns values separated by ;
+func (p *configParser) list(key string) []string {
+	var list []string
+	for _, value := range strings.Split(p.string(key, ""), ";") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			list = append(list, value)
 		}
-		allowedUserIds = append(allowedUserIds, allowedId)
 	}
+	return list
+}
 
-	var photoCount = os.Getenv(keyPhotoCount)
-	parsedCount, convErr := strconv.Atoi(photoCount)
-	if convErr != nil {
-		log.Println(convErr)
-		//if failed to parse, set default value
-		parsedCount = 5
+// id returns a Telegram chat or user id, def if it is not set or required if def is 0
+func (p *configParser) id(key string, def int64) int64 {
+	defValue := ""
+	if def != 0 {
+		defValue = strconv.FormatInt(def, 10)
+	}
+	value := p.string(key, defValue)
+	if value == "" {
+		p.fail(key, "is required")
+		return 0
 	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id == 0 {
+		p.fail(key, "must be a Telegram id, got %q", value)
+	}
+	return id
+}
 
-	photoLibPath := "/photoLibrary"
-	var overridePath = os.Getenv(keyPhotoPath)
-	if overridePath != "" {
-		photoLibPath = overridePath
+// ids returns Telegram ids separated by ;
+func (p *configParser) ids(key string) []int64 {
+	ids := make([]int64, 0)
+	for _, value := range p.list(key) {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id == 0 {
+			p.fail(key, "must be Telegram ids separated by ;, got %q", value)
+			continue
+		}
+		ids = append(ids, id)
 	}
+	return ids
+}
 
-	dbPath := "photo_moments.db"
-	overrideDbPath := os.Getenv(keyDbPath)
-	if overrideDbPath != "" {
-		dbPath = overrideDbPath
+// url returns an absolute URL or an empty string if it is not set
+func (p *configParser) url(key string) string {
+	value := p.string(key, "")
+	if value == "" {
+		return ""
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		p.fail(key, "must be an absolute URL, got %q", value)
 	}
+	return value
+}
 
-	cronSpec := "0 10 * * *"
-	overrideCronSpec := os.Getenv(keyCronSpec)
-	if overrideCronSpec != "" {
-		cronSpec = overrideCronSpec
+// hourRange returns hours like 8-22, all day if not set
+func (p *configParser) hourRange(key string) (int, int) {
+	value := p.string(key, "")
+	if value == "" {
+		return 0, 0
+	}
+	from, to, err := parseHourRange(value)
+	if err != nil {
+		p.fail(key, "%v", err)
+		return 0, 0
 	}
+	return from, to
+}
 
-	sendPhotosByNumber := true
-	overrideSendPhotosByNumber, err := strconv.ParseBool(os.Getenv(keySendPhotosByNumber))
-	if err == nil {
-		sendPhotosByNumber = overrideSendPhotosByNumber
+// checkUnknownFileKeys reports settings of the config file which were not read, e.g. misspelled
+func (p *configParser) checkUnknownFileKeys() {
+	sources := make(map[string]string)
+	for _, v := range p.values {
+		sources[v.key] = v.source
 	}
 
-	debug := false
-	debugEnv, err := strconv.ParseBool(os.Getenv(keyDebug))
-	if err == nil {
-		debug = debugEnv
+	// Settings of roots and schedules of the file are not read if the environment overrides their list
+	overridden := func(key string) bool {
+		return strings.HasPrefix(key, keyPhotoRootPrefix) && sources[keyPhotoRoots] == configSourceEnv ||
+			strings.HasPrefix(key, keySchedulePrefix) && sources[keySchedules] == configSourceEnv
 	}
 
-	// Settings for sending memories
-	memoriesCronSpec := "0 12 * * *" // Default at 12:00 every day
-	overrideMemoriesCronSpec := os.Getenv(keyMemoriesCronSpec)
-	if overrideMemoriesCronSpec != "" {
-		memoriesCronSpec = overrideMemoriesCronSpec
-	}
-
-	memoriesPhotoCount := 5 // Default 5 photos
-	overrideMemoriesPhotoCount := os.Getenv(keyMemoriesPhotoCount)
-	if overrideMemoriesPhotoCount != "" {
-		parsedMemoriesCount, err := strconv.Atoi(overrideMemoriesPhotoCount)
-		if err == nil && parsedMemoriesCount > 0 {
-			memoriesPhotoCount = parsedMemoriesCount
+	var unknown []string
+	for key := range p.file {
+		if sources[key] == "" && !overridden(key) {
+			unknown = append(unknown, key)
 		}
 	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		p.errs = append(p.errs, fmt.Errorf("unknown setting %s in the config file", configFileName(key)))
+	}
+}
 
-	// Settings for automatic reindexing
-	reindexCronSpec := "0 0 * * 0" // Default at midnight every Sunday
-	overrideReindexCronSpec := os.Getenv(keyReindexCronSpec)
-	if overrideReindexCronSpec != "" {
-		reindexCronSpec = overrideReindexCronSpec
-	}
-
-	return Config{
-		chatId:             int64(chatId),
-		allowedUserIds:     allowedUserIds,
-		botToken:           os.Getenv(keyBotToken),
-		photoCount:         parsedCount,
-		photoPath:          photoLibPath,
-		dbPath:             dbPath,
-		cronSpec:           cronSpec,
-		sendPhotosByNumber: sendPhotosByNumber,
-		debug:              debug,
-		memoriesCronSpec:   memoriesCronSpec,
-		memoriesPhotoCount: memoriesPhotoCount,
-		reindexCronSpec:    reindexCronSpec,
-		telegramProxyURL:   os.Getenv(keyTelegramProxyURL),
-		telegramProxyUser:  os.Getenv(keyTelegramProxyUser),
-		telegramProxyPass:  os.Getenv(keyTelegramProxyPass),
+// labelKey returns a label as a part of a setting name, e.g. FAMILY_PHOTOS for "family photos"
+func labelKey(label string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, label)
+}
+
+// secretConfigKeys are redacted when the config is printed
+var secretConfigKeys = map[string]bool{
+	keyBotToken:          true,
+	keyTelegramProxyPass: true,
+	keyWebhookSecret:     true,
+}
+
+// formatConfig lists settings with their sources, secrets are redacted
+func formatConfig(c Config) string {
+	var sb strings.Builder
+	for _, v := range c.settings {
+		value := v.value
+		if secretConfigKeys[v.key] && value != "" {
+			value = "********"
+		}
+		sb.WriteString(fmt.Sprintf("%s=%s (%s)\n", v.key, value, v.source))
 	}
+	return sb.String()
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileRoot is a photo root in the config file
+type configFileRoot struct {
+	Label   string   `yaml:"label"`
+	Path    string   `yaml:"path"`
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	Enabled *bool    `yaml:"enabled"`
+}
+
+// configFileSchedule is a schedule in the config file
+type configFileSchedule struct {
+	Name   string `yaml:"name"`
+	Cron   string `yaml:"cron"`
+	ChatID string `yaml:"chat_id"`
+	Count  string `yaml:"count"`
+	Source string `yaml:"source"`
+	Years  string `yaml:"years"`
+	Folder string `yaml:"folder"`
+}
+
+// readConfigFile reads a YAML config file. Settings are named as environment variables in lower case
+// without the FM_ prefix, e.g. photo_count is FM_PHOTO_COUNT. Lists are joined with ; as in the environment,
+// photo_roots is a list of roots with label, path, include, exclude and enabled and schedules is a list of
+// schedules with name, cron, chat_id, count, source, years and folder.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	settings := make(map[string]string)
+	if len(doc.Content) == 0 {
+		return settings, nil // Empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file %s must be a mapping of settings", path)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		name, value := root.Content[i].Value, root.Content[i+1]
+		key := configFileKey(name)
+
+		if value.Kind == yaml.SequenceNode && (name == "photo_roots" || name == "schedules") {
+			readList := readConfigFileRoots
+			if name == "schedules" {
+				readList = readConfigFileSchedules
+			}
+			if err := readList(value, settings); err != nil {
+				return nil, fmt.Errorf("error parsing %s of config file %s: %v", name, path, err)
+			}
+			continue
+		}
+
+		switch value.Kind {
+		case yaml.ScalarNode:
+			settings[key] = value.Value
+		case yaml.SequenceNode:
+			var items []string
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%s of config file %s must be a list of values (line %d)", name, path, item.Line)
+				}
+				items = append(items, item.Value)
+			}
+			settings[key] = strings.Join(items, ";")
+		default:
+			return nil, fmt.Errorf("%s of config file %s must be a value or a list (line %d)", name, path, value.Line)
+		}
+	}
+	return settings, nil
+}
+
+// readConfigFileRoots converts photo roots to FM_PHOTO_ROOTS and settings of each root
+func readConfigFileRoots(node *yaml.Node, settings map[string]string) error {
+	var roots []configFileRoot
+	if err := decodeStrict(node, &roots); err != nil {
+		return err
+	}
+
+	var entries []string
+	for _, root := range roots {
+		if root.Path == "" {
+			return fmt.Errorf("root %q has no path", root.Label)
+		}
+		if root.Label == "" {
+			root.Label = filepath.Base(filepath.Clean(root.Path))
+		}
+		if strings.ContainsAny(root.Label, "=;") || strings.Contains(root.Path, ";") {
+			return fmt.Errorf("root %q must not have = or ; in the label and ; in the path", root.Label)
+		}
+		entries = append(entries, root.Label+"="+root.Path)
+
+		if len(root.Include) > 0 {
+			settings[photoRootEnvKey(root.Label, "INCLUDE")] = strings.Join(root.Include, ";")
+		}
+		if len(root.Exclude) > 0 {
+			settings[photoRootEnvKey(root.Label, "EXCLUDE")] = strings.Join(root.Exclude, ";")
+		}
+		if root.Enabled != nil {
+			settings[photoRootEnvKey(root.Label, "ENABLED")] = strconv.FormatBool(*root.Enabled)
+		}
+	}
+	settings[keyPhotoRoots] = strings.Join(entries, ";")
+	return nil
+}
+
+// readConfigFileSchedules converts schedules to FM_SCHEDULES and settings of each schedule
+func readConfigFileSchedules(node *yaml.Node, settings map[string]string) error {
+	var schedules []configFileSchedule
+	if err := decodeStrict(node, &schedules); err != nil {
+		return err
+	}
+
+	var names []string
+	for _, s := range schedules {
+		if s.Name == "" || strings.Contains(s.Name, ";") {
+			return fmt.Errorf("schedule %q must have a name without ;", s.Name)
+		}
+		names = append(names, s.Name)
+
+		for setting, value := range map[string]string{"CRON": s.Cron, "CHAT_ID": s.ChatID, "COUNT": s.Count,
+			"SOURCE": s.Source, "YEARS": s.Years, "FOLDER": s.Folder} {
+			if value != "" {
+				settings[scheduleEnvKey(s.Name, setting)] = value
+			}
+		}
+	}
+	settings[keySchedules] = strings.Join(names, ";")
+	return nil
+}
+
+// decodeStrict decodes a node into out, rejecting unknown fields
+func decodeStrict(node *yaml.Node, out any) error {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	return decoder.Decode(out)
+}
+
+// configFileKey returns the environment name of a config file setting, e.g. FM_PHOTO_COUNT for photo_count
+func configFileKey(name string) string {
+	return "FM_" + strings.ToUpper(name)
+}
+
+// configFileName returns the config file name of a setting, e.g. photo_count for FM_PHOTO_COUNT
+func configFileName(key string) string {
+	return strings.ToLower(strings.TrimPrefix(key, "FM_"))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// configFilePath is the config file read on start and on reload, empty if the bot is configured by the environment
+var configFilePath string
+
+// configMu guards settings of cfg which are changed by reloading the config
+var configMu sync.RWMutex
+
+// reloadMu allows one reload at a time
+var reloadMu sync.Mutex
+
+// reloadableConfigKeys are settings applied by reloading the config, others are applied on restart.
+// Settings of schedules are reloaded too, see isReloadableConfigKey.
+var reloadableConfigKeys = map[string]bool{
+	keyChatId:             true,
+	keyAllowedUsers:       true,
+	keyOwnerUsers:         true,
+	keyViewerUsers:        true,
+	keyViewerPhotoLimit:   true,
+	keyAdminPhotoLimit:    true,
+	keyPhotoCount:         true,
+	keyCronSpec:           true,
+	keySendPhotosByNumber: true,
+	keyMemoriesCronSpec:   true,
+	keyMemoriesPhotoCount: true,
+	keyReindexCronSpec:    true,
+	keyChatTimeZones:      true,
+	keyRepeatWindowDays:   true,
+	keySchedules:          true,
+}
+
+// isReloadableConfigKey reports whether a setting is applied by reloading the config
+func isReloadableConfigKey(key string) bool {
+	return reloadableConfigKeys[key] || strings.HasPrefix(key, keySchedulePrefix)
+}
+
+// currentConfig returns the config with settings of the last reload.
+// Settings which can be reloaded are read with it while the bot is running.
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return cfg
+}
+
+// applyReloadableSettings copies settings which can be changed without a restart
+func applyReloadableSettings(dst *Config, src Config) {
+	dst.chatId = src.chatId
+	dst.allowedUserIds = src.allowedUserIds
+	dst.ownerUserIds = src.ownerUserIds
+	dst.viewerUserIds = src.viewerUserIds
+	dst.viewerPhotoLimit = src.viewerPhotoLimit
+	dst.adminPhotoLimit = src.adminPhotoLimit
+	dst.photoCount = src.photoCount
+	dst.cronSpec = src.cronSpec
+	dst.sendPhotosByNumber = src.sendPhotosByNumber
+	dst.memoriesCronSpec = src.memoriesCronSpec
+	dst.memoriesPhotoCount = src.memoriesPhotoCount
+	dst.reindexCronSpec = src.reindexCronSpec
+	dst.repeatWindowDays = src.repeatWindowDays
+	dst.schedules = src.schedules
+	dst.chatLocations = src.chatLocations
+}
+
+// configChange is a setting changed in the config file
+type configChange struct {
+	key     string
+	from    string
+	to      string
+	applied bool // False if the bot must be restarted to apply it
+}
+
+// diffConfig returns settings changed from old to new, secrets are redacted
+func diffConfig(old []configValue, new []configValue) []configChange {
+	oldValues := make(map[string]string)
+	for _, v := range old {
+		oldValues[v.key] = v.value
+	}
+	newValues := make(map[string]string)
+	for _, v := range new {
+		newValues[v.key] = v.value
+	}
+
+	var changes []configChange
+	add := func(key string) {
+		from, to := oldValues[key], newValues[key]
+		if from == to {
+			return
+		}
+		if secretConfigKeys[key] {
+			from, to = "********", "********"
+		}
+		changes = append(changes, configChange{key: key, from: from, to: to, applied: isReloadableConfigKey(key)})
+	}
+	for _, v := range new {
+		add(v.key)
+	}
+	for _, v := range old {
+		if _, ok := newValues[v.key]; !ok {
+			add(v.key)
+		}
+	}
+	return changes
+}
+
+// reloadedSettings returns settings after a reload, settings which need a restart keep their running values
+func reloadedSettings(old []configValue, new []configValue) []configValue {
+	oldValues := make(map[string]configValue)
+	for _, v := range old {
+		oldValues[v.key] = v
+	}
+	newKeys := make(map[string]bool)
+
+	var settings []configValue
+	for _, v := range new {
+		newKeys[v.key] = true
+		if !isReloadableConfigKey(v.key) {
+			running, ok := oldValues[v.key]
+			if !ok {
+				continue
+			}
+			v = running
+		}
+		settings = append(settings, v)
+	}
+	for _, v := range old {
+		if !newKeys[v.key] && !isReloadableConfigKey(v.key) {
+			settings = append(settings, v)
+		}
+	}
+	return settings
+}
+
+// reloadConfig reads the config file again and applies settings which can be changed without a restart.
+// An invalid config is rejected and the running config is kept.
+func reloadConfig() ([]configChange, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if configFilePath == "" {
+		return nil, fmt.Errorf("no config file to reload, set it with --config or %s", keyConfigFile)
+	}
+
+	loaded, err := loadConfig(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	running := currentConfig()
+	changes := diffConfig(running.settings, loaded.settings)
+
+	next := running
+	applyReloadableSettings(&next, loaded)
+	if scheduledJobs != nil {
+		if err := scheduledJobs.apply(next); err != nil {
+			return nil, err
+		}
+	}
+
+	configMu.Lock()
+	applyReloadableSettings(&cfg, loaded)
+	cfg.settings = reloadedSettings(running.settings, loaded.settings)
+	configMu.Unlock()
+
+	return changes, nil
+}
+
+// formatConfigReload describes the result of a reload
+func formatConfigReload(changes []configChange, err error) string {
+	if err != nil {
+		return fmt.Sprintf("❌ Config is not reloaded, the running config is kept:\n%v", err)
+	}
+	if len(changes) == 0 {
+		return "✅ Config is reloaded, nothing changed"
+	}
+
+	var applied, pending strings.Builder
+	for _, change := range changes {
+		line := fmt.Sprintf("\n%s: %q → %q", change.key, change.from, change.to)
+		if change.applied {
+			applied.WriteString(line)
+		} else {
+			pending.WriteString(line)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("✅ Config is reloaded")
+	if applied.Len() > 0 {
+		sb.WriteString("\n\nApplied:")
+		sb.WriteString(applied.String())
+	}
+	if pending.Len() > 0 {
+		sb.WriteString("\n\nApplied after a restart:")
+		sb.WriteString(pending.String())
+	}
+	return sb.String()
+}
+
+// reloadConfigAndReport reloads the config and sends the result to chatId
+func reloadConfigAndReport(chatId int64, replyMessageId int, bot Sender) {
+	changes, err := reloadConfig()
+	if err != nil {
+		log.Printf("Config reload rejected: %v", err)
+	} else {
+		log.Printf("Config reloaded, %d settings changed", len(changes))
+	}
+	sendSafeReplyText(chatId, replyMessageId, bot, formatConfigReload(changes, err))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/robfig/cron/v3"
+)
+
+const reloadTestConfig = `
+tg_bot_token: token
+chat_id: %d
+allowed_users_id: [%d]
+photo_count: %d
+send_photo_cron_spec: "%s"
+db_path: %s
+`
+
+func TestReloadConfig(t *testing.T) {
+	e := newTestEnv(t)
+	clearConfigEnv(t)
+
+	path := writeConfigFile(t, fmt.Sprintf(reloadTestConfig, testChatID, testUserID, 5, "0 10 * * *", "a.db"))
+	loaded, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.settings = loaded.settings
+	configFilePath = path
+	t.Cleanup(func() { configFilePath = "" })
+
+	jobs := newScheduler(cron.New(), e.bot)
+	if err := jobs.apply(loaded); err != nil {
+		t.Fatal(err)
+	}
+	scheduledJobs = jobs
+	t.Cleanup(func() { scheduledJobs = nil })
+
+	// Photo count and cron are applied, the DB path waits for a restart
+	writeTestFile(t, path, fmt.Sprintf(reloadTestConfig, testChatID, testUserID, 7, "30 9 * * *", "b.db"))
+	e.send(t, testUserID, "/reload")
+
+	report := strings.Join(texts(e.telegram.Calls("sendMessage")), "\n")
+	for _, want := range []string{"Applied:\nFM_PHOTO_COUNT: \"5\" → \"7\"", `FM_SEND_PHOTO_CRON_SPEC: "0 10 * * *" → "30 9 * * *"`,
+		"Applied after a restart:\nFM_DB_PATH: \"a.db\" → \"b.db\""} {
+		if !strings.Contains(report, want) {
+			t.Errorf("want %q in report, got %q", want, report)
+		}
+	}
+	if c := currentConfig(); c.photoCount != 7 || c.cronSpec != "30 9 * * *" || c.dbPath == "b.db" {
+		t.Errorf("want reloadable settings applied, got %+v", c)
+	}
+	if entry := jobs.entries["schedule photos"]; entry.spec != "30 9 * * *" || len(jobs.cron.Entries()) != 3 {
+		t.Errorf("want photos job re-registered, got %+v of %d jobs", entry, len(jobs.cron.Entries()))
+	}
+
+	// Invalid config is rejected and the running one is kept
+	e.telegram.Reset()
+	writeTestFile(t, path, fmt.Sprintf(reloadTestConfig, testChatID, testUserID, 0, "0 10 * * *", "b.db"))
+	e.send(t, testUserID, "/reload")
+
+	if !containsText(texts(e.telegram.Calls("sendMessage")), "Config is not reloaded") {
+		t.Errorf("want rejected reload, got %q", texts(e.telegram.Calls("sendMessage")))
+	}
+	if c := currentConfig(); c.photoCount != 7 || c.cronSpec != "30 9 * * *" {
+		t.Errorf("want running config kept, got %+v", c)
+	}
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
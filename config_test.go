@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearConfigEnv unsets bot settings of the environment for the test
+func clearConfigEnv(t *testing.T) {
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(key, "FM_") {
+			t.Setenv(key, "")
+		}
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFileWithEnvOverride(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, `
+tg_bot_token: file-token
+chat_id: -100123
+allowed_users_id: [1, 2]
+photo_count: 3
+send_photo_cron_spec: "0 9 * * *"
+watch_debounce: 30s
+photo_roots:
+  - label: family
+    path: /photos/family
+    exclude: ["*/Screenshots/*"]
+  - path: /photos/phone
+    enabled: false
+`)
+	t.Setenv("FM_PHOTO_COUNT", "7")
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.botToken != "file-token" || c.chatId != -100123 || len(c.allowedUserIds) != 2 || c.cronSpec != "0 9 * * *" {
+		t.Errorf("want settings of the file, got %+v", c)
+	}
+	if c.photoCount != 7 {
+		t.Errorf("want photo count of the environment, got %d", c.photoCount)
+	}
+	if c.memoriesCronSpec != "0 12 * * *" {
+		t.Errorf("want default memories cron, got %q", c.memoriesCronSpec)
+	}
+	if len(c.photoRoots) != 2 || c.photoRoots[0].label != "family" || len(c.photoRoots[0].excludeGlobs) != 1 ||
+		c.photoRoots[1].label != "phone" || c.photoRoots[1].enabled {
+		t.Errorf("want roots of the file, got %+v", c.photoRoots)
+	}
+}
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, `
+chat_id: abc
+photo_count: 0
+memories_cron_spec: "0 25 * * *"
+watch_mode: sometimes
+photo_cont: 3
+`)
+	t.Setenv("FM_UPDATE_MODE", "webhook")
+
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("want invalid config")
+	}
+	for _, want := range []string{"FM_TG_BOT_TOKEN is required", "FM_CHAT_ID must be a Telegram id",
+		"FM_PHOTO_COUNT must be a number of at least 1", "FM_MEMORIES_CRON_SPEC must be a cron spec",
+		"FM_WATCH_MODE must be one of auto, poll, off", "FM_WEBHOOK_URL is required in webhook mode",
+		"unknown setting photo_cont"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want %q in errors, got %v", want, err)
+		}
+	}
+}
+
+func TestPhotoCountAboveMediaGroupLimitIsClamped(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("FM_TG_BOT_TOKEN", "123:secret")
+	t.Setenv("FM_CHAT_ID", "42")
+	t.Setenv("FM_PHOTO_COUNT", "12")
+
+	c, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.photoCount != 10 {
+		t.Errorf("want photo count clamped to 10, got %d", c.photoCount)
+	}
+}
+
+func TestFormatConfigRedactsSecrets(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("FM_TG_BOT_TOKEN", "123:secret")
+	t.Setenv("FM_CHAT_ID", "42")
+	t.Setenv("FM_WEBHOOK_SECRET", "hidden")
+
+	c, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	printed := formatConfig(c)
+	if strings.Contains(printed, "123:secret") || strings.Contains(printed, "hidden") {
+		t.Errorf("want secrets redacted, got %s", printed)
+	}
+	for _, want := range []string{"FM_TG_BOT_TOKEN=******** (env)", "FM_PHOTO_COUNT=5 (default)",
+		"FM_PHOTO_ROOT_LIBRARY_ENABLED=true (default)"} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("want %q in config, got %s", want, printed)
+		}
+	}
+}
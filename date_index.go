@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Date indexes keep a nested bucket per date key ("MM-DD" or "YYYY-MM-DD") with one key per photo path,
+// so adding or removing a photo doesn't rewrite all paths of the day.
+// Schema version 1 stored a JSON array of paths per date key.
+const (
+	dateIndexSchemaVersion = 2
+	keyIndexSchemaVersion  = "IndexSchemaVersion" // Key for date index schema version in IndexingStats
+)
+
+// addToDateIndex adds path under key of the date index bucket
+func addToDateIndex(tx *bolt.Tx, bucketName string, key string, path string) error {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return fmt.Errorf("bucket %s not found", bucketName)
+	}
+
+	dateBucket, err := b.CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return err
+	}
+	return dateBucket.Put([]byte(path), []byte{})
+}
+
+// removeFromDateIndex removes path from key of the date index bucket, the date is removed with its last photo
+func removeFromDateIndex(tx *bolt.Tx, bucketName string, key string, path string) error {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return fmt.Errorf("bucket %s not found", bucketName)
+	}
+
+	dateBucket := b.Bucket([]byte(key))
+	if dateBucket == nil {
+		return nil
+	}
+
+	err := dateBucket.Delete([]byte(path))
+	if err != nil {
+		return err
+	}
+
+	if first, _ := dateBucket.Cursor().First(); first == nil {
+		return b.DeleteBucket([]byte(key))
+	}
+	return nil
+}
+
+// getDateIndexPaths returns paths under key of the date index bucket
+func getDateIndexPaths(tx *bolt.Tx, bucketName string, key string) ([]string, error) {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return nil, fmt.Errorf("bucket %s not found", bucketName)
+	}
+
+	dateBucket := b.Bucket([]byte(key))
+	if dateBucket == nil {
+		return nil, nil
+	}
+
+	var paths []string
+	err := dateBucket.ForEach(func(k, _ []byte) error {
+		paths = append(paths, string(k))
+		return nil
+	})
+	return paths, err
+}
+
+// migrateDateIndexes converts date indexes from JSON arrays to nested buckets
+func migrateDateIndexes(tx *bolt.Tx) error {
+	bStats := tx.Bucket([]byte(bucketIndexingStats))
+	if bStats == nil {
+		return fmt.Errorf("bucket %s not found", bucketIndexingStats)
+	}
+
+	version := 1
+	if versionBytes := bStats.Get([]byte(keyIndexSchemaVersion)); versionBytes != nil {
+		version, _ = strconv.Atoi(string(versionBytes))
+	}
+	if version >= dateIndexSchemaVersion {
+		return nil
+	}
+
+	for _, bucketName := range []string{bucketDateIndex, bucketYearDateIndex} {
+		migrated, err := migrateDateIndex(tx, bucketName)
+		if err != nil {
+			return fmt.Errorf("error migrating %s: %v", bucketName, err)
+		}
+		if migrated > 0 {
+			log.Printf("Migrated %d dates of %s to schema version %d", migrated, bucketName, dateIndexSchemaVersion)
+		}
+	}
+
+	return bStats.Put([]byte(keyIndexSchemaVersion), []byte(strconv.Itoa(dateIndexSchemaVersion)))
+}
+
+// migrateDateIndex moves JSON path lists of a bucket into nested buckets and returns the number of dates moved
+func migrateDateIndex(tx *bolt.Tx, bucketName string) (int, error) {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return 0, fmt.Errorf("bucket %s not found", bucketName)
+	}
+
+	// Collect lists first, buckets can't be modified while iterating
+	lists := make(map[string][]byte)
+	err := b.ForEach(func(k, v []byte) error {
+		// Nested buckets have no value, they are already migrated
+		if v != nil {
+			lists[string(k)] = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for key, data := range lists {
+		err := b.Delete([]byte(key))
+		if err != nil {
+			return 0, err
+		}
+
+		var paths []string
+		err = json.Unmarshal(data, &paths)
+		if err != nil {
+			// Photos of this date are listed again by /reindex verify or the next full reindexing
+			log.Printf("Dropping unreadable date list %s/%s: %v", bucketName, key, err)
+			continue
+		}
+
+		for _, path := range paths {
+			err := addToDateIndex(tx, bucketName, key, path)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(lists), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMigrateDateIndexesFromJSONLists(t *testing.T) {
+	newTestEnv(t)
+
+	// Database written by schema version 1
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(bucketIndexingStats)).Delete([]byte(keyIndexSchemaVersion)); err != nil {
+			return err
+		}
+		b := tx.Bucket([]byte(bucketDateIndex))
+		if err := b.Put([]byte("01-02"), []byte(`["/a.jpg","/b.jpg","/a.jpg"]`)); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("03-04"), []byte(`not json`)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(bucketYearDateIndex)).Put([]byte("2020-01-02"), []byte(`["/a.jpg"]`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitPhotoMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := pathList(t, bucketDateIndex, "01-02")
+	sort.Strings(got)
+	if strings.Join(got, ",") != "/a.jpg,/b.jpg" {
+		t.Errorf("want a and b once, got %v", got)
+	}
+	if got := pathList(t, bucketYearDateIndex, "2020-01-02"); len(got) != 1 {
+		t.Errorf("want year date index migrated, got %v", got)
+	}
+	if got := pathList(t, bucketDateIndex, "03-04"); len(got) != 0 {
+		t.Errorf("want unreadable list dropped, got %v", got)
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		version := tx.Bucket([]byte(bucketIndexingStats)).Get([]byte(keyIndexSchemaVersion))
+		if string(version) != "2" {
+			t.Errorf("want schema version 2, got %q", version)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDateIndexRemovesEmptyDates(t *testing.T) {
+	newTestEnv(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := addToDateIndex(tx, bucketDateIndex, "01-02", "/a.jpg"); err != nil {
+			return err
+		}
+		if err := removeFromDateIndex(tx, bucketDateIndex, "01-02", "/a.jpg"); err != nil {
+			return err
+		}
+		if tx.Bucket([]byte(bucketDateIndex)).Bucket([]byte("01-02")) != nil {
+			t.Error("want empty date removed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
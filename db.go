@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -59,6 +60,31 @@ func initDB(dbPath string) {
 			return err
 		}
 
+		_, err = tx.CreateBucketIfNotExists([]byte(bucketShowHistory))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(bucketFavorites))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(bucketSubscriptions))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(bucketUsers))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(bucketInvites))
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -122,7 +148,12 @@ func storeSending(ps PhotoSending) {
 
 		// Also map messageId -> numberOfSending
 		msgKey := strconv.Itoa(ps.MessageId)
-		return bMap.Put([]byte(msgKey), []byte(sendingKey))
+		if err := bMap.Put([]byte(msgKey), []byte(sendingKey)); err != nil {
+			return err
+		}
+
+		// Remember when the photos were shown, so they are not repeated soon
+		return recordPhotosShown(tx, ps.Photos, time.Now())
 	})
 	if err != nil {
 		log.Println("Error storing PhotoSending:", err)
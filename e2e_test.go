@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	testChatID  int64 = 100
+	testUserID  int64 = 200
+	otherUserID int64 = 300
+)
+
+// testEnv is a bot connected to fakeTelegram with a temporary photo library and database
+type testEnv struct {
+	telegram *fakeTelegram
+	bot      *tgbotapi.BotAPI
+	router   *CommandRouter
+	library  string
+	offset   int
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	dir := t.TempDir()
+	library := filepath.Join(dir, "library")
+	if err := os.MkdirAll(library, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	tempProcessedPhotoPath = filepath.Join(dir, "compressed")
+	if err := os.MkdirAll(tempProcessedPhotoPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = Config{
+		chatId:             testChatID,
+		allowedUserIds:     []int64{testUserID},
+		photoCount:         5,
+		photoRoots:         []photoRoot{newPhotoRoot(defaultPhotoRootLabel, library, nil, nil, true)},
+		dbPath:             filepath.Join(dir, "test.db"),
+		sendPhotosByNumber: true,
+		memoriesPhotoCount: 5,
+		indexWorkers:       2,
+	}
+
+	initDB(cfg.dbPath)
+	t.Cleanup(func() { _ = db.Close() })
+	if err := InitPhotoMetadata(); err != nil {
+		t.Fatalf("init photo metadata: %v", err)
+	}
+
+	// Photo requests of users are counted from zero in each test
+	photoRequests = newRequestLimiter(photoRequests.limit)
+
+	queue := newSendQueue(1)
+	sendJobs = queue
+	t.Cleanup(func() { queue.Close(10 * time.Second) })
+
+	telegram := newFakeTelegram(t)
+	return &testEnv{
+		telegram: telegram,
+		bot:      telegram.newBot(),
+		router:   newBotCommandRouter(),
+		library:  library,
+	}
+}
+
+// addPhoto writes a small JPEG to the library with modification time taken
+func (e *testEnv) addPhoto(t *testing.T, name string, taken time.Time) string {
+	t.Helper()
+
+	path := filepath.Join(e.library, name)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 16), B: 128, A: 255})
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(file, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, taken, taken); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// index runs differential indexing of the library and waits for it to complete
+func (e *testEnv) index(t *testing.T) {
+	t.Helper()
+
+	if err := StartDifferentialIndexing(context.Background(), cfg.photoRoots, 2, triggerUser); err != nil {
+		t.Fatalf("start indexing: %v", err)
+	}
+	waitForIndexing(t)
+}
+
+// send delivers a message from user to the bot through getUpdates and waits until it is handled
+func (e *testEnv) send(t *testing.T, userID int64, text string) {
+	t.Helper()
+	e.sendInChat(t, testChatID, userID, text)
+}
+
+// sendInChat delivers a message from user in chat to the bot and waits until it is handled
+func (e *testEnv) sendInChat(t *testing.T, chatID int64, userID int64, text string) {
+	t.Helper()
+
+	e.telegram.pushMessage(chatID, userID, text)
+
+	updates, err := e.bot.GetUpdates(tgbotapi.UpdateConfig{Offset: e.offset})
+	if err != nil {
+		t.Fatalf("get updates: %v", err)
+	}
+	for _, update := range updates {
+		e.offset = update.UpdateID + 1
+		handleUpdate(e.router, e.bot, update)
+	}
+	sendJobs.Flush()
+}
+
+// waitForIndexing waits until the indexing active flag is reset
+func waitForIndexing(t *testing.T) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		active, _, _, err := GetIndexingStatus()
+		if err != nil {
+			t.Fatalf("get indexing status: %v", err)
+		}
+		if !active {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("indexing did not complete in time")
+}
+
+// texts returns text of all sendMessage calls
+func texts(calls []fakeCall) []string {
+	var result []string
+	for _, call := range calls {
+		if call.Method == "sendMessage" {
+			result = append(result, call.Params.Get("text"))
+		}
+	}
+	return result
+}
+
+// mediaCount returns the number of photos in a sendMediaGroup call
+func mediaCount(t *testing.T, call fakeCall) int {
+	t.Helper()
+
+	var media []map[string]any
+	if err := json.Unmarshal([]byte(call.Params.Get("media")), &media); err != nil {
+		t.Fatalf("parse media: %v", err)
+	}
+	return len(media)
+}
+
+func containsText(texts []string, substr string) bool {
+	for _, text := range texts {
+		if strings.Contains(text, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCommandsEndToEnd(t *testing.T) {
+	now := time.Now()
+	yearAgo := time.Date(now.Year()-1, now.Month(), now.Day(), 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		user     int64
+		messages []string
+		check    func(t *testing.T, e *testEnv, calls []fakeCall)
+	}{
+		{
+			name:     "photo sends media group",
+			user:     testUserID,
+			messages: []string{"/photo 2"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				groups := e.telegram.Calls("sendMediaGroup")
+				if len(groups) != 1 {
+					t.Fatalf("want 1 media group, got %d", len(groups))
+				}
+				if got := mediaCount(t, groups[0]); got != 2 {
+					t.Errorf("want 2 photos, got %d", got)
+				}
+				if groups[0].Files != 2 {
+					t.Errorf("want 2 uploaded files, got %d", groups[0].Files)
+				}
+			},
+		},
+		{
+			name:     "photo by number",
+			user:     testUserID,
+			messages: []string{"3"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				groups := e.telegram.Calls("sendMediaGroup")
+				if len(groups) != 1 || mediaCount(t, groups[0]) != 3 {
+					t.Fatalf("want 1 media group with 3 photos, got %v", groups)
+				}
+			},
+		},
+		{
+			name:     "photo with invalid count",
+			user:     testUserID,
+			messages: []string{"/photo abc"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				if !containsText(texts(calls), "Please send a number") {
+					t.Errorf("want validation reply, got %q", texts(calls))
+				}
+				if len(e.telegram.Calls("sendMediaGroup")) != 0 {
+					t.Error("want no media group")
+				}
+			},
+		},
+		{
+			name:     "info about photo from last sending",
+			user:     testUserID,
+			messages: []string{"/photo 2", "/info 2"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				got := texts(calls)
+				if !containsText(got, "Photo #2 from sending #1:") {
+					t.Errorf("want info header, got %q", got)
+				}
+				if !containsText(got, "📂 "+e.library) {
+					t.Errorf("want photo path, got %q", got)
+				}
+				if !containsText(got, "🗂 "+defaultPhotoRootLabel) {
+					t.Errorf("want photo root label, got %q", got)
+				}
+			},
+		},
+		{
+			name:     "info with out of range number",
+			user:     testUserID,
+			messages: []string{"/photo 1", "/info 5"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				if !containsText(texts(calls), "Invalid photo number. Last sending (#1) had 1 photos.") {
+					t.Errorf("want range error, got %q", texts(calls))
+				}
+			},
+		},
+		{
+			name:     "memories from a year ago",
+			user:     testUserID,
+			messages: []string{"/memories"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				groups := e.telegram.Calls("sendMediaGroup")
+				if len(groups) != 1 {
+					t.Fatalf("want 1 media group, got %d", len(groups))
+				}
+				if got := mediaCount(t, groups[0]); got != 1 {
+					t.Errorf("want 1 photo from a year ago, got %d", got)
+				}
+				if !strings.Contains(groups[0].Params.Get("media"), "1 years ago") {
+					t.Errorf("want memories caption, got %s", groups[0].Params.Get("media"))
+				}
+			},
+		},
+		{
+			name:     "memories without photos",
+			user:     testUserID,
+			messages: []string{"/memories 7"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				if !containsText(texts(calls), "No photos found taken 7 years ago on this day") {
+					t.Errorf("want not found reply, got %q", texts(calls))
+				}
+			},
+		},
+		{
+			name:     "reindex diff",
+			user:     testUserID,
+			messages: []string{"/reindex diff"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				if !containsText(texts(calls), "Differential photo indexing started") {
+					t.Errorf("want reindex reply, got %q", texts(calls))
+				}
+				waitForIndexing(t)
+			},
+		},
+		{
+			name:     "reindex pause without indexing",
+			user:     testUserID,
+			messages: []string{"/reindex pause"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				if !containsText(texts(calls), "Cannot pause indexing: there is no indexing to stop") {
+					t.Errorf("want pause error, got %q", texts(calls))
+				}
+			},
+		},
+		{
+			name:     "reindex without type",
+			user:     testUserID,
+			messages: []string{"/reindex"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				if !containsText(texts(calls), "Usage: /reindex") {
+					t.Errorf("want usage reply, got %q", texts(calls))
+				}
+			},
+		},
+		{
+			name:     "not allowed user",
+			user:     otherUserID,
+			messages: []string{"/photo 2", "/memories"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				if len(calls) != 0 {
+					t.Errorf("want no calls, got %v", calls)
+				}
+			},
+		},
+		{
+			name:     "history of sent photos",
+			user:     testUserID,
+			messages: []string{"/photo 2", "/history"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				if !containsText(texts(calls), "Photos sent at least once: 2") {
+					t.Errorf("want history, got %q", texts(calls))
+				}
+			},
+		},
+		{
+			name:     "history reset",
+			user:     testUserID,
+			messages: []string{"/photo 2", "/history reset", "/history"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				got := texts(calls)
+				if !containsText(got, "History of sent photos is cleared") ||
+					!containsText(got, "Photos sent at least once: 0") {
+					t.Errorf("want history cleared, got %q", got)
+				}
+			},
+		},
+		{
+			name:     "help lists commands",
+			user:     otherUserID,
+			messages: []string{"/help"},
+			check: func(t *testing.T, e *testEnv, calls []fakeCall) {
+				got := texts(calls)
+				if !containsText(got, "/reindex full") || !containsText(got, "/photo N") {
+					t.Errorf("want help text, got %q", got)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			e.addPhoto(t, "2020/a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+			e.addPhoto(t, "2020/b.jpg", time.Date(2020, 1, 2, 11, 0, 0, 0, time.Local))
+			e.addPhoto(t, "2021/c.jpg", time.Date(2021, 5, 6, 10, 0, 0, 0, time.Local))
+			e.addPhoto(t, "last-year.jpg", yearAgo)
+			e.index(t)
+			e.telegram.Reset()
+
+			for _, message := range tt.messages {
+				e.send(t, tt.user, message)
+			}
+
+			var calls []fakeCall
+			for _, call := range e.telegram.Calls("") {
+				if call.Method != "getUpdates" {
+					calls = append(calls, call)
+				}
+			}
+			tt.check(t, e, calls)
+		})
+	}
+}
+
+func TestReindexDiffPicksUpNewPhotos(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "old.jpg", time.Date(2019, 3, 4, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	newPhoto := e.addPhoto(t, "new.jpg", time.Date(2022, 3, 4, 10, 0, 0, 0, time.Local))
+	e.send(t, testUserID, "/reindex diff")
+	waitForIndexing(t)
+
+	var photos []string
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		photos, err = getDateIndexPaths(tx, bucketDateIndex, "03-04")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(photos, newPhoto) {
+		t.Errorf("want %s in date index, got %v", newPhoto, photos)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+const fakeBotToken = "123456:TEST"
+
+// fakeCall is a request received by fakeTelegram
+type fakeCall struct {
+	Method string
+	Params url.Values
+	Files  int // Number of uploaded files
+
+	FileNames  map[string]string // Uploaded file name by form field
+	MessageIDs []int             // IDs of messages created by the call
+}
+
+// fakeTelegram is an in-process Bot API server recording all calls
+type fakeTelegram struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu            sync.Mutex
+	calls         []fakeCall
+	nextMessageID int
+	nextUpdateID  int
+	updates       []tgbotapi.Update
+	failures      map[string][]fakeFailure
+}
+
+// fakeFailure is an error response returned instead of the next successful one
+type fakeFailure struct {
+	code        int
+	description string
+	retryAfter  int
+}
+
+// newFakeTelegram starts the fake Bot API server, it is stopped on test cleanup
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	t.Helper()
+
+	f := &fakeTelegram{t: t, nextMessageID: 1, nextUpdateID: 1, failures: make(map[string][]fakeFailure)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// newBot creates a bot client connected to the fake server
+func (f *fakeTelegram) newBot() *tgbotapi.BotAPI {
+	f.t.Helper()
+
+	bot, err := tgbotapi.NewBotAPIWithClient(fakeBotToken, f.server.URL+"/bot%s/%s", f.server.Client())
+	if err != nil {
+		f.t.Fatalf("create bot: %v", err)
+	}
+	return bot
+}
+
+// pushMessage queues a message from user for getUpdates and returns it
+func (f *fakeTelegram) pushMessage(chatID, userID int64, text string) tgbotapi.Update {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	message := &tgbotapi.Message{
+		MessageID: f.nextMessageID,
+		From:      &tgbotapi.User{ID: userID, UserName: "user" + strconv.FormatInt(userID, 10)},
+		Chat:      tgbotapi.Chat{ID: chatID},
+		Text:      text,
+	}
+	f.nextMessageID++
+
+	if strings.HasPrefix(text, "/") {
+		command := strings.Fields(text)[0]
+		message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+
+	update := tgbotapi.Update{UpdateID: f.nextUpdateID, Message: message}
+	f.nextUpdateID++
+	f.updates = append(f.updates, update)
+	return update
+}
+
+// failNext makes the next call of method fail with the given error
+func (f *fakeTelegram) failNext(method string, code int, description string, retryAfter int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method] = append(f.failures[method], fakeFailure{code, description, retryAfter})
+}
+
+// Calls returns recorded calls of the given method, or all calls if method is empty
+func (f *fakeTelegram) Calls(method string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var calls []fakeCall
+	for _, call := range f.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets recorded calls
+func (f *fakeTelegram) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+func (f *fakeTelegram) handle(w http.ResponseWriter, r *http.Request) {
+	// Path is /bot<token>/<method>
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "bot"+fakeBotToken {
+		f.writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	method := parts[1]
+
+	call := fakeCall{Method: method}
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		f.writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	call.Params = r.Form
+	if r.MultipartForm != nil {
+		call.FileNames = make(map[string]string)
+		for field, files := range r.MultipartForm.File {
+			call.Files += len(files)
+			for _, file := range files {
+				call.FileNames[field] = file.Filename
+			}
+		}
+	}
+
+	f.mu.Lock()
+	callIndex := len(f.calls)
+	f.calls = append(f.calls, call)
+	var failure *fakeFailure
+	if failures := f.failures[method]; len(failures) > 0 {
+		failure = &failures[0]
+		f.failures[method] = failures[1:]
+	}
+	f.mu.Unlock()
+
+	if failure != nil {
+		resp := tgbotapi.APIResponse{Ok: false, ErrorCode: failure.code, Description: failure.description}
+		if failure.retryAfter > 0 {
+			resp.Parameters = &tgbotapi.ResponseParameters{RetryAfter: failure.retryAfter}
+		}
+		f.writeResponse(w, failure.code, resp)
+		return
+	}
+
+	chatID, _ := strconv.ParseInt(call.Params.Get("chat_id"), 10, 64)
+
+	switch method {
+	case "getMe":
+		f.writeResult(w, tgbotapi.User{ID: 1, IsBot: true, UserName: "photo_moments_test_bot"})
+
+	case "getUpdates":
+		offset, _ := strconv.Atoi(call.Params.Get("offset"))
+		f.mu.Lock()
+		var updates []tgbotapi.Update
+		for _, update := range f.updates {
+			if update.UpdateID >= offset {
+				updates = append(updates, update)
+			}
+		}
+		f.mu.Unlock()
+		f.writeResult(w, updates)
+
+	case "sendMessage", "sendLocation":
+		f.writeResult(w, f.newMessage(chatID, call.Params.Get("text")))
+
+	case "editMessageText":
+		messageID, _ := strconv.Atoi(call.Params.Get("message_id"))
+		f.writeResult(w, tgbotapi.Message{MessageID: messageID, Chat: tgbotapi.Chat{ID: chatID},
+			Text: call.Params.Get("text")})
+
+	case "sendMediaGroup":
+		var media []map[string]any
+		if err := json.Unmarshal([]byte(call.Params.Get("media")), &media); err != nil {
+			f.writeError(w, http.StatusBadRequest, "Bad Request: can't parse media JSON object")
+			return
+		}
+		var messages []tgbotapi.Message
+		for range media {
+			messages = append(messages, f.newMessage(chatID, ""))
+		}
+		f.mu.Lock()
+		for _, message := range messages {
+			f.calls[callIndex].MessageIDs = append(f.calls[callIndex].MessageIDs, message.MessageID)
+		}
+		f.mu.Unlock()
+		f.writeResult(w, messages)
+
+	default:
+		f.writeResult(w, true)
+	}
+}
+
+func (f *fakeTelegram) newMessage(chatID int64, text string) tgbotapi.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	message := tgbotapi.Message{MessageID: f.nextMessageID, Chat: tgbotapi.Chat{ID: chatID}, Text: text}
+	f.nextMessageID++
+	return message
+}
+
+func (f *fakeTelegram) writeResult(w http.ResponseWriter, result any) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		f.t.Errorf("marshal result: %v", err)
+	}
+	f.writeResponse(w, http.StatusOK, tgbotapi.APIResponse{Ok: true, Result: data})
+}
+
+func (f *fakeTelegram) writeError(w http.ResponseWriter, status int, description string) {
+	f.writeResponse(w, status, tgbotapi.APIResponse{Ok: false, ErrorCode: status, Description: description})
+}
+
+func (f *fakeTelegram) writeResponse(w http.ResponseWriter, status int, resp tgbotapi.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+	bolt "go.etcd.io/bbolt"
+)
+
+const bucketFavorites = "FavoritePhotos" // Photo path -> time it was added to favorites
+
+// toggleFavorite adds the photo to favorites or removes it if it is already there.
+// It returns whether the photo is a favorite now.
+func toggleFavorite(path string) (bool, error) {
+	var favorite bool
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketFavorites))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketFavorites)
+		}
+
+		if b.Get([]byte(path)) != nil {
+			return b.Delete([]byte(path))
+		}
+		favorite = true
+		return b.Put([]byte(path), []byte(time.Now().Format(time.RFC3339)))
+	})
+
+	if err != nil {
+		return false, err
+	}
+	return favorite, nil
+}
+
+// getFavoritePhotos returns paths of favorite photos
+func getFavoritePhotos() ([]string, error) {
+	var photos []string
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketFavorites))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketFavorites)
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			photos = append(photos, string(k))
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return photos, nil
+}
+
+// selectFavoritePhotoPaths picks count favorite photos, those not shown recently first
+func selectFavoritePhotoPaths(count int) []string {
+	favorites, err := getFavoritePhotos()
+	if err != nil {
+		log.Printf("Error getting favorite photos: %v", err)
+		return nil
+	}
+	return selectExistingPhotos(favorites, count)
+}
+
+func parseFavoriteArgs(message *tgbotapi.Message) (any, error) {
+	arg := strings.TrimSpace(message.CommandArguments())
+
+	if message.ReplyToMessage != nil && arg == "" {
+		return infoArgs{replyToPhoto: true}, nil
+	}
+
+	// "/favorite 2" is the 2nd photo from the last sending
+	if arg != "" {
+		photoIndex, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, errors.New("Please provide a valid number, e.g. /favorite 2.")
+		}
+		return infoArgs{photoIndex: photoIndex}, nil
+	}
+
+	return nil, errors.New("Please specify a photo number or reply to a specific photo with /favorite.")
+}
+
+// handleFavoriteCommand adds a sent photo to favorites or removes it
+func handleFavoriteCommand(bot Sender, update tgbotapi.Update, args any) {
+	chatID, messageID := update.Message.Chat.ID, update.Message.MessageID
+
+	path, err := sentPhotoPath(update, args.(infoArgs))
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, err.Error())
+		return
+	}
+
+	favorite, err := toggleFavorite(path)
+	switch {
+	case err != nil:
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error updating favorites: %v", err))
+	case favorite:
+		sendSafeReplyText(chatID, messageID, bot, "⭐️ Added to favorites: "+path)
+	default:
+		sendSafeReplyText(chatID, messageID, bot, "Removed from favorites: "+path)
+	}
+}
+
+// sentPhotoPath returns the photo the message replies to or the photo with the number in the last sending
+func sentPhotoPath(update tgbotapi.Update, args infoArgs) (string, error) {
+	if args.replyToPhoto {
+		meta, err := getPhotoMsgMetaById(update.Message.ReplyToMessage.MessageID)
+		if err != nil {
+			return "", errors.New("No info found for this photo. Possibly not from the last group or DB error.")
+		}
+		return meta.PhotoPath, nil
+	}
+
+	lastNumber, err := getLastSendingNumber()
+	if err != nil {
+		return "", errors.New("No sendings found in DB.")
+	}
+	ps, err := getSendingByNumber(lastNumber)
+	if err != nil {
+		return "", errors.New("Could not load record for last sending.")
+	}
+	if args.photoIndex < 1 || args.photoIndex > len(ps.Photos) {
+		return "", fmt.Errorf("Invalid photo number. Last sending (#%d) had %d photos.", lastNumber, len(ps.Photos))
+	}
+	return ps.Photos[args.photoIndex-1].Path, nil
+}
@@ -4,9 +4,11 @@ go 1.23
 
 require (
 	github.com/OvyFlash/telegram-bot-api v0.0.0-20241219171906-3f2ca0c14ada
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/h2non/bimg v1.1.9
 	github.com/robfig/cron/v3 v3.0.1
 	go.etcd.io/bbolt v1.3.11
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.4.0 // indirect
+require golang.org/x/sys v0.13.0 // indirect
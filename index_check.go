@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// IndexCheckReport lists problems found in the date and photo ID indices
+type IndexCheckReport struct {
+	Photos      int // Photos with metadata
+	Orphans     int // Paths listed under a date without photo metadata
+	Stale       int // Paths listed under a date other than the date in their metadata
+	Missing     int // Photos not listed under the date in their metadata
+	BrokenLists int // Date entries in an unknown format, e.g. left from a failed migration
+	IDProblems  int // Photos missing from the photo ID index or IDs of removed photos
+	Repaired    bool
+}
+
+// Problems returns the total number of problems found
+func (r *IndexCheckReport) Problems() int {
+	return r.Orphans + r.Stale + r.Missing + r.BrokenLists + r.IDProblems
+}
+
+// dateIndexSpec describes a date index bucket and how its key is built from metadata
+type dateIndexSpec struct {
+	bucket string
+	key    func(metadata *PhotoMetadata) string
+}
+
+var dateIndexSpecs = []dateIndexSpec{
+	{bucketDateIndex, dateIndexKey},
+	{bucketYearDateIndex, yearDateIndexKey},
+}
+
+// CheckIndex compares the date and photo ID indices with photo metadata.
+// If repair is set and problems are found, the indices are rebuilt from photo metadata.
+func CheckIndex(repair bool) (*IndexCheckReport, error) {
+	report := &IndexCheckReport{}
+
+	check := func(tx *bolt.Tx) error {
+		metadataByPath, err := loadAllMetadata(tx)
+		if err != nil {
+			return err
+		}
+		report.Photos = len(metadataByPath)
+
+		for _, spec := range dateIndexSpecs {
+			err := checkDateIndex(tx, spec, metadataByPath, report)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = checkPhotoIDIndex(tx, metadataByPath, report)
+		if err != nil {
+			return err
+		}
+
+		if !repair || report.Problems() == 0 {
+			return nil
+		}
+
+		err = rebuildIndices(tx, metadataByPath)
+		if err != nil {
+			return fmt.Errorf("error rebuilding indices: %v", err)
+		}
+		report.Repaired = true
+		return nil
+	}
+
+	var err error
+	if repair {
+		err = db.Update(check)
+	} else {
+		err = db.View(check)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Index check: %d photos, %d problems, repaired: %v", report.Photos, report.Problems(), report.Repaired)
+	return report, nil
+}
+
+// loadAllMetadata reads metadata of all indexed photos
+func loadAllMetadata(tx *bolt.Tx) (map[string]*PhotoMetadata, error) {
+	b := tx.Bucket([]byte(bucketPhotoMetadata))
+	if b == nil {
+		return nil, fmt.Errorf("bucket %s not found", bucketPhotoMetadata)
+	}
+
+	metadataByPath := make(map[string]*PhotoMetadata)
+	err := b.ForEach(func(k, v []byte) error {
+		var metadata PhotoMetadata
+		err := json.Unmarshal(v, &metadata)
+		if err != nil {
+			return fmt.Errorf("error unmarshaling metadata of %s: %v", k, err)
+		}
+		metadataByPath[string(k)] = &metadata
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metadataByPath, nil
+}
+
+// checkDateIndex counts orphaned, stale and missing entries of a date index
+func checkDateIndex(tx *bolt.Tx, spec dateIndexSpec, metadataByPath map[string]*PhotoMetadata,
+	report *IndexCheckReport) error {
+	b := tx.Bucket([]byte(spec.bucket))
+	if b == nil {
+		return fmt.Errorf("bucket %s not found", spec.bucket)
+	}
+
+	listed := make(map[string]bool)
+	err := b.ForEach(func(k, v []byte) error {
+		dateBucket := b.Bucket(k)
+		if v != nil || dateBucket == nil {
+			log.Printf("Index check: unexpected value %s/%s", spec.bucket, k)
+			report.BrokenLists++
+			return nil
+		}
+
+		return dateBucket.ForEach(func(pathKey, _ []byte) error {
+			path := string(pathKey)
+			metadata, indexed := metadataByPath[path]
+			switch {
+			case !indexed:
+				report.Orphans++
+			case spec.key(metadata) != string(k):
+				report.Stale++
+			default:
+				listed[path] = true
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	report.Missing += len(metadataByPath) - len(listed)
+	return nil
+}
+
+// checkPhotoIDIndex counts photos without an ID and IDs not matching a photo
+func checkPhotoIDIndex(tx *bolt.Tx, metadataByPath map[string]*PhotoMetadata, report *IndexCheckReport) error {
+	bIDs := tx.Bucket([]byte(bucketPhotoIDs))
+	bIDByPath := tx.Bucket([]byte(bucketPhotoIDByPath))
+	if bIDs == nil || bIDByPath == nil {
+		return fmt.Errorf("photo ID buckets not found")
+	}
+
+	withID := 0
+	total := photoIDCount(bIDs)
+	for id := uint64(0); id < total; id++ {
+		path := bIDs.Get(photoIDKey(id))
+		_, indexed := metadataByPath[string(path)]
+		if path == nil || !indexed || string(bIDByPath.Get(path)) != string(photoIDKey(id)) {
+			report.IDProblems++
+			continue
+		}
+		withID++
+	}
+
+	report.IDProblems += len(metadataByPath) - withID
+	return nil
+}
+
+// rebuildIndices recreates the date and photo ID indices from photo metadata
+func rebuildIndices(tx *bolt.Tx, metadataByPath map[string]*PhotoMetadata) error {
+	for _, bucketName := range []string{bucketDateIndex, bucketYearDateIndex, bucketPhotoIDs, bucketPhotoIDByPath} {
+		err := tx.DeleteBucket([]byte(bucketName))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return fmt.Errorf("error deleting bucket %s: %v", bucketName, err)
+		}
+
+		_, err = tx.CreateBucket([]byte(bucketName))
+		if err != nil {
+			return fmt.Errorf("error creating bucket %s: %v", bucketName, err)
+		}
+	}
+
+	// Sorted paths give the same IDs on every rebuild
+	paths := make([]string, 0, len(metadataByPath))
+	for path := range metadataByPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		err := addToDateIndices(tx, metadataByPath[path])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, path := range paths {
+		err := addPhotoID(tx, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
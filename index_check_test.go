@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// pathList returns the paths stored under key in bucket
+func pathList(t *testing.T, bucket, key string) []string {
+	t.Helper()
+
+	var paths []string
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		paths, err = getDateIndexPaths(tx, bucket, key)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestSavePhotoMetadataReplacesDateEntries(t *testing.T) {
+	newTestEnv(t)
+
+	metadata := &PhotoMetadata{Path: "/a.jpg", Year: 2020, Month: 1, Day: 2}
+	for i := 0; i < 2; i++ {
+		if err := savePhotoMetadata(metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := pathList(t, bucketDateIndex, "01-02"); len(got) != 1 {
+		t.Errorf("want photo listed once after re-indexing, got %v", got)
+	}
+
+	// EXIF date changed
+	if err := savePhotoMetadata(&PhotoMetadata{Path: "/a.jpg", Year: 2021, Month: 3, Day: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if got := pathList(t, bucketDateIndex, "01-02"); len(got) != 0 {
+		t.Errorf("want old date entry removed, got %v", got)
+	}
+	if got := pathList(t, bucketYearDateIndex, "2020-01-02"); len(got) != 0 {
+		t.Errorf("want old year date entry removed, got %v", got)
+	}
+	if got := pathList(t, bucketYearDateIndex, "2021-03-04"); len(got) != 1 {
+		t.Errorf("want new year date entry, got %v", got)
+	}
+}
+
+func TestCheckIndexReportsAndRepairs(t *testing.T) {
+	newTestEnv(t)
+
+	for _, metadata := range []*PhotoMetadata{
+		{Path: "/a.jpg", Year: 2020, Month: 1, Day: 2},
+		{Path: "/b.jpg", Year: 2020, Month: 1, Day: 2},
+		{Path: "/c.jpg", Year: 2021, Month: 5, Day: 6},
+	} {
+		if err := savePhotoMetadata(metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Index with a deleted photo, a stale date, a missing photo and a leftover JSON list
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := addToDateIndex(tx, bucketDateIndex, "01-02", "/deleted.jpg"); err != nil {
+			return err
+		}
+		if err := addToDateIndex(tx, bucketDateIndex, "12-31", "/c.jpg"); err != nil {
+			return err
+		}
+		if err := removeFromDateIndex(tx, bucketDateIndex, "05-06", "/c.jpg"); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(bucketDateIndex)).Put([]byte("07-08"), []byte(`["/a.jpg"]`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := CheckIndex(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := IndexCheckReport{Photos: 3, Orphans: 1, Stale: 1, Missing: 1, BrokenLists: 1}
+	if *report != want {
+		t.Fatalf("want report %+v, got %+v", want, *report)
+	}
+
+	report, err = CheckIndex(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Repaired {
+		t.Error("want index repaired")
+	}
+
+	report, err = CheckIndex(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Problems() != 0 {
+		t.Errorf("want no problems after repair, got %+v", *report)
+	}
+	if got := pathList(t, bucketDateIndex, "05-06"); len(got) != 1 || got[0] != "/c.jpg" {
+		t.Errorf("want c.jpg back under its date, got %v", got)
+	}
+	assertPhotoIDIndex(t, []string{"/a.jpg", "/b.jpg", "/c.jpg"})
+}
+
+func TestReindexVerifyCommand(t *testing.T) {
+	e := newTestEnv(t)
+	photo := e.addPhoto(t, "a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	// Entry of a photo deleted from the index
+	err := db.Update(func(tx *bolt.Tx) error {
+		return addToDateIndex(tx, bucketDateIndex, "01-02", photo+".deleted")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.telegram.Reset()
+	e.send(t, testUserID, "/reindex verify")
+
+	got := texts(e.telegram.Calls("sendMessage"))
+	if !containsText(got, "Entries of photos not in index: 1") || !containsText(got, "Indexes repaired") {
+		t.Errorf("want repair report, got %q", got)
+	}
+	if list := pathList(t, bucketDateIndex, "01-02"); len(list) != 1 {
+		t.Errorf("want orphaned entry removed, got %v", list)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	bucketIndexingHistory = "IndexingHistory" // Bucket for reports of indexing runs (sequence -> IndexingReport)
+
+	indexingHistoryLimit     = 100 // Reports kept, older ones are removed
+	indexingFailedFilesLimit = 100 // Failed files kept per report, counters include all of them
+)
+
+// Indexing types
+const (
+	indexTypeFull  = "full"
+	indexTypeDiff  = "diff"
+	indexTypeWatch = "watch" // Changes applied by the library watcher
+)
+
+// What started indexing
+const (
+	triggerStartup = "startup"
+	triggerUser    = "user"
+	triggerCron    = "cron"
+	triggerWatcher = "watcher"
+)
+
+// indexOutcome is what indexing did with a photo
+type indexOutcome int
+
+const (
+	outcomeSkipped indexOutcome = iota // Not changed since it was indexed
+	outcomeAdded
+	outcomeUpdated
+)
+
+// FailedFile is a photo which could not be indexed or was indexed without EXIF
+type FailedFile struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// IndexingReport is the record of an indexing run
+type IndexingReport struct {
+	ID          uint64       `json:"id"`
+	Type        string       `json:"type"`
+	Trigger     string       `json:"trigger"`
+	State       string       `json:"state"` // Final state of the indexing job after the run
+	StartedAt   time.Time    `json:"startedAt"`
+	FinishedAt  time.Time    `json:"finishedAt"`
+	Added       int          `json:"added"`
+	Updated     int          `json:"updated"`
+	Skipped     int          `json:"skipped"`
+	Failed      int          `json:"failed"`
+	Removed     int          `json:"removed"`
+	NoExif      int          `json:"noExif"` // Photos indexed without EXIF, dated by file modification time
+	FailedFiles []FailedFile `json:"failedFiles,omitempty"`
+
+	mu sync.Mutex // Workers count outcomes concurrently
+}
+
+func newIndexingReport(indexType string, trigger string) *IndexingReport {
+	return &IndexingReport{Type: indexType, Trigger: trigger, StartedAt: time.Now()}
+}
+
+// count records what was done with a photo, photos whose EXIF could not be read are listed as failed
+func (r *IndexingReport) count(outcome indexOutcome, metadata *PhotoMetadata) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch outcome {
+	case outcomeAdded:
+		r.Added++
+	case outcomeUpdated:
+		r.Updated++
+	default:
+		r.Skipped++
+	}
+
+	if metadata != nil && metadata.exifError != nil {
+		r.NoExif++
+		r.addFailedFile(metadata.Path, fmt.Sprintf("EXIF: %v", metadata.exifError))
+	}
+}
+
+// fail records a photo which could not be indexed
+func (r *IndexingReport) fail(path string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Failed++
+	r.addFailedFile(path, err.Error())
+}
+
+// removed records photos removed from the index
+func (r *IndexingReport) removed(count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Removed += count
+}
+
+func (r *IndexingReport) addFailedFile(path string, reason string) {
+	if len(r.FailedFiles) < indexingFailedFilesLimit {
+		r.FailedFiles = append(r.FailedFiles, FailedFile{Path: path, Error: reason})
+	}
+}
+
+// Changes returns the number of photos added, updated, removed or failed
+func (r *IndexingReport) Changes() int {
+	return r.Added + r.Updated + r.Removed + r.Failed + r.NoExif
+}
+
+// saveIndexingReport adds the finished run to the history and removes reports above the limit.
+// Completed watcher runs without changes are not saved, frequent polls would push other runs out of the history.
+func saveIndexingReport(report *IndexingReport, state string) {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	if report.Trigger == triggerWatcher && state == jobCompleted && report.Changes() == 0 {
+		return
+	}
+
+	report.State = state
+	report.FinishedAt = time.Now()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingHistory))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingHistory)
+		}
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		report.ID = id
+
+		data, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("error marshaling indexing report: %v", err)
+		}
+		err = b.Put(indexingReportKey(id), data)
+		if err != nil {
+			return err
+		}
+
+		// Keys are in ID order, the oldest reports come first
+		var keys [][]byte
+		err = b.ForEach(func(k, _ []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for len(keys) > indexingHistoryLimit {
+			err := b.Delete(keys[0])
+			if err != nil {
+				return err
+			}
+			keys = keys[1:]
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("Error saving indexing report: %v", err)
+		return
+	}
+
+	log.Printf("Indexing report #%d: %s (%s) %s, %d added, %d updated, %d skipped, %d failed, %d removed, %d without EXIF",
+		report.ID, report.Type, report.Trigger, report.State, report.Added, report.Updated, report.Skipped,
+		report.Failed, report.Removed, report.NoExif)
+}
+
+// indexingReportKey is a big-endian ID, so reports are ordered by ID
+func indexingReportKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// GetIndexingReports returns up to limit latest reports, newest first
+func GetIndexingReports(limit int) ([]*IndexingReport, error) {
+	var reports []*IndexingReport
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingHistory))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingHistory)
+		}
+
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(reports) < limit; k, v = c.Prev() {
+			report := &IndexingReport{}
+			err := json.Unmarshal(v, report)
+			if err != nil {
+				return fmt.Errorf("error unmarshaling indexing report: %v", err)
+			}
+			reports = append(reports, report)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
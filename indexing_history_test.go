@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndexingRunsAreRecorded(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+	e.addPhoto(t, "b.jpg", time.Date(2021, 1, 2, 10, 0, 0, 0, time.Local))
+	broken := filepath.Join(e.library, "broken.jpg")
+	if err := os.WriteFile(broken, []byte("not a photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(broken, past, past); err != nil {
+		t.Fatal(err)
+	}
+	e.index(t)
+
+	// Second run removes a deleted photo and skips the others
+	if err := os.Remove(filepath.Join(e.library, "a.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	e.index(t)
+
+	reports, err := GetIndexingReports(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("want 2 reports, got %d", len(reports))
+	}
+
+	last, first := reports[0], reports[1]
+	if first.Type != indexTypeDiff || first.Trigger != triggerUser || first.State != jobCompleted {
+		t.Errorf("want completed diff run by user, got %+v", first)
+	}
+	if first.Added != 3 || first.NoExif != 1 || len(first.FailedFiles) != 1 || first.FailedFiles[0].Path != broken {
+		t.Errorf("want 3 photos added and the broken one listed, got %+v", first)
+	}
+	if last.ID <= first.ID || last.Removed != 1 || last.Skipped != 2 || last.Added+last.Updated != 0 {
+		t.Errorf("want 1 removed and 2 skipped by the second run, got %+v", last)
+	}
+
+	e.telegram.Reset()
+	e.send(t, testUserID, "/indexing history")
+	if got := texts(e.telegram.Calls("sendMessage")); !containsText(got, "Added 0, updated 0, skipped 2, failed 0, removed 1") {
+		t.Errorf("want history of runs, got %q", got)
+	}
+
+	e.telegram.Reset()
+	e.send(t, testUserID, "/indexing errors")
+	if got := texts(e.telegram.Calls("sendMessage")); !containsText(got, "📂 "+broken) {
+		t.Errorf("want broken photo listed, got %q", got)
+	}
+}
+
+func TestIndexingHistoryRetention(t *testing.T) {
+	newTestEnv(t)
+
+	for i := 0; i < indexingHistoryLimit+5; i++ {
+		saveIndexingReport(newIndexingReport(indexTypeDiff, triggerCron), jobCompleted)
+	}
+
+	reports, err := GetIndexingReports(indexingHistoryLimit * 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != indexingHistoryLimit {
+		t.Fatalf("want %d reports kept, got %d", indexingHistoryLimit, len(reports))
+	}
+	if reports[0].ID != indexingHistoryLimit+5 || reports[len(reports)-1].ID != 6 {
+		t.Errorf("want reports 6..%d, got %d..%d", indexingHistoryLimit+5, reports[len(reports)-1].ID, reports[0].ID)
+	}
+}
+
+func TestWatcherRunsWithoutChangesAreNotRecorded(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	// A library poll which finds nothing new is not kept, one which removes a photo is
+	for i := 0; i < 3; i++ {
+		saveIndexingReport(newIndexingReport(indexTypeDiff, triggerWatcher), jobCompleted)
+	}
+	report := newIndexingReport(indexTypeDiff, triggerWatcher)
+	report.removed(1)
+	saveIndexingReport(report, jobCompleted)
+
+	reports, err := GetIndexingReports(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Removed != 1 || reports[1].Trigger != triggerUser {
+		t.Errorf("want the user run and the watcher run with changes, got %+v", reports)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Indexing job states
+const (
+	jobRunning   = "running"   // Indexing is running or was interrupted by shutdown and is resumed on next start
+	jobPaused    = "paused"    // Stopped by /reindex pause until /reindex resume
+	jobCancelled = "cancelled" // Stopped by /reindex cancel
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+)
+
+const (
+	bucketIndexingJobFiles = "IndexingJobFiles" // Bucket for photos of the current indexing job (position -> path)
+	keyIndexingJob         = "IndexingJob"      // Key for the current indexing job in IndexingStats
+)
+
+// IndexingJob is the last started indexing. It is persisted, so paused or interrupted indexing continues
+// from where it stopped instead of walking the library again. Photos of the job are kept in bucketIndexingJobFiles
+// in the order they are indexed.
+type IndexingJob struct {
+	State          string    `json:"state"`
+	ForceAll       bool      `json:"forceAll"`       // Full reindexing, all photos are extracted again
+	CleanupDeleted bool      `json:"cleanupDeleted"` // Deleted photos are removed from the index when the job completes
+	Listed         bool      `json:"listed"`         // Photos of the job are saved in bucketIndexingJobFiles
+	Total          int       `json:"total"`          // Photos of the job
+	Position       int       `json:"position"`       // Photos of the job handled so far
+	StartedAt      time.Time `json:"startedAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Finished reports whether the job can't be resumed
+func (j *IndexingJob) Finished() bool {
+	return j.State != jobRunning && j.State != jobPaused
+}
+
+// indexType returns the type of the job for the indexing history
+func (j *IndexingJob) indexType() string {
+	if j.ForceAll {
+		return indexTypeFull
+	}
+	return indexTypeDiff
+}
+
+// indexingRun controls the running indexing job, protected by indexingMutex
+type indexingRun struct {
+	cancel context.CancelFunc
+	stopAs string // State requested by /reindex pause or cancel, empty when stopped by shutdown
+}
+
+var activeRun *indexingRun
+
+var errNoIndexingJob = errors.New("there is no indexing to stop")
+
+// newIndexingJob returns a new job, which is saved when it starts
+func newIndexingJob(forceAll bool, cleanupDeleted bool) *IndexingJob {
+	return &IndexingJob{State: jobRunning, ForceAll: forceAll, CleanupDeleted: cleanupDeleted, StartedAt: time.Now()}
+}
+
+// getIndexingJob returns the last indexing job or nil if indexing was never started
+func getIndexingJob() (*IndexingJob, error) {
+	var job *IndexingJob
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingStats))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingStats)
+		}
+
+		jobBytes := b.Get([]byte(keyIndexingJob))
+		if jobBytes == nil {
+			// Older versions saved a checkpoint of indexing interrupted by shutdown
+			jobBytes = b.Get([]byte(keyIndexingCheckpoint))
+			if jobBytes == nil {
+				return nil
+			}
+			job = &IndexingJob{State: jobRunning}
+		} else {
+			job = &IndexingJob{}
+		}
+
+		err := json.Unmarshal(jobBytes, job)
+		if err != nil {
+			return fmt.Errorf("error unmarshaling indexing job: %v", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// saveIndexingJob saves the job, photos of finished jobs are dropped
+func saveIndexingJob(job *IndexingJob) error {
+	job.UpdatedAt = time.Now()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingStats))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingStats)
+		}
+
+		if job.Finished() && job.Listed {
+			err := tx.DeleteBucket([]byte(bucketIndexingJobFiles))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return fmt.Errorf("error deleting bucket %s: %v", bucketIndexingJobFiles, err)
+			}
+			job.Listed = false
+		}
+
+		data, err := json.Marshal(job)
+		if err != nil {
+			return fmt.Errorf("error marshaling indexing job: %v", err)
+		}
+
+		err = b.Delete([]byte(keyIndexingCheckpoint))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(keyIndexingJob), data)
+	})
+}
+
+// saveIndexingJobFiles replaces photos of the job
+func saveIndexingJobFiles(photos []string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(bucketIndexingJobFiles))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return fmt.Errorf("error deleting bucket %s: %v", bucketIndexingJobFiles, err)
+		}
+
+		b, err := tx.CreateBucket([]byte(bucketIndexingJobFiles))
+		if err != nil {
+			return fmt.Errorf("error creating bucket %s: %v", bucketIndexingJobFiles, err)
+		}
+
+		for i, photo := range photos {
+			err := b.Put(photoIDKey(uint64(i)), []byte(photo))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadIndexingJobFiles returns photos of the job in the order they are indexed
+func loadIndexingJobFiles() ([]string, error) {
+	var photos []string
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingJobFiles))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingJobFiles)
+		}
+
+		return b.ForEach(func(_, v []byte) error {
+			photos = append(photos, string(v))
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return photos, nil
+}
+
+// PauseIndexing stops the running indexing, so it can be continued with ResumeIndexing.
+// Workers finish their current photos after it returns.
+func PauseIndexing() error {
+	indexingMutex.Lock()
+	defer indexingMutex.Unlock()
+
+	if activeRun == nil {
+		return errNoIndexingJob
+	}
+	activeRun.stopAs = jobPaused
+	activeRun.cancel()
+	return nil
+}
+
+// CancelIndexing stops the running or paused indexing for good
+func CancelIndexing() error {
+	indexingMutex.Lock()
+	defer indexingMutex.Unlock()
+
+	if activeRun != nil {
+		activeRun.stopAs = jobCancelled
+		activeRun.cancel()
+		return nil
+	}
+
+	job, err := getIndexingJob()
+	if err != nil {
+		return err
+	}
+	if job == nil || job.State != jobPaused {
+		return errNoIndexingJob
+	}
+
+	job.State = jobCancelled
+	return saveIndexingJob(job)
+}
+
+// ResumeIndexing continues the paused indexing
+func ResumeIndexing(ctx context.Context, roots []photoRoot, workerCount int) error {
+	indexingMutex.Lock()
+
+	job, err := getIndexingJob()
+	if err != nil {
+		indexingMutex.Unlock()
+		return err
+	}
+	if job == nil || job.State != jobPaused {
+		indexingMutex.Unlock()
+		return errors.New("there is no paused indexing")
+	}
+
+	// The job is resumed once, a second resume sees it running
+	job.State = jobRunning
+	err = saveIndexingJob(job)
+	indexingMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("error saving indexing job: %v", err)
+	}
+
+	log.Printf("Resuming paused indexing at %d of %d photos", job.Position, job.Total)
+	startIndexingProcess(ctx, roots, workerCount, job, triggerUser)
+	return nil
+}
+
+// resetStaleIndexingFlag resets the indexing active flag if no indexing runs in this process.
+// It returns true if the flag was reset.
+func resetStaleIndexingFlag() (bool, error) {
+	indexingMutex.Lock()
+	defer indexingMutex.Unlock()
+
+	if activeRun != nil {
+		return false, nil
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingStats))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingStats)
+		}
+		return b.Put([]byte(keyIndexingActive), []byte("false"))
+	})
+	return err == nil, err
+}
+
+// checkNoPausedIndexing returns an error if a paused job waits to be resumed or cancelled
+func checkNoPausedIndexing() error {
+	job, err := getIndexingJob()
+	if err != nil {
+		return fmt.Errorf("error reading indexing job: %v", err)
+	}
+	if job != nil && job.State == jobPaused {
+		return errors.New("indexing is paused, resume or cancel it first")
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// startSlowIndexing starts differential indexing of photos read at 10 per second and waits until it extracts them.
+// The library walk and the check are throttled too.
+func startSlowIndexing(t *testing.T, e *testEnv) {
+	t.Helper()
+
+	indexThrottle = newIndexingThrottle(10, 0, 0)
+	t.Cleanup(func() { indexThrottle = nil })
+
+	if err := StartDifferentialIndexing(context.Background(), cfg.photoRoots, 2, triggerUser); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for stats := runningIndexing.Load(); stats == nil || stats.getPhase() != phaseExtracting; stats = runningIndexing.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("indexing did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPauseAndResumeIndexing(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 10; i++ {
+		e.addPhoto(t, fmt.Sprintf("%d.jpg", i), time.Date(2020, 1, i+1, 10, 0, 0, 0, time.Local))
+	}
+
+	startSlowIndexing(t, e)
+	if err := PauseIndexing(); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitForIndexing(5 * time.Second) {
+		t.Fatal("indexing did not pause")
+	}
+
+	job, err := getIndexingJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != jobPaused || job.Position >= 10 || job.Total != 10 || !job.Listed {
+		t.Fatalf("want job paused before the last photo, got %+v", job)
+	}
+
+	// Paused indexing is not replaced by new indexing or restarted on start
+	if err := StartDifferentialIndexing(context.Background(), cfg.photoRoots, 2, triggerUser); err == nil {
+		t.Error("want error starting indexing while paused")
+	}
+	StartBackgroundIndexing(context.Background(), cfg.photoRoots, 2)
+	if active, _, _, _ := GetIndexingStatus(); active {
+		t.Error("want paused indexing not started on start")
+	}
+
+	status, err := formatIndexingStatus(testChatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsText([]string{status}, "Indexing is paused at") {
+		t.Errorf("want paused status, got %q", status)
+	}
+
+	// Resumed job continues with the photos listed when it started
+	e.addPhoto(t, "new.jpg", time.Date(2021, 1, 1, 10, 0, 0, 0, time.Local))
+	indexThrottle = nil
+	if err := ResumeIndexing(context.Background(), cfg.photoRoots, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitForIndexing(5 * time.Second) {
+		t.Fatal("indexing did not complete")
+	}
+
+	job, err = getIndexingJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != jobCompleted || job.Position != 10 || job.Listed {
+		t.Errorf("want completed job without photo list, got %+v", job)
+	}
+
+	_, indexed, _, err := GetIndexingStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 10 {
+		t.Errorf("want 10 photos indexed by the job, got %d", indexed)
+	}
+}
+
+func TestCancelIndexing(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 10; i++ {
+		e.addPhoto(t, fmt.Sprintf("%d.jpg", i), time.Date(2020, 1, i+1, 10, 0, 0, 0, time.Local))
+	}
+
+	if err := CancelIndexing(); err != errNoIndexingJob {
+		t.Errorf("want error without indexing, got %v", err)
+	}
+
+	// Running indexing
+	startSlowIndexing(t, e)
+	if err := CancelIndexing(); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitForIndexing(5 * time.Second) {
+		t.Fatal("indexing did not stop")
+	}
+
+	job, err := getIndexingJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != jobCancelled || job.Listed {
+		t.Errorf("want cancelled job without photo list, got %+v", job)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucketIndexingJobFiles)) != nil {
+			t.Error("want photos of cancelled job removed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paused indexing
+	startSlowIndexing(t, e)
+	if err := PauseIndexing(); err != nil {
+		t.Fatal(err)
+	}
+	WaitForIndexing(5 * time.Second)
+	if err := CancelIndexing(); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := getIndexingJob(); job.State != jobCancelled {
+		t.Errorf("want paused job cancelled, got %+v", job)
+	}
+	if err := ResumeIndexing(context.Background(), cfg.photoRoots, 2); err == nil {
+		t.Error("want error resuming cancelled indexing")
+	}
+}
+
+func TestRunningIndexingKeepsActiveFlag(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 10; i++ {
+		e.addPhoto(t, fmt.Sprintf("%d.jpg", i), time.Date(2020, 1, i+1, 10, 0, 0, 0, time.Local))
+	}
+
+	// A slow run is not taken for a stuck one
+	startSlowIndexing(t, e)
+	if reset, err := resetStaleIndexingFlag(); err != nil || reset {
+		t.Errorf("want flag of the running indexing kept, got %v %v", reset, err)
+	}
+	if active, _, _, _ := GetIndexingStatus(); !active {
+		t.Error("want indexing active")
+	}
+
+	// The paused job is resumed once
+	if err := PauseIndexing(); err != nil {
+		t.Fatal(err)
+	}
+	WaitForIndexing(5 * time.Second)
+	if err := ResumeIndexing(context.Background(), cfg.photoRoots, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResumeIndexing(context.Background(), cfg.photoRoots, 2); err == nil {
+		t.Error("want error resuming indexing twice")
+	}
+	if err := CancelIndexing(); err != nil {
+		t.Fatal(err)
+	}
+	WaitForIndexing(5 * time.Second)
+}
+
+func TestIndexingJobFromCheckpointOfOlderVersion(t *testing.T) {
+	newTestEnv(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingStats))
+		return b.Put([]byte(keyIndexingCheckpoint), []byte(`{"cleanupDeleted":true,"indexed":5}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := getIndexingJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.State != jobRunning || !job.CleanupDeleted {
+		t.Errorf("want running differential job, got %+v", job)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// workLimit limits how many goroutines do some work at the same time, a nil limit doesn't limit
+type workLimit chan struct{}
+
+func newWorkLimit(n int) workLimit {
+	if n < 1 {
+		return nil
+	}
+	return make(workLimit, n)
+}
+
+func (l workLimit) acquire() {
+	if l != nil {
+		l <- struct{}{}
+	}
+}
+
+func (l workLimit) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// Limits of indexing work, set up in main from the config. Tests run without limits.
+var (
+	exifLimit     workLimit         // EXIF extraction, mostly CPU
+	hashLimit     workLimit         // MD5 hashing, reads whole files
+	indexThrottle *indexingThrottle // Low priority mode, nil if disabled
+)
+
+// initIndexingLimits sets up indexing limits from the config
+func initIndexingLimits() {
+	exifLimit = newWorkLimit(cfg.indexExifWorkers)
+	hashLimit = newWorkLimit(cfg.indexHashWorkers)
+	indexThrottle = nil
+	if cfg.indexFilesPerSec > 0 {
+		indexThrottle = newIndexingThrottle(cfg.indexFilesPerSec, cfg.indexThrottleFrom, cfg.indexThrottleTo)
+	}
+}
+
+// defaultIndexWorkers is the number of indexing workers if FM_INDEX_WORKERS is not set
+func defaultIndexWorkers() int {
+	return runtime.NumCPU()
+}
+
+// indexingThrottle limits files read by indexing per second in low priority mode,
+// only between fromHour and toHour if they differ
+type indexingThrottle struct {
+	bucket   *tokenBucket
+	fromHour int
+	toHour   int
+}
+
+func newIndexingThrottle(filesPerSec float64, fromHour int, toHour int) *indexingThrottle {
+	return &indexingThrottle{bucket: newTokenBucket(filesPerSec, 1), fromHour: fromHour, toHour: toHour}
+}
+
+// active reports whether files are throttled at now
+func (t *indexingThrottle) active(now time.Time) bool {
+	if t.fromHour == t.toHour {
+		return true
+	}
+	hour := now.Hour()
+	if t.fromHour < t.toHour {
+		return hour >= t.fromHour && hour < t.toHour
+	}
+	// Hours pass midnight, e.g. 22-6
+	return hour >= t.fromHour || hour < t.toHour
+}
+
+// wait blocks until the next file may be read or ctx is cancelled
+func (t *indexingThrottle) wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	now := time.Now()
+	if !t.active(now) {
+		return nil
+	}
+
+	delay := t.bucket.reserve(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseHourRange parses hours like "8-22", end hour is exclusive
+func parseHourRange(value string) (int, int, error) {
+	from, to, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("want hours like 8-22, got %q", value)
+	}
+
+	fromHour, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil || fromHour < 0 || fromHour > 23 {
+		return 0, 0, fmt.Errorf("invalid start hour %q", from)
+	}
+	toHour, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil || toHour < 0 || toHour > 24 {
+		return 0, 0, fmt.Errorf("invalid end hour %q", to)
+	}
+	return fromHour, toHour % 24, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIndexingThrottleHours(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2024, 5, 1, hour, 30, 0, 0, time.Local) }
+
+	allDay := newIndexingThrottle(1, 0, 0)
+	day := newIndexingThrottle(1, 8, 22)
+	night := newIndexingThrottle(1, 22, 6)
+
+	tests := []struct {
+		name     string
+		throttle *indexingThrottle
+		hour     int
+		want     bool
+	}{
+		{"all day", allDay, 3, true},
+		{"day hours", day, 8, true},
+		{"after day hours", day, 22, false},
+		{"before day hours", day, 7, false},
+		{"night hours before midnight", night, 23, true},
+		{"night hours after midnight", night, 5, true},
+		{"outside night hours", night, 12, false},
+	}
+	for _, tt := range tests {
+		if got := tt.throttle.active(at(tt.hour)); got != tt.want {
+			t.Errorf("%s: want active %v at %d, got %v", tt.name, tt.want, tt.hour, got)
+		}
+	}
+}
+
+func TestIndexingThrottleWait(t *testing.T) {
+	throttle := newIndexingThrottle(20, 0, 0)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := throttle.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first file is read at once, the next four 50ms apart
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("want 5 files read in at least 200ms, took %s", elapsed)
+	}
+
+	// Cancelled indexing doesn't wait
+	slow := newIndexingThrottle(0.01, 0, 0)
+	_ = slow.wait(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := slow.wait(ctx); err == nil {
+		t.Error("want error of cancelled context")
+	}
+
+	// Disabled throttle
+	var disabled *indexingThrottle
+	if err := disabled.wait(ctx); err != nil {
+		t.Errorf("want no wait without throttle, got %v", err)
+	}
+}
+
+func TestParseHourRange(t *testing.T) {
+	from, to, err := parseHourRange("8-24")
+	if err != nil || from != 8 || to != 0 {
+		t.Errorf("want 8-0, got %d-%d, %v", from, to, err)
+	}
+
+	for _, value := range []string{"8", "a-9", "8-25", "-1-3"} {
+		if _, _, err := parseHourRange(value); err == nil {
+			t.Errorf("want error for %q", value)
+		}
+	}
+}
+
+func TestIndexingThrottleLimitsWalkAndCheck(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 5; i++ {
+		e.addPhoto(t, fmt.Sprintf("%d.jpg", i), time.Date(2020, 1, i+1, 10, 0, 0, 0, time.Local))
+	}
+	indexThrottle = newIndexingThrottle(20, 0, 0)
+	t.Cleanup(func() { indexThrottle = nil })
+
+	// Each phase reads the first photo at once, the next four 50ms apart
+	start := time.Now()
+	photos := findInRoots(context.Background(), cfg.photoRoots, nil, nil, indexThrottle)
+	if elapsed := time.Since(start); len(photos) != 5 || elapsed < 190*time.Millisecond {
+		t.Errorf("want 5 photos found in at least 200ms, got %d in %s", len(photos), elapsed)
+	}
+
+	time.Sleep(100 * time.Millisecond) // Refill the bucket
+	start = time.Now()
+	pending := checkChangedPhotos(context.Background(), photos, 0, false, time.Time{}, 2, newIndexingRunStats(),
+		newIndexingReport(indexTypeDiff, triggerUser))
+	if elapsed := time.Since(start); len(pending) != 5 || elapsed < 190*time.Millisecond {
+		t.Errorf("want 5 photos checked in at least 200ms, got %d in %s", len(pending), elapsed)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// indexingPhase is the stage of an indexing run
+type indexingPhase int32
+
+const (
+	phaseWalking    indexingPhase = iota // Walking the library to find photos
+	phaseChecking                        // Checking which photos are new or changed since they were indexed
+	phaseExtracting                      // Extracting and saving metadata of changed photos
+	phaseCleanup                         // Removing deleted photos from the index
+)
+
+var indexingPhaseNames = map[indexingPhase]string{
+	phaseWalking:    "walking the library",
+	phaseChecking:   "checking for changes",
+	phaseExtracting: "extracting metadata",
+	phaseCleanup:    "cleaning up deleted photos",
+}
+
+func (p indexingPhase) String() string {
+	return indexingPhaseNames[p]
+}
+
+// etaMinElapsed is how long a phase runs before its ETA is estimated
+const etaMinElapsed = 2 * time.Second
+
+// indexingRunStats are progress counters of the running indexing, kept in memory.
+// Counters are of this run only, a resumed job starts them from zero.
+type indexingRunStats struct {
+	startedAt time.Time
+
+	phase          atomic.Int32 // indexingPhase
+	phaseStartedAt atomic.Int64 // Unix nanoseconds
+
+	scanned      atomic.Int64 // Photos found by the library walk
+	toCheck      atomic.Int64 // Photos this run checks, the job's photos left when it started
+	checked      atomic.Int64 // Photos checked for changes
+	changed      atomic.Int64 // New or changed photos, which are extracted
+	extracted    atomic.Int64 // Changed photos extracted or failed
+	written      atomic.Int64 // Photos saved by this run
+	toRemove     atomic.Int64 // Deleted photos the cleanup removes from the index
+	removed      atomic.Int64 // Deleted photos removed from the index
+	transactions atomic.Int64 // Write transactions committed by this run
+
+	extractStartedAt  atomic.Int64 // Unix nanoseconds, zero until extracting starts
+	extractFinishedAt atomic.Int64 // Unix nanoseconds, zero while extracting
+}
+
+func newIndexingRunStats() *indexingRunStats {
+	stats := &indexingRunStats{startedAt: time.Now()}
+	stats.setPhase(phaseWalking)
+	return stats
+}
+
+// setPhase moves the run to the next phase
+func (s *indexingRunStats) setPhase(phase indexingPhase) {
+	now := time.Now().UnixNano()
+	if s.getPhase() == phaseExtracting && phase != phaseExtracting {
+		s.extractFinishedAt.Store(now)
+	}
+	if phase == phaseExtracting {
+		s.extractStartedAt.Store(now)
+	}
+	s.phaseStartedAt.Store(now)
+	s.phase.Store(int32(phase))
+}
+
+func (s *indexingRunStats) getPhase() indexingPhase {
+	return indexingPhase(s.phase.Load())
+}
+
+// throughput returns saved photos per second while metadata was extracted
+func (s *indexingRunStats) throughput() float64 {
+	started := s.extractStartedAt.Load()
+	if started == 0 {
+		return 0
+	}
+	finished := s.extractFinishedAt.Load()
+	if finished == 0 {
+		finished = time.Now().UnixNano()
+	}
+
+	elapsed := time.Duration(finished - started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.written.Load()) / elapsed
+}
+
+// eta estimates the time left in the current phase from its progress so far.
+// It returns false if the phase has no known end or it is too early to tell.
+func (s *indexingRunStats) eta() (time.Duration, bool) {
+	var done, total int64
+	switch s.getPhase() {
+	case phaseChecking:
+		done, total = s.checked.Load(), s.toCheck.Load()
+	case phaseExtracting:
+		done, total = s.extracted.Load(), s.changed.Load()
+	case phaseCleanup:
+		done, total = s.removed.Load(), s.toRemove.Load()
+	default:
+		return 0, false
+	}
+
+	elapsed := time.Since(time.Unix(0, s.phaseStartedAt.Load()))
+	if done == 0 || elapsed < etaMinElapsed {
+		return 0, false
+	}
+	return time.Duration(float64(elapsed) / float64(done) * float64(total-done)), true
+}
+
+// progress grows while the run does any work, so a run which doesn't change it is stuck
+func (s *indexingRunStats) progress() int64 {
+	return s.scanned.Load() + s.checked.Load() + s.extracted.Load() + s.written.Load() + s.removed.Load()
+}
+
+// runningIndexing holds counters of the running indexing, nil when indexing is not running
+var runningIndexing atomic.Pointer[indexingRunStats]
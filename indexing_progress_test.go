@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDifferentialIndexingStatusCountsLibrary(t *testing.T) {
+	e := newTestEnv(t)
+	past := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		path := e.addPhoto(t, fmt.Sprintf("%d.jpg", i), time.Date(2020, 1, i+1, 10, 0, 0, 0, time.Local))
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.index(t)
+
+	// The second run saves only the new photo, the status counts the whole library
+	e.addPhoto(t, "new.jpg", time.Date(2021, 1, 2, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	status, err := formatIndexingStatus(testChatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status, "4 of 4 photos are indexed (100.0%)") ||
+		!strings.Contains(status, "Last run: checked 4, changed 1, failed 0, removed 0") {
+		t.Errorf("want all photos indexed and counters of the last run, got %q", status)
+	}
+
+	saved, err := getIndexedCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != 1 {
+		t.Errorf("want 1 photo saved by the last run, got %d", saved)
+	}
+}
+
+func TestIndexingStatusShowsPhase(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 10; i++ {
+		e.addPhoto(t, fmt.Sprintf("%d.jpg", i), time.Date(2020, 1, i+1, 10, 0, 0, 0, time.Local))
+	}
+
+	startSlowIndexing(t, e)
+	t.Cleanup(func() {
+		_ = CancelIndexing()
+		WaitForIndexing(5 * time.Second)
+	})
+
+	status, err := formatIndexingStatus(testChatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"extracting metadata (3 of 4)", "Found 10 photos in the library",
+		"Checked 10 of 10 photos (100.0%)", "Changed 10, extracted"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("want %q in status, got %q", want, status)
+		}
+	}
+}
+
+func TestIndexingRunStatsETA(t *testing.T) {
+	stats := newIndexingRunStats()
+	if _, ok := stats.eta(); ok {
+		t.Error("want no ETA while walking the library")
+	}
+
+	stats.toCheck.Store(100)
+	stats.setPhase(phaseChecking)
+	stats.checked.Store(25)
+	if _, ok := stats.eta(); ok {
+		t.Error("want no ETA right after the phase started")
+	}
+
+	// A quarter of photos checked in 10 seconds leaves 30 seconds
+	stats.phaseStartedAt.Store(time.Now().Add(-10 * time.Second).UnixNano())
+	eta, ok := stats.eta()
+	if !ok || eta < 29*time.Second || eta > 31*time.Second {
+		t.Errorf("want ETA of 30s, got %v %v", eta, ok)
+	}
+
+	// Extracting estimates changed photos left
+	stats.changed.Store(10)
+	stats.setPhase(phaseExtracting)
+	stats.extracted.Store(5)
+	stats.phaseStartedAt.Store(time.Now().Add(-10 * time.Second).UnixNano())
+	eta, ok = stats.eta()
+	if !ok || eta < 9*time.Second || eta > 11*time.Second {
+		t.Errorf("want ETA of 10s, got %v %v", eta, ok)
+	}
+
+	// Cleanup estimates deleted photos left
+	stats.toRemove.Store(40)
+	stats.setPhase(phaseCleanup)
+	stats.removed.Store(10)
+	stats.phaseStartedAt.Store(time.Now().Add(-10 * time.Second).UnixNano())
+	eta, ok = stats.eta()
+	if !ok || eta < 29*time.Second || eta > 31*time.Second {
+		t.Errorf("want ETA of 30s, got %v %v", eta, ok)
+	}
+}
+
+func TestCleanupCountsRemovedPhotos(t *testing.T) {
+	e := newTestEnv(t)
+	var photos []string
+	for i := 0; i < 5; i++ {
+		photos = append(photos, e.addPhoto(t, fmt.Sprintf("%d.jpg", i), time.Date(2020, 1, i+1, 10, 0, 0, 0, time.Local)))
+	}
+	e.index(t)
+
+	// Three photos are deleted, the cleanup progress and ETA count them
+	stats := newIndexingRunStats()
+	stats.setPhase(phaseCleanup)
+	if removed := cleanupDeletedFiles(map[string]bool{photos[0]: true, photos[1]: true}, stats); removed != 3 {
+		t.Errorf("want 3 photos removed, got %d", removed)
+	}
+	if stats.toRemove.Load() != 3 || stats.removed.Load() != 3 || stats.progress() != 3 {
+		t.Errorf("want removed photos counted, got %d of %d, progress %d",
+			stats.removed.Load(), stats.toRemove.Load(), stats.progress())
+	}
+	if !strings.Contains(formatIndexingProgress(stats), "Removed 3 of 3 deleted photos (100.0%)") {
+		t.Errorf("want cleanup progress, got %q", formatIndexingProgress(stats))
+	}
+	if isIndexed(t, photos[4]) || !isIndexed(t, photos[0]) {
+		t.Error("want only deleted photos removed from the index")
+	}
+}
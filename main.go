@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
-	"strings"
+	"syscall"
 	"time"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
@@ -18,8 +21,14 @@ import (
 )
 
 var tempProcessedPhotoPath = os.TempDir() + "/" + "compressed"
-var cfg = getConfig()
-var lastPhotos []string
+var cfg Config
+
+// appCtx is cancelled on SIGINT/SIGTERM to stop background work
+var appCtx = context.Background()
+
+// Time given to cron jobs and indexing to finish on shutdown.
+// Docker kills the container 10 seconds after SIGTERM by default.
+const shutdownTimeout = 8 * time.Second
 
 type PhotoRequestType int
 
@@ -29,13 +38,27 @@ const (
 )
 
 func main() {
-	notSensitiveData := cfg
-	notSensitiveData.botToken = "********"
-	if notSensitiveData.telegramProxyPass != "" {
-		notSensitiveData.telegramProxyPass = "********"
+	configPath := flag.String("config", os.Getenv(keyConfigFile), "path to the YAML config file, also set by "+keyConfigFile)
+	checkConfig := flag.Bool("check-config", false, "print the effective config with secrets redacted and exit")
+	flag.Parse()
+
+	loadedConfig, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config:\n%v\n", err)
+		os.Exit(1)
 	}
+	if *checkConfig {
+		fmt.Print(formatConfig(loadedConfig))
+		return
+	}
+	cfg = loadedConfig
+	configFilePath = *configPath
+
+	var stopSignals context.CancelFunc
+	appCtx, stopSignals = signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
 
-	log.Println("Starting bot with config:", notSensitiveData)
+	log.Printf("Starting bot with config:\n%s", formatConfig(cfg))
 
 	// 1) Ensure the compressed photos folder
 	if _, err := os.Stat(tempProcessedPhotoPath); os.IsNotExist(err) {
@@ -43,14 +66,26 @@ func main() {
 		if err != nil {
 			log.Panic(err)
 		}
+	} else {
+		// Remove files possibly half-written before the previous stop
+		clearCompressedPhotos()
 	}
 
+	// Photo sends run in the queue workers, each with its own temporary workspace
+	sendJobs = newSendQueue(cfg.sendWorkers)
+
+	// Indexing shares the CPU and disks with other services, limit it as configured
+	initIndexingLimits()
+
+	// Photo formats may be extended by the config
+	initMediaTypes()
+
 	// 2) Initialize bbolt DB
 	initDB(cfg.dbPath)
 	defer db.Close()
 
 	// 3) Initialize photo metadata buckets
-	err := InitPhotoMetadata()
+	err = InitPhotoMetadata()
 	if err != nil {
 		log.Printf("Failed to initialize photo metadata: %v", err)
 	} else {
@@ -60,8 +95,11 @@ func main() {
 			log.Printf("Error checking indexing flag: %v", err)
 		}
 
-		// 4) Start background indexing with 2 workers
-		StartBackgroundIndexing(cfg.photoPath, 2)
+		// 4) Start background indexing, it resumes indexing interrupted by the previous stop
+		StartBackgroundIndexing(appCtx, cfg.photoRoots, cfg.indexWorkers)
+
+		// 5) Index photos added, changed or deleted while the bot is running
+		StartLibraryWatcher(appCtx, cfg.photoRoots)
 	}
 
 	var bot *tgbotapi.BotAPI
@@ -90,338 +128,150 @@ func main() {
 	bot.Debug = false
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	updates, stopUpdates, err := startReceivingUpdates(bot)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Stop receiving updates on SIGINT/SIGTERM, so the update loop below ends
+	// and the webhook is removed
+	go func() {
+		<-appCtx.Done()
+		log.Println("Received stop signal, shutting down")
+		stopUpdates()
+	}()
 
-	updates := bot.GetUpdatesChan(u)
+	// All outbound requests go through the rate limiter to stay within Telegram limits
+	sender := newThrottledSender(bot, newSendLimiter())
 
 	msg := tgbotapi.NewMessage(cfg.chatId, startMessage)
-	if _, err := bot.Send(msg); err != nil {
+	if _, err := sender.Send(msg); err != nil {
 		log.Println("Failed to send start message.", err)
 	}
 
-	c := cron.New()
-	_, err = c.AddFunc(cfg.cronSpec, func() {
-		sendRandomPhoto(cfg.photoCount, nil, bot)
-	})
-	if err != nil {
-		panic("Failed to add cron job.")
+	c := cron.New(cron.WithLocation(cfg.location))
+	scheduledJobs = newScheduler(c, sender)
+	if err := scheduledJobs.apply(cfg); err != nil {
+		log.Panic(err)
 	}
+	c.Start()
 
-	// Add cron job for sending photos from this day in different years
-	_, err = c.AddFunc(cfg.memoriesCronSpec, func() {
-		sendMemoryPhotos(RequestTypeToday, 0, nil, bot)
-	})
+	// Set up commands for Telegram menu and /help from the same registry
+	router := newBotCommandRouter()
+	commandConfig := tgbotapi.NewSetMyCommands(router.BotCommands()...)
+	_, err = sender.Request(commandConfig)
 	if err != nil {
-		panic("Failed to add memories cron job.")
+		log.Printf("Error setting bot commands: %v", err)
 	}
 
-	// Add cron job for automatic reindexing
-	_, err = c.AddFunc(cfg.reindexCronSpec, func() {
-		log.Println("Starting scheduled differential reindexing")
-		err := StartDifferentialIndexing(cfg.photoPath, 2)
-		if err != nil {
-			log.Printf("Error during scheduled reindexing: %v", err)
+	// Reload the config on SIGHUP, the result is reported to the chat
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	go func() {
+		for range reloadSignals {
+			log.Println("Received SIGHUP, reloading config")
+			reloadConfigAndReport(currentConfig().chatId, 0, sender)
 		}
-	})
-	if err != nil {
-		panic("Failed to add reindexing cron job.")
+	}()
+
+	// The loop ends after the current update is handled once updates are stopped
+	for update := range updates {
+		handleUpdate(router, sender, update)
 	}
 
-	c.Start()
+	shutdown(c)
+}
 
-	// Set up commands for Telegram menu
-	commands := []tgbotapi.BotCommand{
-		{Command: "start", Description: "Start interaction with the bot"},
-		{Command: "photo", Description: "Send random photos from your library"},
-		{Command: "memories", Description: "Photos from this day 1 year ago (use /memories N for N years ago)"},
-		{Command: "today", Description: "View photos taken on this day across different years"},
-		{Command: "indexing", Description: "Show photo indexing status"},
-		{Command: "reindex", Description: "Start photo reindexing (full/diff)"},
-		{Command: "info", Description: "Show photo info (reply to photo or use /info N for Nth photo)"},
+// shutdown waits for running cron jobs and indexing workers before the DB is closed
+func shutdown(c *cron.Cron) {
+	deadline := time.Now().Add(shutdownTimeout)
+
+	select {
+	case <-c.Stop().Done():
+		log.Println("Scheduled jobs stopped")
+	case <-time.After(time.Until(deadline)):
+		log.Println("Timed out waiting for scheduled jobs")
 	}
 
-	// Set bot commands
-	commandConfig := tgbotapi.NewSetMyCommands(commands...)
-	_, err = bot.Request(commandConfig)
-	if err != nil {
-		log.Printf("Error setting bot commands: %v", err)
+	if sendJobs.Close(time.Until(deadline)) {
+		log.Println("Photo sends finished")
+	} else {
+		log.Println("Timed out waiting for photo sends")
 	}
 
-	for update := range updates {
-		if update.Message != nil {
-			log.Printf("New message: [%s]: %d - %s", update.Message.From.UserName, update.Message.From.ID,
-				update.Message.Text)
-
-			if update.Message.IsCommand() && update.Message.Command() == "start" {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, startMessage)
-				if _, err := sendMessageWithRetry(bot, msg); err != nil {
-					log.Println("Failed send start msg after all retries:", err)
-				}
-				continue
-			}
+	if WaitForIndexing(time.Until(deadline)) {
+		log.Println("Indexing stopped")
+	} else {
+		log.Println("Timed out waiting for indexing")
+	}
 
-			// Check user permission
-			if !containsInt(cfg.allowedUserIds, update.Message.From.ID) {
-				log.Printf("User %s: %d is not allowed", update.Message.From.UserName, update.Message.From.ID)
-				continue
-			}
+	clearCompressedPhotos()
+	log.Println("Bot stopped")
+}
 
-			if update.Message.IsCommand() {
-				switch update.Message.Command() {
-				case "photo":
-					userPhotoCount, parseUserCountErr := strconv.Atoi(update.Message.CommandArguments())
-					if parseUserCountErr != nil {
-						sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-							"Please send a number")
-						continue
-					}
-					if userPhotoCount < 1 {
-						sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-							"Please send a number greater than 0")
-						continue
-					}
-					sendRandomPhoto(userPhotoCount, &update, bot)
-
-				case "info":
-					infoArg := update.Message.CommandArguments()
-
-					// Case 1: If user replies to a specific photo message with /info
-					//         and provides no numeric argument => show that photo's info.
-					if update.Message.ReplyToMessage != nil && (infoArg == "" || infoArg == " ") {
-						handleReplyToPhotoInfo(update, bot)
-						break
-					}
-
-					// Case 2: If user just writes "/info 2" (no reply),
-					//         we interpret that as "the 2nd photo from the last sending."
-					if infoArg != "" {
-						photoIndex, err := strconv.Atoi(infoArg)
-						if err != nil {
-							sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-								"Please provide a valid number, e.g. /info 2.")
-							break
-						}
-						handleLastSendingInfo(update, photoIndex, bot)
-						break
-					}
-
-					// If user typed "/info" with no reply, no argument
-					sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-						"Please specify a photo number or reply to a specific photo with /info.")
-
-				case "memories":
-					// Processing command to get photos from the past
-					yearsArg := update.Message.CommandArguments()
-					var yearsAgo = 1 // Default: 1 year ago
-					var err error
-
-					if yearsArg != "" {
-						yearsAgo, err = strconv.Atoi(yearsArg)
-						if err != nil || yearsAgo < 1 {
-							sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-								"Please specify a valid number of years, for example: /memories 2")
-							break
-						}
-					}
-
-					sendMemoryPhotos(RequestTypeMemories, yearsAgo, &update, bot)
-
-				case "today":
-					// Processing command to get photos taken on this day in different years
-					sendMemoryPhotos(RequestTypeToday, 0, &update, bot)
-
-				case "indexing":
-					// Show indexing status
-					active, _, _, err := GetIndexingStatus()
-					if err != nil {
-						sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-							fmt.Sprintf("Error getting indexing status: %v", err))
-						break
-					}
-
-					// Send initial status message
-					statusMsgID, err := sendIndexingStatusMessage(update.Message.Chat.ID, update.Message.MessageID, bot)
-					if err != nil {
-						log.Printf("Error sending indexing status: %v", err)
-						break
-					}
-
-					// If indexing is active, start a goroutine to update the status message
-					if active {
-						go func(chatID int64, messageID int) {
-							ticker := time.NewTicker(3 * time.Second) // Update every 3 seconds
-							defer ticker.Stop()
-
-							// Add counter to track changes in indexing status
-							lastIndexed := 0
-							unchangedCount := 0
-							maxUnchangedCount := 10 // Maximum number of updates without changes (30 seconds)
-
-							// Keep updating the status message while indexing is active
-							for range ticker.C {
-								// Check if indexing is still active
-								active, indexed, _, err := GetIndexingStatus()
-								if err != nil {
-									log.Printf("Error checking indexing status: %v", err)
-									return
-								}
-
-								// Check if the number of indexed photos has changed
-								if indexed == lastIndexed {
-									unchangedCount++
-								} else {
-									unchangedCount = 0
-									lastIndexed = indexed
-								}
-
-								// If status hasn't changed for too long, consider indexing as "stuck"
-								if unchangedCount >= maxUnchangedCount {
-									log.Printf("Indexing status hasn't changed for %d seconds, stopping updates",
-										3*maxUnchangedCount)
-
-									// Reset indexing flag
-									err = db.Update(func(tx *bolt.Tx) error {
-										b := tx.Bucket([]byte(bucketIndexingStats))
-										if b == nil {
-											return fmt.Errorf("bucket %s not found", bucketIndexingStats)
-										}
-										return b.Put([]byte(keyIndexingActive), []byte("false"))
-									})
-
-									if err != nil {
-										log.Printf("Error resetting indexing flag: %v", err)
-									} else {
-										log.Println("Reset stuck indexing flag")
-										active = false
-									}
-								}
-
-								// Update the status message
-								err = updateIndexingStatusMessage(chatID, messageID, bot)
-								if err != nil {
-									log.Printf("Error updating indexing status: %v", err)
-									return
-								}
-
-								// If indexing is no longer active, update one last time and stop
-								if !active {
-									// Wait a moment for final stats to be updated
-									time.Sleep(1 * time.Second)
-
-									// Final update with completed status
-									err = updateIndexingStatusMessage(chatID, messageID, bot)
-									if err != nil {
-										log.Printf("Error updating final indexing status: %v", err)
-									}
-									return
-								}
-							}
-						}(update.Message.Chat.ID, statusMsgID)
-					}
-
-				case "reindex":
-					// Start indexing with parameters
-					args := strings.Fields(update.Message.Text)
-					if len(args) < 2 {
-						sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-							"Usage: /reindex [full|diff]\n"+
-								"full - full reindexing (clear and recreate indexes)\n"+
-								"diff - differential indexing (only new and modified files)")
-						break
-					}
-
-					// Check if indexing is already active
-					active, _, _, err := GetIndexingStatus()
-					if err != nil {
-						sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-							fmt.Sprintf("Error checking indexing status: %v", err))
-						break
-					}
-
-					if active {
-						sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-							"Indexing is already active, please wait for it to complete")
-						break
-					}
-
-					// Determine indexing type
-					indexType := strings.ToLower(args[1])
-					var responseMsg string
-
-					switch indexType {
-					case "full":
-						// Start full reindexing
-						err = ForceReindexing(cfg.photoPath, 2)
-						if err != nil {
-							sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-								fmt.Sprintf("Error starting full reindexing: %v", err))
-							break
-						}
-						responseMsg = "Full photo reindexing started"
-
-					case "diff":
-						// Start differential indexing
-						err = StartDifferentialIndexing(cfg.photoPath, 2)
-						if err != nil {
-							sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-								fmt.Sprintf("Error starting differential indexing: %v", err))
-							break
-						}
-						responseMsg = "Differential photo indexing started (only new and modified files)"
-
-					default:
-						sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-							"Unknown indexing type. Use 'full' or 'diff'")
-						break
-					}
-
-					sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot, responseMsg)
-
-				default:
-					continue
-				}
-			}
+// handleUpdate dispatches a single update received from Telegram
+func handleUpdate(router *CommandRouter, bot Sender, update tgbotapi.Update) {
+	if update.Message == nil {
+		return
+	}
 
-			// Also handle the situation if user just types a number (if cfg.sendPhotosByNumber = true)
-			if cfg.sendPhotosByNumber {
-				userPhotoCount, parseUserCountErr := strconv.Atoi(update.Message.Text)
-				if parseUserCountErr != nil {
-					continue
-				}
-				if userPhotoCount < 1 {
-					sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
-						"Please send a number greater than 0")
-					continue
-				}
+	log.Printf("New message: [%s]: %d - %s", update.Message.From.UserName, update.Message.From.ID,
+		update.Message.Text)
 
-				sendRandomPhoto(userPhotoCount, &update, bot)
-			}
+	if update.Message.IsCommand() {
+		router.Dispatch(bot, update)
+		return
+	}
+
+	// Check user permission
+	if !hasPermission(update.Message.From, PermissionViewer) {
+		log.Printf("User %s: %d is not allowed", update.Message.From.UserName, update.Message.From.ID)
+		return
+	}
+
+	// Also handle the situation if user just types a number (if cfg.sendPhotosByNumber = true)
+	if currentConfig().sendPhotosByNumber {
+		userPhotoCount, parseUserCountErr := strconv.Atoi(update.Message.Text)
+		if parseUserCountErr != nil {
+			return
+		}
+		if userPhotoCount < 1 {
+			sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
+				"Please send a number greater than 0")
+			return
+		}
+		if !photoRequests.allowMessage(bot, update.Message) {
+			return
 		}
+
+		queueSend("photo", &update, bot, func() {
+			sendRandomPhoto(userPhotoCount, &update, bot)
+		})
 	}
 }
 
-func sendRandomPhoto(count int, update *tgbotapi.Update, bot *tgbotapi.BotAPI) {
+func sendRandomPhoto(count int, update *tgbotapi.Update, bot Sender) {
 	sendRandomPhotoMessage(count, update, bot)
-	clearCompressedPhotos()
 }
 
 // sendMemoryPhotos sends photos from the past
 // requestType - request type (today or specific number of years ago)
 // yearsAgo - number of years ago (used only for RequestTypeMemories)
-func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbotapi.Update, bot *tgbotapi.BotAPI) {
+func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbotapi.Update, bot Sender) {
 	var chatId int64
-	var replyMessageId *int
+	var replyMessageId int
 	if update != nil {
 		chatId = update.Message.Chat.ID
-		replyMessageId = &update.Message.MessageID
+		replyMessageId = update.Message.MessageID
 	} else {
-		chatId = cfg.chatId
-		// Create a dummy message ID for reply
-		fakeId := 0
-		replyMessageId = &fakeId
+		chatId = currentConfig().chatId
 	}
+	sendMemoryPhotosTo(chatId, replyMessageId, currentConfig().memoriesPhotoCount, requestType, yearsAgo, bot)
+}
 
+// sendMemoryPhotosTo sends at most count photos from the past to chatId, replying to replyMessageId if it is not 0
+func sendMemoryPhotosTo(chatId int64, replyMessageId int, count int, requestType PhotoRequestType, yearsAgo int,
+	bot Sender) {
 	// Form message depending on request type
 	var searchMessage string
 	if requestType == RequestTypeToday {
@@ -429,19 +279,22 @@ func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbota
 	} else {
 		searchMessage = fmt.Sprintf("🕰 Looking for photos taken %d years ago on this day...", yearsAgo)
 	}
-	sendSafeReplyText(chatId, *replyMessageId, bot, searchMessage)
+	sendSafeReplyText(chatId, replyMessageId, bot, searchMessage)
+
+	// "This day" is the date in the time zone of the chat
+	now := chatTime(chatId, time.Now())
 
 	// Get photos depending on request type
 	var photos []string
 	var err error
 	if requestType == RequestTypeToday {
-		photos, err = GetPhotosFromThisDay(100) // Get more photos to have enough for selection
+		photos, err = GetPhotosFromThisDay(now, 100) // Get more photos to have enough for selection
 	} else {
-		photos, err = GetPhotosFromPast(yearsAgo, 30) // Increase limit as we'll group by years
+		photos, err = GetPhotosFromPast(now, yearsAgo, 30) // Increase limit as we'll group by years
 	}
 
 	if err != nil {
-		sendSafeReplyText(chatId, *replyMessageId, bot, fmt.Sprintf("Error searching for photos: %v", err))
+		sendSafeReplyText(chatId, replyMessageId, bot, fmt.Sprintf("Error searching for photos: %v", err))
 		return
 	}
 
@@ -452,7 +305,7 @@ func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbota
 		} else {
 			notFoundMessage = fmt.Sprintf("No photos found taken %d years ago on this day", yearsAgo)
 		}
-		sendSafeReplyText(chatId, *replyMessageId, bot, notFoundMessage)
+		sendSafeReplyText(chatId, replyMessageId, bot, notFoundMessage)
 		return
 	}
 
@@ -502,10 +355,18 @@ func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbota
 	// Flag to track the first message (which will have sound)
 	isFirstMessage := true
 
+	// Processed photos of all years are kept in a workspace owned by this send
+	workspace, err := newSendWorkspace()
+	if err != nil {
+		sendSafeReplyText(chatId, replyMessageId, bot, fmt.Sprintf("Error preparing photos: %v", err))
+		return
+	}
+	defer workspace.Close()
+
 	// Calculate how many photos to take from each year
 	// to not exceed the total limit
 	photosPerYear := make(map[int]int)
-	remainingPhotos := cfg.memoriesPhotoCount
+	remainingPhotos := count
 
 	// First pass: ensure at least one photo per year if possible
 	for _, year := range years {
@@ -590,21 +451,25 @@ func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbota
 				filteredYearPhotos = yearPhotos // Fallback to original photos
 			}
 
-			// If we still have more photos than needed after filtering, shuffle and take the calculated number
+			// If we still have more photos than needed after filtering, take the calculated number
+			// preferring photos that were not shown recently
 			if len(filteredYearPhotos) > photosPerYear[year] {
-				shuffleStrings(filteredYearPhotos)
-				yearPhotos = filteredYearPhotos[:photosPerYear[year]]
+				orderedYearPhotos := preferUnseenPhotos(filteredYearPhotos, photosPerYear[year])
+				if len(orderedYearPhotos) > photosPerYear[year] {
+					orderedYearPhotos = orderedYearPhotos[:photosPerYear[year]]
+				}
+				yearPhotos = orderedYearPhotos
 			} else {
 				yearPhotos = filteredYearPhotos
 			}
 		}
 
 		// Process photos for this year
-		var processedPhotos []string
+		var processedPhotos []SelectedPhoto
 		for _, photo := range yearPhotos {
-			compressedPhoto := processPhoto(photo)
+			compressedPhoto := processPhoto(photo, workspace)
 			if compressedPhoto != nil {
-				processedPhotos = append(processedPhotos, *compressedPhoto)
+				processedPhotos = append(processedPhotos, SelectedPhoto{Original: photo, Processed: *compressedPhoto})
 			}
 		}
 
@@ -622,15 +487,13 @@ func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbota
 			log.Printf("Limiting photos for year %d from %d to %d due to Telegram API limitations",
 				year, len(processedPhotos), maxPhotosInGroup)
 			processedPhotos = processedPhotos[:maxPhotosInGroup]
-			yearPhotos = yearPhotos[:maxPhotosInGroup]
 		}
 
-		for i, path := range processedPhotos {
-			photo := tgbotapi.NewInputMediaPhoto(tgbotapi.FilePath(path))
+		for i, processedPhoto := range processedPhotos {
+			photo := tgbotapi.NewInputMediaPhoto(tgbotapi.FilePath(processedPhoto.Processed))
 
 			// Set caption only for the first photo in the group
 			if i == 0 {
-				now := time.Now()
 				var caption string
 				if requestType == RequestTypeToday {
 					caption = fmt.Sprintf("📅 Photos taken on %d.%d.%d", now.Day(), now.Month(), year)
@@ -643,11 +506,11 @@ func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbota
 
 			mediaGroup = append(mediaGroup, photo)
 			// Store original photo path for each processed photo
-			originalPhotos = append(originalPhotos, yearPhotos[i])
+			originalPhotos = append(originalPhotos, processedPhoto.Original)
 		}
 
 		mediaMsg := tgbotapi.NewMediaGroup(chatId, mediaGroup)
-		mediaMsg.ReplyParameters.MessageID = *replyMessageId
+		mediaMsg.ReplyParameters.MessageID = replyMessageId
 
 		// Set DisableNotification flag for all messages except the first one
 		if !isFirstMessage {
@@ -659,7 +522,7 @@ func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbota
 		sentMessages, err := sendMediaGroupWithRetry(bot, mediaMsg)
 		if err != nil {
 			log.Println("Failed to send memory photos after all retries:", err)
-			sendSafeReplyText(chatId, *replyMessageId, bot, fmt.Sprintf("Error sending photos for year %d: %v", year, err))
+			sendSafeReplyText(chatId, replyMessageId, bot, fmt.Sprintf("Error sending photos for year %d: %v", year, err))
 			continue
 		}
 
@@ -704,11 +567,9 @@ func sendMemoryPhotos(requestType PhotoRequestType, yearsAgo int, update *tgbota
 			storeSending(ps)
 		}
 	}
-
-	clearCompressedPhotos()
 }
 
-func handleReplyToPhotoInfo(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+func handleReplyToPhotoInfo(update tgbotapi.Update, bot Sender) {
 	// The message we are replying to is a single photo in the group
 	repliedMsgID := update.Message.ReplyToMessage.MessageID
 
@@ -728,7 +589,7 @@ func handleReplyToPhotoInfo(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	sendPhotoDescriptionMessage(update.Message.Chat.ID, update.Message.MessageID, bot, meta.PhotoPath)
 }
 
-func handleLastSendingInfo(update tgbotapi.Update, photoIndex int, bot *tgbotapi.BotAPI) {
+func handleLastSendingInfo(update tgbotapi.Update, photoIndex int, bot Sender) {
 	// 1) Get the highest sendingNumber
 	lastNumber, err := getLastSendingNumber()
 	if err != nil {
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// mediaType is a kind of photo file the bot indexes and sends
+type mediaType struct {
+	name       string
+	extensions []string                 // Lower case with the dot
+	convert    bool                     // Converted to JPEG before sending, Telegram doesn't show it as a photo
+	magic      func(header []byte) bool // Recognizes files without extension, nil if the type is not sniffed
+}
+
+// sniffHeaderSize is how many first bytes of a file are read to recognize its type
+const sniffHeaderSize = 32
+
+var builtinMediaTypes = []mediaType{
+	{name: "JPEG", extensions: []string{".jpg", ".jpeg", ".jpe"}, magic: func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte{0xFF, 0xD8, 0xFF})
+	}},
+	{name: "PNG", extensions: []string{".png"}, magic: func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte("\x89PNG\r\n\x1a\n"))
+	}},
+	{name: "GIF", extensions: []string{".gif"}, magic: func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte("GIF87a")) || bytes.HasPrefix(h, []byte("GIF89a"))
+	}},
+	{name: "WEBP", extensions: []string{".webp"}, magic: func(h []byte) bool {
+		return len(h) >= 12 && bytes.Equal(h[:4], []byte("RIFF")) && bytes.Equal(h[8:12], []byte("WEBP"))
+	}},
+	{name: "HEIC", extensions: []string{".heic", ".heif"}, convert: true, magic: func(h []byte) bool {
+		return hasFtypBrand(h, "heic", "heix", "hevc", "heim", "heis", "mif1", "msf1")
+	}},
+	{name: "AVIF", extensions: []string{".avif"}, convert: true, magic: func(h []byte) bool {
+		return hasFtypBrand(h, "avif", "avis")
+	}},
+	{name: "TIFF", extensions: []string{".tif", ".tiff"}, convert: true, magic: func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte("II*\x00")) || bytes.HasPrefix(h, []byte("MM\x00*"))
+	}},
+	{name: "JXL", extensions: []string{".jxl"}, convert: true, magic: func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte{0xFF, 0x0A}) ||
+			bytes.HasPrefix(h, []byte("\x00\x00\x00\x0cJXL \r\n\x87\n"))
+	}},
+}
+
+// hasFtypBrand reports whether an ISO media file header has one of brands, as HEIF and AVIF files do
+func hasFtypBrand(header []byte, brands ...string) bool {
+	if len(header) < 12 || !bytes.Equal(header[4:8], []byte("ftyp")) {
+		return false
+	}
+	for _, brand := range brands {
+		if string(header[8:12]) == brand {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaRegistry finds the media type of files by extension, case-insensitively
+type mediaRegistry struct {
+	types       []*mediaType
+	byExtension map[string]*mediaType
+	sniff       bool // Files without extension are recognized by their first bytes
+}
+
+// newMediaRegistry returns built-in types and a converted type for each extra extension, e.g. ".dng"
+func newMediaRegistry(extraExtensions []string, sniff bool) *mediaRegistry {
+	r := &mediaRegistry{byExtension: make(map[string]*mediaType), sniff: sniff}
+	for i := range builtinMediaTypes {
+		r.add(&builtinMediaTypes[i])
+	}
+
+	for _, ext := range extraExtensions {
+		ext = normalizeExtension(ext)
+		if ext == "." {
+			continue
+		}
+		if existing, ok := r.byExtension[ext]; ok {
+			log.Printf("Extension %s is already a %s photo", ext, existing.name)
+			continue
+		}
+		r.add(&mediaType{name: strings.ToUpper(strings.TrimPrefix(ext, ".")), extensions: []string{ext}, convert: true})
+	}
+	return r
+}
+
+func (r *mediaRegistry) add(t *mediaType) {
+	r.types = append(r.types, t)
+	for _, ext := range t.extensions {
+		r.byExtension[ext] = t
+	}
+}
+
+// normalizeExtension returns an extension in lower case with the dot
+func normalizeExtension(ext string) string {
+	return "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+}
+
+// typeOf returns the media type of a file or nil if it is not a photo
+func (r *mediaRegistry) typeOf(path string) *mediaType {
+	ext := filepath.Ext(path)
+	if ext != "" {
+		return r.byExtension[strings.ToLower(ext)]
+	}
+	if !r.sniff {
+		return nil
+	}
+	return r.sniffType(path)
+}
+
+// sniffType recognizes a file by its first bytes
+func (r *mediaRegistry) sniffType(path string) *mediaType {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	header := make([]byte, sniffHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil
+	}
+	header = header[:n]
+
+	for _, t := range r.types {
+		if t.magic != nil && t.magic(header) {
+			return t
+		}
+	}
+	return nil
+}
+
+// mediaTypes is the registry of photo files, set up in main from the config
+var mediaTypes = newMediaRegistry(nil, false)
+
+// initMediaTypes sets up the registry of photo files from the config
+func initMediaTypes() {
+	mediaTypes = newMediaRegistry(cfg.mediaExtensions, cfg.mediaSniff)
+}
+
+// isPhotoFile reports whether path is a photo of a known media type
+func isPhotoFile(path string) bool {
+	return mediaTypes.typeOf(path) != nil
+}
+
+const keyFormatStats = "FormatStats" // Key for files counted by type in the last library walk
+
+// FormatStats counts files of the library by type, for /formats
+type FormatStats struct {
+	Found           map[string]int `json:"found"`           // Media type -> photos
+	Skipped         map[string]int `json:"skipped"`         // Extension -> files which are not photos
+	ExcludedFiles   int            `json:"excludedFiles"`   // Photos skipped by patterns of roots
+	ExcludedFolders int            `json:"excludedFolders"` // Folders skipped by patterns of roots, their files are not counted
+	CountedAt       time.Time      `json:"countedAt"`
+}
+
+func newFormatStats() *FormatStats {
+	return &FormatStats{Found: make(map[string]int), Skipped: make(map[string]int)}
+}
+
+// count records a file of type t, nil if it is not a photo
+func (s *FormatStats) count(path string, t *mediaType) {
+	if t != nil {
+		s.Found[t.name]++
+		return
+	}
+	s.Skipped[strings.ToLower(filepath.Ext(path))]++
+}
+
+// saveFormatStats persists stats of a complete library walk
+func saveFormatStats(stats *FormatStats) error {
+	stats.CountedAt = time.Now()
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("error marshaling format stats: %v", err)
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingStats))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingStats)
+		}
+		return b.Put([]byte(keyFormatStats), data)
+	})
+}
+
+// getFormatStats returns stats of the last complete library walk or nil if the library was not walked yet
+func getFormatStats() (*FormatStats, error) {
+	var stats *FormatStats
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingStats))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingStats)
+		}
+
+		data := b.Get([]byte(keyFormatStats))
+		if data == nil {
+			return nil
+		}
+		stats = newFormatStats()
+		return json.Unmarshal(data, stats)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// formatCounts lists counts of a map sorted by count, largest first
+func formatCounts(counts map[string]int, name func(key string) string) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	var sb strings.Builder
+	for _, key := range keys {
+		sb.WriteString(fmt.Sprintf("\n%s: %d", name(key), counts[key]))
+	}
+	return sb.String()
+}
+
+// formatFormatsReport describes photo formats found in the library for /formats
+func formatFormatsReport(chatID int64) string {
+	var sb strings.Builder
+
+	stats, err := getFormatStats()
+	switch {
+	case err != nil:
+		sb.WriteString(fmt.Sprintf("Error getting format stats: %v\n\n", err))
+	case stats == nil:
+		sb.WriteString("Formats are counted when indexing walks the library, use /reindex diff\n\n")
+	default:
+		sb.WriteString(fmt.Sprintf("🗂 Files found by the last indexing (%s)\n", chatTime(chatID, stats.CountedAt).Format("02.01.2006 15:04")))
+		sb.WriteString("\nPhotos:")
+		if len(stats.Found) == 0 {
+			sb.WriteString("\nnone")
+		}
+		sb.WriteString(formatCounts(stats.Found, func(name string) string { return name }))
+
+		if len(stats.Skipped) > 0 {
+			sb.WriteString("\n\nSkipped files:")
+			sb.WriteString(formatCounts(stats.Skipped, func(ext string) string {
+				if ext == "" {
+					return "without extension"
+				}
+				return ext
+			}))
+		}
+		if stats.ExcludedFiles > 0 || stats.ExcludedFolders > 0 {
+			sb.WriteString(fmt.Sprintf("\n\nExcluded by root patterns: %d photos, %d folders",
+				stats.ExcludedFiles, stats.ExcludedFolders))
+		}
+		sb.WriteString("\n\n")
+	}
+
+	sb.WriteString("Supported formats:")
+	for _, t := range mediaTypes.types {
+		sb.WriteString(fmt.Sprintf("\n%s: %s", t.name, strings.Join(t.extensions, " ")))
+	}
+	if mediaTypes.sniff {
+		sb.WriteString("\nFiles without extension are recognized by their content")
+	}
+	return sb.String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMediaRegistryTypeOf(t *testing.T) {
+	dir := t.TempDir()
+	jpeg := filepath.Join(dir, "IMG_0001")
+	if err := os.WriteFile(jpeg, []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0x10}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	heic := filepath.Join(dir, "IMG_0002")
+	if err := os.WriteFile(heic, []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	text := filepath.Join(dir, "README")
+	if err := os.WriteFile(text, []byte("not a photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := newMediaRegistry([]string{"dng", ".ARW", ".jpg"}, true)
+	for path, want := range map[string]string{
+		"/a.Jpg":  "JPEG",
+		"/a.TIF":  "TIFF",
+		"/a.avif": "AVIF",
+		"/a.jxl":  "JXL",
+		"/a.DNG":  "DNG",
+		"/a.arw":  "ARW",
+		"/a.mov":  "",
+		jpeg:      "JPEG",
+		heic:      "HEIC",
+		text:      "",
+	} {
+		var got string
+		if mediaType := registry.typeOf(path); mediaType != nil {
+			got = mediaType.name
+		}
+		if got != want {
+			t.Errorf("typeOf(%s) = %q, want %q", path, got, want)
+		}
+	}
+
+	if newMediaRegistry(nil, false).typeOf(jpeg) != nil {
+		t.Error("want files without extension skipped without sniffing")
+	}
+	if mediaType := registry.typeOf("/a.dng"); mediaType == nil || !mediaType.convert {
+		t.Error("want extra formats converted before sending")
+	}
+}
+
+func TestFormatsCommand(t *testing.T) {
+	e := newTestEnv(t)
+	mediaTypes = newMediaRegistry(nil, true)
+	t.Cleanup(func() { mediaTypes = newMediaRegistry(nil, false) })
+
+	e.addPhoto(t, "a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+	upper := e.addPhoto(t, "B.JPG", time.Date(2020, 1, 3, 10, 0, 0, 0, time.Local))
+	extensionless := filepath.Join(e.library, "IMG_0003")
+	if err := os.Rename(e.addPhoto(t, "c.jpg", time.Date(2020, 1, 4, 10, 0, 0, 0, time.Local)), extensionless); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"notes.txt", "clip.mov", "clip2.MOV"} {
+		if err := os.WriteFile(filepath.Join(e.library, name), []byte("not a photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e.telegram.Reset()
+	e.send(t, testUserID, "/formats")
+	if got := texts(e.telegram.Calls("sendMessage")); !containsText(got, "use /reindex diff") {
+		t.Errorf("want hint before the library is walked, got %q", got)
+	}
+
+	e.index(t)
+	if !isIndexed(t, upper) || !isIndexed(t, extensionless) {
+		t.Error("want photos with upper case extension and without extension indexed")
+	}
+
+	e.telegram.Reset()
+	e.send(t, testUserID, "/formats")
+	got := texts(e.telegram.Calls("sendMessage"))
+	for _, want := range []string{"JPEG: 3", ".mov: 2", ".txt: 1", "AVIF: .avif", "recognized by their content"} {
+		if !containsText(got, want) {
+			t.Errorf("want %q in formats report, got %q", want, got)
+		}
+	}
+}
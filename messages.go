@@ -15,7 +15,7 @@ var startMessage = " 🖖 Hey! This is a Photo Moments bot, I can send random ph
 	"\n" +
 	"\nIm a open-source project, you can find me on https://github.com/Romancha/photo-moments-telegram-bot"
 
-func sendPhotoDescriptionMessage(chatId int64, messageId int, bot *tgbotapi.BotAPI, photoPath string) {
+func sendPhotoDescriptionMessage(chatId int64, messageId int, bot Sender, photoPath string) {
 	photoExif := getPhotoExif(photoPath)
 	if photoExif == nil {
 		log.Println("photoExif is nil, path:", photoPath)
@@ -24,6 +24,9 @@ func sendPhotoDescriptionMessage(chatId int64, messageId int, bot *tgbotapi.BotA
 
 	msg := "Photo description\n"
 	msg += "📂 " + photoPath + "\n"
+	if root := photoRootOf(cfg.photoRoots, photoPath); root != nil {
+		msg += "🗂 " + root.label + "\n"
+	}
 
 	var photoCamera string
 	if len(strings.TrimSpace(photoExif.Make)) > 0 {
@@ -63,33 +66,51 @@ func sendPhotoDescriptionMessage(chatId int64, messageId int, bot *tgbotapi.BotA
 }
 
 func getPhotoExif(photoPath string) *bimg.EXIF {
-	image, err := bimg.Read(photoPath)
+	exif, err := readPhotoExif(photoPath)
 	if err != nil {
 		log.Println(err)
 		return nil
 	}
+	return exif
+}
+
+// readPhotoExif reads EXIF of a photo
+func readPhotoExif(photoPath string) (*bimg.EXIF, error) {
+	image, err := bimg.Read(photoPath)
+	if err != nil {
+		return nil, err
+	}
 
 	imageMetadata, err := bimg.Metadata(image)
 	if err != nil {
-		log.Println(err)
-		return nil
+		return nil, err
 	}
 
-	return &imageMetadata.EXIF
+	return &imageMetadata.EXIF, nil
 }
 
-func sendRandomPhotoMessage(count int, update *tgbotapi.Update, bot *tgbotapi.BotAPI) {
+func sendRandomPhotoMessage(count int, update *tgbotapi.Update, bot Sender) {
 	var chatId int64
 	var replyMessageId *int
 	if update != nil {
 		chatId = update.Message.Chat.ID
 		replyMessageId = &update.Message.MessageID
 	} else {
-		chatId = cfg.chatId
+		chatId = currentConfig().chatId
 	}
 
+	sendPhotosMessage(chatId, replyMessageId, "📷 Sending random photos...", bot,
+		func(workspace *sendWorkspace) []SelectedPhoto {
+			return getRandomPhotos(count, workspace)
+		})
+}
+
+// sendPhotosMessage sends photos chosen by selectPhotos to chatId as a numbered sending,
+// replying to replyMessageId if it is not nil
+func sendPhotosMessage(chatId int64, replyMessageId *int, notice string, bot Sender,
+	selectPhotos func(workspace *sendWorkspace) []SelectedPhoto) {
 	// Уведомление с retry механизмом
-	notifyMsg := tgbotapi.NewMessage(chatId, "📷 Sending random photos...")
+	notifyMsg := tgbotapi.NewMessage(chatId, notice)
 	_, err := sendMessageWithRetry(bot, notifyMsg)
 	if err != nil {
 		log.Println("Failed to send notification message:", err)
@@ -98,14 +119,26 @@ func sendRandomPhotoMessage(count int, update *tgbotapi.Update, bot *tgbotapi.Bo
 	// 1) Get the next sending number
 	sendingNumber := getNextSendingNumber()
 
-	// 2) Gather photos
-	randomPhotoPaths := getRandomPhotos(count)
+	// 2) Gather photos into a workspace owned by this send
+	workspace, err := newSendWorkspace()
+	if err != nil {
+		log.Println("Failed to prepare photos:", err)
+		return
+	}
+	defer workspace.Close()
+
+	selectedPhotos := selectPhotos(workspace)
+	if len(selectedPhotos) == 0 {
+		log.Println("No photos to send")
+		return
+	}
+
 	var mediaGroup []interface{}
 
 	// We'll store the "originalPaths" in the DB too
 	var photoRecords []PhotoRecord
-	for i, path := range randomPhotoPaths {
-		photo := tgbotapi.NewInputMediaPhoto(tgbotapi.FilePath(path))
+	for i, selectedPhoto := range selectedPhotos {
+		photo := tgbotapi.NewInputMediaPhoto(tgbotapi.FilePath(selectedPhoto.Processed))
 
 		// set caption only for the first photo
 		if i == 0 {
@@ -130,7 +163,11 @@ func sendRandomPhotoMessage(count int, update *tgbotapi.Update, bot *tgbotapi.Bo
 	// 3) Store each photo's message ID individually
 	//    and also build the "PhotoSending" record
 	for i, msg := range sentMessages {
-		originalPath := lastPhotos[i]
+		if i >= len(selectedPhotos) {
+			log.Printf("Warning: sent message index %d exceeds photos length %d", i, len(selectedPhotos))
+			continue
+		}
+		originalPath := selectedPhotos[i].Original
 
 		// We'll store a single record:
 		// photoMsgID -> (sendingNumber, i+1, path)
@@ -151,6 +188,10 @@ func sendRandomPhotoMessage(count int, update *tgbotapi.Update, bot *tgbotapi.Bo
 		})
 	}
 
+	if len(sentMessages) == 0 {
+		return
+	}
+
 	// 4) Finally, store group-level sending record
 	ps := PhotoSending{
 		NumberOfSending: sendingNumber,
@@ -160,7 +201,7 @@ func sendRandomPhotoMessage(count int, update *tgbotapi.Update, bot *tgbotapi.Bo
 	storeSending(ps)
 }
 
-func sendSafeReplyText(chatId int64, replyMessageId int, bot *tgbotapi.BotAPI, text string) {
+func sendSafeReplyText(chatId int64, replyMessageId int, bot Sender, text string) {
 	msg := tgbotapi.NewMessage(chatId, text)
 	msg.ReplyParameters.MessageID = replyMessageId
 
@@ -171,41 +212,10 @@ func sendSafeReplyText(chatId int64, replyMessageId int, bot *tgbotapi.BotAPI, t
 }
 
 // sendIndexingStatusMessage sends a message with indexing status and returns the message ID
-func sendIndexingStatusMessage(chatId int64, replyMessageId int, bot *tgbotapi.BotAPI) (int, error) {
-	// Get indexing status
-	active, indexed, total, err := GetIndexingStatus()
+func sendIndexingStatusMessage(chatId int64, replyMessageId int, bot Sender) (int, error) {
+	statusMsg, err := formatIndexingStatus(chatId)
 	if err != nil {
-		return 0, fmt.Errorf("error getting indexing status: %v", err)
-	}
-
-	// Get last indexing time
-	lastIndexed, err := GetLastIndexedTime()
-	var lastIndexedStr string
-	if err == nil && !lastIndexed.IsZero() {
-		lastIndexedStr = lastIndexed.Format("02.01.2006 15:04:05")
-	} else {
-		lastIndexedStr = "unknown"
-	}
-
-	// Get last indexing duration
-	duration, err := GetIndexingDuration()
-	var durationStr string
-	if err == nil && duration > 0 {
-		durationStr = formatDuration(duration)
-	} else {
-		durationStr = "unknown"
-	}
-
-	var statusMsg string
-	if active {
-		statusMsg = fmt.Sprintf("⏳ Indexing is active. Indexed %d of %d photos (%.1f%%)",
-			indexed, total, float64(indexed)/float64(total)*100)
-	} else {
-		statusMsg = fmt.Sprintf("✅ Indexing completed. Indexed %d of %d photos (%.1f%%)\n"+
-			"Last indexing: %s\n"+
-			"Duration: %s",
-			indexed, total, float64(indexed)/float64(total)*100,
-			lastIndexedStr, durationStr)
+		return 0, err
 	}
 
 	msg := tgbotapi.NewMessage(chatId, statusMsg)
@@ -220,18 +230,63 @@ func sendIndexingStatusMessage(chatId int64, replyMessageId int, bot *tgbotapi.B
 }
 
 // updateIndexingStatusMessage updates an existing message with current indexing status
-func updateIndexingStatusMessage(chatId int64, messageId int, bot *tgbotapi.BotAPI) error {
+func updateIndexingStatusMessage(chatId int64, messageId int, bot Sender) error {
+	statusMsg, err := formatIndexingStatus(chatId)
+	if err != nil {
+		return err
+	}
+
+	editMsg := tgbotapi.NewEditMessageText(chatId, messageId, statusMsg)
+	_, err = sendMessageWithRetry(bot, editMsg)
+	if err != nil {
+		return fmt.Errorf("error updating indexing status message: %v", err)
+	}
+
+	return nil
+}
+
+// formatIndexingStatus returns the text of the indexing status message
+func formatIndexingStatus(chatId int64) (string, error) {
 	// Get indexing status
 	active, indexed, total, err := GetIndexingStatus()
 	if err != nil {
-		return fmt.Errorf("error getting indexing status: %v", err)
+		return "", fmt.Errorf("error getting indexing status: %v", err)
+	}
+
+	// Get throughput of the last runs
+	lastThroughput, previousThroughput, err := GetIndexingThroughput()
+	if err != nil {
+		log.Printf("Error getting indexing throughput: %v", err)
+	}
+
+	if active {
+		stats := runningIndexing.Load()
+		if stats == nil {
+			return "⏳ Indexing is active", nil
+		}
+
+		statusMsg := formatIndexingProgress(stats)
+		if lastThroughput > 0 {
+			statusMsg += fmt.Sprintf("\nLast run: %.1f photos/sec", lastThroughput)
+		}
+		return statusMsg, nil
+	}
+
+	job, err := getIndexingJob()
+	if err != nil {
+		log.Printf("Error reading indexing job: %v", err)
+	}
+	if job != nil && job.State == jobPaused {
+		return fmt.Sprintf("⏸ Indexing is paused at %d of %d photos. %d photos are indexed\n"+
+			"Use /reindex resume to continue or /reindex cancel to stop it",
+			job.Position, job.Total, indexed), nil
 	}
 
 	// Get last indexing time
 	lastIndexed, err := GetLastIndexedTime()
 	var lastIndexedStr string
 	if err == nil && !lastIndexed.IsZero() {
-		lastIndexedStr = lastIndexed.Format("02.01.2006 15:04:05")
+		lastIndexedStr = chatTime(chatId, lastIndexed).Format("02.01.2006 15:04:05")
 	} else {
 		lastIndexedStr = "unknown"
 	}
@@ -245,23 +300,93 @@ func updateIndexingStatusMessage(chatId int64, messageId int, bot *tgbotapi.BotA
 		durationStr = "unknown"
 	}
 
-	var statusMsg string
-	if active {
-		statusMsg = fmt.Sprintf("⏳ Indexing is active. Indexed %d of %d photos (%.1f%%)",
-			indexed, total, float64(indexed)/float64(total)*100)
-	} else {
-		statusMsg = fmt.Sprintf("✅ Indexing completed. Indexed %d of %d photos (%.1f%%)\n"+
-			"Last indexing: %s\n"+
-			"Duration: %s",
-			indexed, total, float64(indexed)/float64(total)*100,
-			lastIndexedStr, durationStr)
+	statusMsg := fmt.Sprintf("✅ Indexing completed. %d of %d photos are indexed (%.1f%%)\n"+
+		"Last indexing: %s\n"+
+		"Duration: %s",
+		indexed, total, percent(int64(indexed), int64(total)),
+		lastIndexedStr, durationStr)
+
+	if report := lastLibraryIndexingReport(); report != nil {
+		statusMsg += fmt.Sprintf("\nLast run: checked %d, changed %d, failed %d, removed %d",
+			report.Added+report.Updated+report.Skipped+report.Failed, report.Added+report.Updated,
+			report.Failed, report.Removed)
 	}
 
-	editMsg := tgbotapi.NewEditMessageText(chatId, messageId, statusMsg)
-	_, err = sendMessageWithRetry(bot, editMsg)
-	if err != nil {
-		return fmt.Errorf("error updating indexing status message: %v", err)
+	if lastThroughput > 0 {
+		statusMsg += "\n" + formatThroughput(lastThroughput, previousThroughput)
+	}
+
+	// Completion stats are of the last completed indexing, later jobs may have stopped early
+	switch {
+	case job != nil && job.State == jobCancelled:
+		statusMsg += fmt.Sprintf("\n⏹ Last indexing was cancelled at %d of %d photos", job.Position, job.Total)
+	case job != nil && job.State == jobFailed:
+		statusMsg += fmt.Sprintf("\n❌ Last indexing failed: %s", job.Error)
+	}
+	return statusMsg, nil
+}
+
+// formatIndexingProgress describes the phase and counters of the running indexing
+func formatIndexingProgress(stats *indexingRunStats) string {
+	phase := stats.getPhase()
+	statusMsg := fmt.Sprintf("⏳ Indexing is active, %s (%d of 4)", phase, phase+1)
+
+	scanned := stats.scanned.Load()
+	if phase == phaseWalking {
+		statusMsg += fmt.Sprintf("\nFound %d photos so far", scanned)
+		return statusMsg
 	}
+	statusMsg += fmt.Sprintf("\nFound %d photos in the library", scanned)
 
+	checked, toCheck := stats.checked.Load(), stats.toCheck.Load()
+	statusMsg += fmt.Sprintf("\nChecked %d of %d photos (%.1f%%)", checked, toCheck, percent(checked, toCheck))
+
+	changed, extracted := stats.changed.Load(), stats.extracted.Load()
+	statusMsg += fmt.Sprintf("\nChanged %d, extracted %d (%.1f%%), written %d in %d transactions",
+		changed, extracted, percent(extracted, changed), stats.written.Load(), stats.transactions.Load())
+
+	if phase == phaseExtracting {
+		statusMsg += fmt.Sprintf("\nThroughput: %.1f photos/sec", stats.throughput())
+	}
+	if phase == phaseCleanup {
+		removed, toRemove := stats.removed.Load(), stats.toRemove.Load()
+		statusMsg += fmt.Sprintf("\nRemoved %d of %d deleted photos (%.1f%%)", removed, toRemove, percent(removed, toRemove))
+	}
+	if eta, ok := stats.eta(); ok {
+		statusMsg += fmt.Sprintf("\nETA of this phase: %s", formatDuration(eta.Seconds()))
+	}
+	return statusMsg
+}
+
+// lastLibraryIndexingReport returns the latest report of full or differential indexing,
+// changes applied by the watcher are skipped
+func lastLibraryIndexingReport() *IndexingReport {
+	reports, err := GetIndexingReports(indexingHistoryShown)
+	if err != nil {
+		log.Printf("Error getting indexing history: %v", err)
+		return nil
+	}
+	for _, report := range reports {
+		if report.Type != indexTypeWatch {
+			return report
+		}
+	}
 	return nil
 }
+
+// percent returns done of total in percent, zero if total is zero
+func percent(done int64, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(done) / float64(total) * 100
+}
+
+// formatThroughput describes throughput of the last indexing compared to the run before it
+func formatThroughput(last float64, previous float64) string {
+	if previous <= 0 {
+		return fmt.Sprintf("Throughput: %.1f photos/sec", last)
+	}
+	return fmt.Sprintf("Throughput: %.1f photos/sec (previous run: %.1f photos/sec, %+.0f%%)",
+		last, previous, (last-previous)/previous*100)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Indexing workers hand extracted metadata to a single writer, which commits it in batches.
+// A batch is committed when it is full or when the interval passes, whichever comes first.
+const (
+	metadataBatchSize     = 500
+	metadataBatchInterval = time.Second
+)
+
+// metadataWriter saves metadata sent by indexing workers from a single goroutine
+type metadataWriter struct {
+	input     chan *PhotoMetadata
+	done      chan struct{}
+	stats     *indexingRunStats
+	batchSize int
+	interval  time.Duration
+}
+
+// newMetadataWriter starts a writer which counts saved photos in stats
+func newMetadataWriter(stats *indexingRunStats, batchSize int, interval time.Duration) *metadataWriter {
+	w := &metadataWriter{
+		input:     make(chan *PhotoMetadata, batchSize),
+		done:      make(chan struct{}),
+		stats:     stats,
+		batchSize: batchSize,
+		interval:  interval,
+	}
+	go w.run()
+	return w
+}
+
+// Write queues metadata for saving, it blocks while the queue is full
+func (w *metadataWriter) Write(metadata *PhotoMetadata) {
+	w.input <- metadata
+}
+
+// Close saves queued metadata and stops the writer. Write must not be called after Close.
+func (w *metadataWriter) Close() {
+	close(w.input)
+	<-w.done
+}
+
+func (w *metadataWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]*PhotoMetadata, 0, w.batchSize)
+	for {
+		select {
+		case metadata, ok := <-w.input:
+			if !ok {
+				w.commit(batch)
+				return
+			}
+			batch = append(batch, metadata)
+			if len(batch) < w.batchSize {
+				continue
+			}
+
+		case <-ticker.C:
+		}
+
+		w.commit(batch)
+		batch = batch[:0]
+	}
+}
+
+// commit saves a batch in one transaction. If the transaction fails, photos are saved one by one,
+// so a single broken photo doesn't lose the whole batch.
+func (w *metadataWriter) commit(batch []*PhotoMetadata) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, metadata := range batch {
+			err := savePhotoMetadataTx(tx, metadata)
+			if err != nil {
+				return fmt.Errorf("error saving metadata of %s: %v", metadata.Path, err)
+			}
+		}
+		return putIndexedCount(tx, int(w.stats.written.Load())+len(batch))
+	})
+	w.stats.transactions.Add(1)
+	if err == nil {
+		w.stats.written.Add(int64(len(batch)))
+		return
+	}
+
+	log.Printf("Error saving batch of %d photos, saving them one by one: %v", len(batch), err)
+	for _, metadata := range batch {
+		err := db.Update(func(tx *bolt.Tx) error {
+			err := savePhotoMetadataTx(tx, metadata)
+			if err != nil {
+				return err
+			}
+			return putIndexedCount(tx, int(w.stats.written.Load())+1)
+		})
+		w.stats.transactions.Add(1)
+		if err != nil {
+			log.Printf("Error saving metadata of %s: %v", metadata.Path, err)
+			continue
+		}
+		w.stats.written.Add(1)
+	}
+}
+
+// removePhotoBatch removes photos from the index in one transaction, counting them in stats.
+// If the transaction fails, photos are removed one by one like a failed batch of metadata is saved.
+func removePhotoBatch(paths []string, stats *indexingRunStats) {
+	if len(paths) == 0 {
+		return
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, path := range paths {
+			err := removePhotoFromIndexTx(tx, path)
+			if err != nil {
+				return fmt.Errorf("error removing %s: %v", path, err)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		stats.removed.Add(int64(len(paths)))
+		return
+	}
+
+	log.Printf("Error removing batch of %d deleted files, removing them one by one: %v", len(paths), err)
+	for _, path := range paths {
+		err := removePhotoFromIndex(path)
+		if err != nil {
+			log.Printf("Error removing deleted file from index: %v", err)
+			continue
+		}
+		stats.removed.Add(1)
+	}
+}
+
+// putIndexedCount persists the counter of photos saved by the run
+func putIndexedCount(tx *bolt.Tx, count int) error {
+	b := tx.Bucket([]byte(bucketIndexingStats))
+	if b == nil {
+		return fmt.Errorf("bucket %s not found", bucketIndexingStats)
+	}
+	return b.Put([]byte(keyIndexedCount), []byte(strconv.Itoa(count)))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMetadataWriterCommitsInBatches(t *testing.T) {
+	newTestEnv(t)
+
+	// A long interval leaves only the batch size and Close to commit
+	stats := newIndexingRunStats()
+	writer := newMetadataWriter(stats, 100, time.Hour)
+	for i := 0; i < 250; i++ {
+		writer.Write(&PhotoMetadata{Path: fmt.Sprintf("/photo%03d.jpg", i), Year: 2020, Month: 1, Day: 2})
+	}
+	writer.Close()
+
+	if got := stats.written.Load(); got != 250 {
+		t.Errorf("want 250 photos written, got %d", got)
+	}
+	if got := stats.transactions.Load(); got != 3 {
+		t.Errorf("want 3 transactions, got %d", got)
+	}
+
+	indexed, err := getIndexedCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 250 {
+		t.Errorf("want persisted counter 250, got %d", indexed)
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		metadataByPath, err := loadAllMetadata(tx)
+		if err != nil {
+			return err
+		}
+		if len(metadataByPath) != 250 {
+			t.Errorf("want 250 photos saved, got %d", len(metadataByPath))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexingStatusReportsThroughput(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	status, err := formatIndexingStatus(testChatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status, "Throughput: ") || strings.Contains(status, "previous run") {
+		t.Errorf("want throughput of the first run, got %q", status)
+	}
+
+	// The second run saving photos is compared with the first one
+	e.addPhoto(t, "b.jpg", time.Date(2021, 1, 2, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	status, err = formatIndexingStatus(testChatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status, "previous run: ") {
+		t.Errorf("want throughput compared to the previous run, got %q", status)
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -9,6 +12,7 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,38 +36,59 @@ type PhotoMetadata struct {
 	ModifiedTime time.Time `json:"modifiedTime"` // File last modification time
 	FileSize     int64     `json:"fileSize"`     // File size
 	FileHash     string    `json:"fileHash"`     // MD5 file hash (optional)
+
+	exifError error // Why EXIF could not be read during indexing, not saved
 }
 
 const (
-	bucketPhotoMetadata    = "PhotoMetadata"     // Bucket for storing photo metadata
-	bucketDateIndex        = "DateIndex"         // Bucket for date index (month-day -> list of paths)
-	bucketYearDateIndex    = "YearDateIndex"     // Bucket for year and date index (year-month-day -> list of paths)
-	bucketIndexingStats    = "IndexingStats"     // Bucket for indexing statistics
-	keyIndexingActive      = "IndexingActive"    // Key for indexing activity flag
-	keyIndexedCount        = "IndexedCount"      // Key for indexed photos counter
-	keyTotalCount          = "TotalCount"        // Key for total photos count
-	keyLastIndexedTime     = "LastIndexedTime"   // Key for last indexing time
-	keyAllIndexedFiles     = "AllIndexedFiles"   // Key for list of all indexed files
-	keyCalculateFileHashes = "CalculateHashes"   // Key for file hash calculation flag
-	keyIndexingStartTime   = "IndexingStartTime" // Key for indexing start time
-	keyIndexingDuration    = "IndexingDuration"  // Key for indexing duration (in seconds)
+	bucketPhotoMetadata    = "PhotoMetadata"      // Bucket for storing photo metadata
+	bucketDateIndex        = "DateIndex"          // Bucket for date index (month-day -> bucket of paths)
+	bucketYearDateIndex    = "YearDateIndex"      // Bucket for year and date index (year-month-day -> bucket of paths)
+	bucketIndexingStats    = "IndexingStats"      // Bucket for indexing statistics
+	bucketPhotoIDs         = "PhotoIDs"           // Bucket for dense photo ID index (id -> path), IDs are 0..N-1
+	bucketPhotoIDByPath    = "PhotoIDByPath"      // Bucket for reverse photo ID index (path -> id)
+	keyIndexingActive      = "IndexingActive"     // Key for indexing activity flag
+	keyIndexedCount        = "IndexedCount"       // Key for photos saved by the current or last indexing run
+	keyTotalCount          = "TotalCount"         // Key for total photos count
+	keyLastIndexedTime     = "LastIndexedTime"    // Key for last indexing time
+	keyAllIndexedFiles     = "AllIndexedFiles"    // Key for list of all indexed files
+	keyCalculateFileHashes = "CalculateHashes"    // Key for file hash calculation flag
+	keyIndexingStartTime   = "IndexingStartTime"  // Key for indexing start time
+	keyIndexingDuration    = "IndexingDuration"   // Key for indexing duration (in seconds)
+	keyIndexingCheckpoint  = "IndexingCheckpoint" // Key for checkpoint of interrupted indexing of older versions
+	keyIndexingThroughput  = "IndexingThroughput" // Key for photos saved per second by the last indexing
+	keyPreviousThroughput  = "PreviousThroughput" // Key for photos saved per second by the indexing before it
 )
 
 var (
 	indexingMutex sync.Mutex
+	indexingWG    sync.WaitGroup // Running indexing processes
 )
 
 // InitPhotoMetadata initializes buckets for photo metadata
 func InitPhotoMetadata() error {
 	return db.Update(func(tx *bolt.Tx) error {
 		// Create buckets if they don't exist
-		for _, bucketName := range []string{bucketPhotoMetadata, bucketDateIndex, bucketYearDateIndex, bucketIndexingStats} {
+		for _, bucketName := range []string{bucketPhotoMetadata, bucketDateIndex, bucketYearDateIndex, bucketIndexingStats,
+			bucketPhotoIDs, bucketPhotoIDByPath, bucketIndexingHistory} {
 			_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
 			if err != nil {
 				return fmt.Errorf("cannot create bucket %s: %v", bucketName, err)
 			}
 		}
 
+		// Date indexes of older versions are converted to the current layout
+		err := migrateDateIndexes(tx)
+		if err != nil {
+			return fmt.Errorf("cannot migrate date indexes: %v", err)
+		}
+
+		// Databases indexed before the ID index existed get it built from photo metadata
+		err = buildPhotoIDIndexIfEmpty(tx)
+		if err != nil {
+			return fmt.Errorf("cannot build photo ID index: %v", err)
+		}
+
 		// Set default value for hash calculation flag
 		b := tx.Bucket([]byte(bucketIndexingStats))
 		if b != nil {
@@ -79,13 +104,29 @@ func InitPhotoMetadata() error {
 	})
 }
 
-// StartBackgroundIndexing starts background photo indexing process
-func StartBackgroundIndexing(photoPath string, workerCount int) {
-	startIndexingProcess(photoPath, workerCount, false, false)
+// StartBackgroundIndexing starts background photo indexing process.
+// If previous indexing was interrupted, it is resumed. Paused indexing waits for /reindex resume.
+func StartBackgroundIndexing(ctx context.Context, roots []photoRoot, workerCount int) {
+	job, err := getIndexingJob()
+	if err != nil {
+		log.Printf("Error reading indexing job: %v", err)
+	}
+
+	switch {
+	case job != nil && job.State == jobRunning:
+		log.Printf("Resuming indexing interrupted at %d of %d photos", job.Position, job.Total)
+	case job != nil && job.State == jobPaused:
+		log.Printf("Indexing is paused at %d of %d photos, use /reindex resume to continue", job.Position, job.Total)
+		return
+	default:
+		job = newIndexingJob(false, false)
+	}
+
+	startIndexingProcess(ctx, roots, workerCount, job, triggerStartup)
 }
 
-// ForceReindexing starts forced full photo reindexing
-func ForceReindexing(photoPath string, workerCount int) error {
+// ForceReindexing starts forced full photo reindexing, trigger is recorded in the indexing history
+func ForceReindexing(ctx context.Context, roots []photoRoot, workerCount int, trigger string) error {
 	// Check if indexing is already active
 	active, _, _, err := GetIndexingStatus()
 	if err != nil {
@@ -96,6 +137,11 @@ func ForceReindexing(photoPath string, workerCount int) error {
 		return fmt.Errorf("indexing is already active, please wait for it to complete")
 	}
 
+	err = checkNoPausedIndexing()
+	if err != nil {
+		return err
+	}
+
 	// Clear existing indices
 	err = clearAllIndices()
 	if err != nil {
@@ -103,13 +149,14 @@ func ForceReindexing(photoPath string, workerCount int) error {
 	}
 
 	// Start indexing process
-	startIndexingProcess(photoPath, workerCount, true, false)
+	startIndexingProcess(ctx, roots, workerCount, newIndexingJob(true, false), trigger)
 
 	return nil
 }
 
-// StartDifferentialIndexing starts differential indexing (only new and modified files)
-func StartDifferentialIndexing(photoPath string, workerCount int) error {
+// StartDifferentialIndexing starts differential indexing (only new and modified files),
+// trigger is recorded in the indexing history
+func StartDifferentialIndexing(ctx context.Context, roots []photoRoot, workerCount int, trigger string) error {
 	// Check if indexing is already active
 	active, _, _, err := GetIndexingStatus()
 	if err != nil {
@@ -120,8 +167,13 @@ func StartDifferentialIndexing(photoPath string, workerCount int) error {
 		return fmt.Errorf("indexing is already active, please wait for it to complete")
 	}
 
+	err = checkNoPausedIndexing()
+	if err != nil {
+		return err
+	}
+
 	// Start indexing process
-	startIndexingProcess(photoPath, workerCount, false, true)
+	startIndexingProcess(ctx, roots, workerCount, newIndexingJob(false, true), trigger)
 
 	return nil
 }
@@ -172,7 +224,8 @@ func IsFileHashingEnabled() (bool, error) {
 func clearAllIndices() error {
 	return db.Update(func(tx *bolt.Tx) error {
 		// Delete and recreate buckets
-		for _, bucketName := range []string{bucketPhotoMetadata, bucketDateIndex, bucketYearDateIndex} {
+		for _, bucketName := range []string{bucketPhotoMetadata, bucketDateIndex, bucketYearDateIndex, bucketPhotoIDs,
+			bucketPhotoIDByPath} {
 			err := tx.DeleteBucket([]byte(bucketName))
 			if err != nil && err != bolt.ErrBucketNotFound {
 				return fmt.Errorf("error deleting bucket %s: %v", bucketName, err)
@@ -203,8 +256,10 @@ func clearAllIndices() error {
 	})
 }
 
-// startIndexingProcess starts the indexing process
-func startIndexingProcess(photoPath string, workerCount int, forceAll bool, cleanupDeleted bool) {
+// startIndexingProcess starts or continues the indexing job.
+// When ctx is cancelled or the job is paused, workers finish their current photo and the job position is saved.
+// Each run is recorded in the indexing history with its trigger.
+func startIndexingProcess(ctx context.Context, roots []photoRoot, workerCount int, job *IndexingJob, trigger string) {
 	indexingMutex.Lock()
 
 	// Check if indexing is already active
@@ -251,6 +306,12 @@ func startIndexingProcess(photoPath string, workerCount int, forceAll bool, clea
 			return err
 		}
 
+		// Saved photos are counted from zero for each run
+		err = b.Put([]byte(keyIndexedCount), []byte("0"))
+		if err != nil {
+			return err
+		}
+
 		return b.Put([]byte(keyIndexingActive), []byte("true"))
 	})
 
@@ -260,18 +321,51 @@ func startIndexingProcess(photoPath string, workerCount int, forceAll bool, clea
 		return
 	}
 
+	err = saveIndexingJob(job)
+	if err != nil {
+		log.Printf("Error saving indexing job: %v", err)
+	}
+
+	// The run is stopped by shutdown through ctx or by /reindex pause and cancel through its own context
+	runCtx, cancelRun := context.WithCancel(ctx)
+	run := &indexingRun{cancel: cancelRun}
+	activeRun = run
+
+	indexingWG.Add(1)
 	indexingMutex.Unlock()
 
 	go func() {
+		defer indexingWG.Done()
+		defer cancelRun()
+
+		// Keep progress in memory, the writer persists saved photos with each batch
+		stats := newIndexingRunStats()
+		runningIndexing.Store(stats)
+		report := newIndexingReport(job.indexType(), trigger)
 		defer func() {
-			// Reset indexing active flag when completed
 			indexingMutex.Lock()
-			err := db.Update(func(tx *bolt.Tx) error {
+			activeRun = nil
+
+			// The job and the report are saved before the active flag is reset,
+			// so indexing is never seen finished without them
+			err := saveIndexingJob(job)
+			if err != nil {
+				log.Printf("Error saving indexing job: %v", err)
+			}
+			saveIndexingReport(report, job.State)
+
+			// Reset indexing active flag when completed
+			err = db.Update(func(tx *bolt.Tx) error {
 				b := tx.Bucket([]byte(bucketIndexingStats))
 				if b == nil {
 					return fmt.Errorf("bucket %s not found", bucketIndexingStats)
 				}
 
+				// Stopped indexing is not complete, keep the last indexing time and duration
+				if job.State != jobCompleted {
+					return b.Put([]byte(keyIndexingActive), []byte("false"))
+				}
+
 				// Update last indexing time
 				err := b.Put([]byte(keyLastIndexedTime), []byte(time.Now().Format(time.RFC3339)))
 				if err != nil {
@@ -285,27 +379,96 @@ func startIndexingProcess(photoPath string, workerCount int, forceAll bool, clea
 					return err
 				}
 
+				// Save throughput of runs which saved photos, keeping the previous one for comparison
+				if stats.written.Load() > 0 {
+					if last := b.Get([]byte(keyIndexingThroughput)); last != nil {
+						err = b.Put([]byte(keyPreviousThroughput), append([]byte(nil), last...))
+						if err != nil {
+							return err
+						}
+					}
+					err = b.Put([]byte(keyIndexingThroughput), []byte(fmt.Sprintf("%.2f", stats.throughput())))
+					if err != nil {
+						return err
+					}
+				}
+
 				return b.Put([]byte(keyIndexingActive), []byte("false"))
 			})
 
 			if err != nil {
 				log.Printf("Error resetting indexing active flag: %v", err)
 			}
+			runningIndexing.Store(nil)
 
 			indexingMutex.Unlock()
 		}()
 
-		log.Println("Starting background indexing of photos")
+		// Changes being applied by the library watcher are saved first
+		indexingMutex.Lock()
+		applying := watcherApplying
+		indexingMutex.Unlock()
+		if applying != nil {
+			log.Println("Waiting for the library watcher to apply changes")
+			select {
+			case <-applying:
+			case <-runCtx.Done():
+				job.State = stoppedJobState(run)
+				return
+			}
+		}
 
-		// Get list of all photos
-		photos := find(photoPath, []string{".JPG", ".PNG", ".JPEG", ".jpg", ".png", ".jpeg", ".webp", ".WEBP", ".gif",
-			".GIF", ".HEIC", ".heic"})
+		// An unmounted library looks empty, cleanup would remove all photos from the index
+		for _, root := range enabledPhotoRoots(roots) {
+			if _, err := os.Stat(root.path); err != nil {
+				log.Printf("Indexing failed, photo root %s is not available: %v", root.label, err)
+				job.State = jobFailed
+				job.Error = fmt.Sprintf("photo root %s is not available: %v", root.label, err)
+				return
+			}
+		}
 
-		log.Printf("Found %d photos to index", len(photos))
+		// Get photos of the job, a resumed job continues with the photos listed when it started
+		var photos []string
+		if job.Listed {
+			photos, err = loadIndexingJobFiles()
+			if err != nil || len(photos) != job.Total {
+				log.Printf("Cannot load photos of the indexing job, walking the library again: %v", err)
+				photos = nil
+				job.Listed = false
+				job.Position = 0
+			}
+		}
+		if !job.Listed {
+			log.Println("Starting background indexing of photos")
+			formats := newFormatStats()
+			photos = findInRoots(runCtx, roots, &stats.scanned, formats, indexThrottle)
+			if runCtx.Err() != nil {
+				// The library walk is incomplete, it is repeated when the job continues
+				job.State = stoppedJobState(run)
+				return
+			}
+
+			err = saveFormatStats(formats)
+			if err != nil {
+				log.Printf("Error saving format stats: %v", err)
+			}
+
+			job.Total = len(photos)
+			err = saveIndexingJobFiles(photos)
+			if err != nil {
+				log.Printf("Error saving photos of the indexing job: %v", err)
+			} else {
+				job.Listed = true
+			}
+		}
+		stats.scanned.Store(int64(len(photos)))
+
+		log.Printf("Found %d photos to index, starting from %d", len(photos), job.Position)
 
 		// Get last indexing time
 		var lastIndexedTime time.Time
-		if !forceAll {
+		if !job.ForceAll {
 			lastIndexed, err := GetLastIndexedTime()
 			if err == nil {
 				lastIndexedTime = lastIndexed
@@ -333,150 +496,322 @@ func startIndexingProcess(photoPath string, workerCount int, forceAll bool, clea
 			log.Printf("Error saving total photo count: %v", err)
 		}
 
-		// If need to clean up deleted files, create map of all current files
-		var currentFiles map[string]bool
-		if cleanupDeleted {
-			currentFiles = make(map[string]bool, len(photos))
-			for _, photo := range photos {
-				currentFiles[photo] = true
-			}
+		if workerCount < 1 {
+			workerCount = 1
 		}
+		log.Printf("Indexing with %d workers", workerCount)
+
+		// Check which photos are new or changed, an interrupted check is repeated when the job continues
+		stats.toCheck.Store(int64(len(photos) - job.Position))
+		stats.setPhase(phaseChecking)
+		pending := checkChangedPhotos(runCtx, photos, job.Position, job.ForceAll, lastIndexedTime, workerCount,
+			stats, report)
+		if runCtx.Err() != nil {
+			job.State = stoppedJobState(run)
+			log.Printf("Indexing stopped while checking photos, job is %s", job.State)
+			return
+		}
+		log.Printf("Checked %d photos, %d are new or changed", stats.checked.Load(), len(pending))
 
-		// Create channel for processing photos
-		photoChan := make(chan string, workerCount)
+		// Extract changed photos
+		stats.setPhase(phaseExtracting)
+		writer := newMetadataWriter(stats, metadataBatchSize, metadataBatchInterval)
+		pendingChan := make(chan pendingPhoto, workerCount)
 		var wg sync.WaitGroup
 
+		// Photos skipped because the run was stopped are indexed when the job continues
+		var skippedMutex sync.Mutex
+		firstSkipped := len(photos)
+
 		// Start workers for processing photos
 		for i := 0; i < workerCount; i++ {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				for photoPath := range photoChan {
-					// Check if this photo is already indexed in the database and if it needs to be reindexed
-					var needsIndexing bool = true
-					var existingMetadata *PhotoMetadata
-
-					if !forceAll {
-						err := db.View(func(tx *bolt.Tx) error {
-							b := tx.Bucket([]byte(bucketPhotoMetadata))
-							if b == nil {
-								return fmt.Errorf("bucket %s not found", bucketPhotoMetadata)
-							}
-
-							metadataBytes := b.Get([]byte(photoPath))
-							if metadataBytes != nil {
-								// Photo is already indexed, check modification time
-								var metadata PhotoMetadata
-								err := json.Unmarshal(metadataBytes, &metadata)
-								if err != nil {
-									return fmt.Errorf("error unmarshaling metadata: %v", err)
-								}
-
-								existingMetadata = &metadata
-
-								// Get file information
-								fileInfo, err := os.Stat(photoPath)
-								if err != nil {
-									return fmt.Errorf("error getting file info: %v", err)
-								}
-
-								modTime := fileInfo.ModTime()
-								fileSize := fileInfo.Size()
-
-								// Check if file has changed since last indexing
-								if modTime.After(lastIndexedTime) ||
-									modTime.After(metadata.IndexedAt) ||
-									fileSize != metadata.FileSize {
-									// File changed, need to reindex
-									needsIndexing = true
-								} else {
-									// File not changed, skip
-									needsIndexing = false
-								}
-							}
-
-							return nil
-						})
-
-						if err != nil {
-							log.Printf("Error checking if photo needs indexing: %v", err)
-							continue
-						}
-					}
-
-					if !needsIndexing {
-						// Photo not changed, skip
+				for photo := range pendingChan {
+					photoPath := photos[photo.position]
+					metadata, outcome, err := extractPendingPhotoMetadata(runCtx, photoPath, photo.existing,
+						calculateHashes)
+					if err != nil && runCtx.Err() != nil {
+						skippedMutex.Lock()
+						firstSkipped = min(firstSkipped, photo.position)
+						skippedMutex.Unlock()
 						continue
 					}
-
-					// Extract metadata
-					metadata, err := extractPhotoMetadata(photoPath, calculateHashes)
+					stats.extracted.Add(1)
 					if err != nil {
-						log.Printf("Error extracting metadata for %s: %v", photoPath, err)
+						log.Printf("Error indexing %s: %v", photoPath, err)
+						report.fail(photoPath, err)
 						continue
 					}
-
-					// If there are existing metadata, save some fields
-					if existingMetadata != nil {
-						// Save file hash, if it was calculated before and not calculated now
-						if !calculateHashes && existingMetadata.FileHash != "" {
-							metadata.FileHash = existingMetadata.FileHash
-						}
-					}
-
-					// Save metadata to database
-					err = savePhotoMetadata(metadata)
-					if err != nil {
-						log.Printf("Error saving metadata for %s: %v", photoPath, err)
-					}
-
-					// Increment indexed photos counter
-					err = db.Update(func(tx *bolt.Tx) error {
-						b := tx.Bucket([]byte(bucketIndexingStats))
-						if b == nil {
-							return fmt.Errorf("bucket %s not found", bucketIndexingStats)
-						}
-
-						countBytes := b.Get([]byte(keyIndexedCount))
-						var count int
-						if countBytes != nil {
-							count, _ = strconv.Atoi(string(countBytes))
-						}
-
-						count++
-						return b.Put([]byte(keyIndexedCount), []byte(strconv.Itoa(count)))
-					})
-
-					if err != nil {
-						log.Printf("Error updating indexed count: %v", err)
-					}
+					report.count(outcome, metadata)
+					writer.Write(metadata)
 				}
 			}()
 		}
 
-		// Send photos to processing channel
-		for _, photo := range photos {
-			photoChan <- photo
+		// Send photos to processing channel until indexing is stopped
+		next := 0
+	feed:
+		for ; next < len(pending); next++ {
+			select {
+			case <-runCtx.Done():
+				break feed
+			case pendingChan <- pending[next]:
+			}
 		}
 
 		// Close channel and wait for all workers to complete
-		close(photoChan)
+		close(pendingChan)
 		wg.Wait()
+		writer.Close()
+		log.Printf("Saved %d photos in %d transactions", stats.written.Load(), stats.transactions.Load())
+
+		// Unchanged photos before the first photo left are checked again when the job continues
+		job.Position = firstSkipped
+		if next < len(pending) {
+			job.Position = min(job.Position, pending[next].position)
+		}
+		if job.Position < len(photos) {
+			job.State = stoppedJobState(run)
+			log.Printf("Indexing stopped at %d of %d photos, job is %s", job.Position, len(photos), job.State)
+			return
+		}
 
 		// If need to clean up deleted files
-		if cleanupDeleted {
+		if job.CleanupDeleted {
 			log.Println("Cleaning up deleted files from index")
-			cleanupDeletedFiles(currentFiles)
+			stats.setPhase(phaseCleanup)
+			currentFiles := make(map[string]bool, len(photos))
+			for _, photo := range photos {
+				currentFiles[photo] = true
+			}
+			report.removed(cleanupDeletedFiles(currentFiles, stats))
 		}
 
+		job.State = jobCompleted
 		log.Println("Background indexing completed")
 	}()
 }
 
-// cleanupDeletedFiles removes files from index that no longer exist on disk
-func cleanupDeletedFiles(currentFiles map[string]bool) {
-	// Get list of all indexed files
-	var allIndexedFiles []string
+// pendingPhoto is a new or changed photo found by checkChangedPhotos
+type pendingPhoto struct {
+	position int            // Position in photos of the job
+	existing *PhotoMetadata // Indexed metadata of a changed photo, nil for a new one
+}
+
+// checkChangedPhotos checks photos from position start and returns new or changed ones in the order of photos.
+// Unchanged and failed photos are counted in report. It stops early when ctx is cancelled.
+func checkChangedPhotos(ctx context.Context, photos []string, start int, forceAll bool, lastIndexedTime time.Time,
+	workerCount int, stats *indexingRunStats, report *IndexingReport) []pendingPhoto {
+	var pending []pendingPhoto
+	var pendingMutex sync.Mutex
+	positions := make(chan int, workerCount)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for position := range positions {
+				// In low priority mode checking is limited like reading photos
+				if indexThrottle.wait(ctx) != nil {
+					continue
+				}
+				changed, existing, err := checkPhotoChanged(photos[position], forceAll, lastIndexedTime)
+				stats.checked.Add(1)
+				if err != nil {
+					log.Printf("Error indexing %s: %v", photos[position], err)
+					report.fail(photos[position], err)
+					continue
+				}
+				if !changed {
+					report.count(outcomeSkipped, nil)
+					continue
+				}
+
+				stats.changed.Add(1)
+				pendingMutex.Lock()
+				pending = append(pending, pendingPhoto{position: position, existing: existing})
+				pendingMutex.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for position := start; position < len(photos); position++ {
+		select {
+		case <-ctx.Done():
+			break feed
+		case positions <- position:
+		}
+	}
+	close(positions)
+	wg.Wait()
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].position < pending[j].position
+	})
+	return pending
+}
+
+// stoppedJobState returns the state of a job whose run was stopped before all photos were indexed
+func stoppedJobState(run *indexingRun) string {
+	indexingMutex.Lock()
+	defer indexingMutex.Unlock()
+
+	if run.stopAs != "" {
+		return run.stopAs
+	}
+	// Stopped by shutdown, the job is resumed on next start
+	return jobRunning
+}
+
+// indexPhoto extracts and saves metadata of a photo if it is new or changed since it was indexed.
+// It returns true if the photo was indexed.
+// The result is counted in report.
+func indexPhoto(ctx context.Context, photoPath string, forceAll bool, lastIndexedTime time.Time,
+	calculateHashes bool, report *IndexingReport) (bool, error) {
+	metadata, outcome, err := extractChangedPhotoMetadata(ctx, photoPath, forceAll, lastIndexedTime, calculateHashes)
+	if err == nil && metadata != nil {
+		// Save metadata to database
+		err = savePhotoMetadata(metadata)
+		if err != nil {
+			err = fmt.Errorf("error saving metadata: %v", err)
+		}
+	}
+
+	if err != nil {
+		report.fail(photoPath, err)
+		return false, err
+	}
+	report.count(outcome, metadata)
+	return metadata != nil, nil
+}
+
+// extractChangedPhotoMetadata extracts metadata of a photo if it is new or changed since it was indexed.
+// It returns nil metadata if the photo is not changed. In low priority mode it waits before reading the photo.
+func extractChangedPhotoMetadata(ctx context.Context, photoPath string, forceAll bool, lastIndexedTime time.Time,
+	calculateHashes bool) (*PhotoMetadata, indexOutcome, error) {
+	needsIndexing, existingMetadata, err := checkPhotoChanged(photoPath, forceAll, lastIndexedTime)
+	if err != nil {
+		return nil, outcomeSkipped, err
+	}
+	if !needsIndexing {
+		return nil, outcomeSkipped, nil
+	}
+	return extractPendingPhotoMetadata(ctx, photoPath, existingMetadata, calculateHashes)
+}
+
+// checkPhotoChanged reports whether a photo is new or changed since it was indexed.
+// It also returns metadata of an indexed photo which changed.
+func checkPhotoChanged(photoPath string, forceAll bool, lastIndexedTime time.Time) (bool, *PhotoMetadata, error) {
+	// Full reindexing extracts all photos
+	if forceAll {
+		return true, nil, nil
+	}
+
+	// Check if this photo is already indexed in the database and if it needs to be reindexed
+	var needsIndexing bool = true
+	var existingMetadata *PhotoMetadata
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketPhotoMetadata))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketPhotoMetadata)
+		}
+
+		metadataBytes := b.Get([]byte(photoPath))
+		if metadataBytes != nil {
+			// Photo is already indexed, check modification time
+			var metadata PhotoMetadata
+			err := json.Unmarshal(metadataBytes, &metadata)
+			if err != nil {
+				return fmt.Errorf("error unmarshaling metadata: %v", err)
+			}
+
+			existingMetadata = &metadata
+
+			// Get file information
+			fileInfo, err := os.Stat(photoPath)
+			if err != nil {
+				return fmt.Errorf("error getting file info: %v", err)
+			}
+
+			modTime := fileInfo.ModTime()
+			fileSize := fileInfo.Size()
+
+			// Check if file has changed since last indexing
+			if modTime.After(lastIndexedTime) ||
+				modTime.After(metadata.IndexedAt) ||
+				fileSize != metadata.FileSize {
+				// File changed, need to reindex
+				needsIndexing = true
+			} else {
+				// File not changed, skip
+				needsIndexing = false
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return false, nil, fmt.Errorf("error checking if photo needs indexing: %v", err)
+	}
+	return needsIndexing, existingMetadata, nil
+}
+
+// extractPendingPhotoMetadata extracts metadata of a new or changed photo, existingMetadata is nil for a new one.
+// In low priority mode it waits before reading the photo.
+func extractPendingPhotoMetadata(ctx context.Context, photoPath string, existingMetadata *PhotoMetadata,
+	calculateHashes bool) (*PhotoMetadata, indexOutcome, error) {
+	err := indexThrottle.wait(ctx)
+	if err != nil {
+		return nil, outcomeSkipped, err
+	}
+
+	// Extract metadata
+	metadata, err := extractPhotoMetadata(photoPath, calculateHashes)
+	if err != nil {
+		return nil, outcomeSkipped, fmt.Errorf("error extracting metadata: %v", err)
+	}
+
+	// If there are existing metadata, save some fields
+	if existingMetadata != nil {
+		// Save file hash, if it was calculated before and not calculated now
+		if !calculateHashes && existingMetadata.FileHash != "" {
+			metadata.FileHash = existingMetadata.FileHash
+		}
+	}
+
+	if existingMetadata != nil {
+		return metadata, outcomeUpdated, nil
+	}
+	return metadata, outcomeAdded, nil
+}
+
+// WaitForIndexing waits for running indexing to stop. It returns false on timeout.
+func WaitForIndexing(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		indexingWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// cleanupDeletedFiles removes files from index that no longer exist on disk and returns their number.
+// They are removed in batches like metadata is saved, counted in stats.
+func cleanupDeletedFiles(currentFiles map[string]bool, stats *indexingRunStats) int {
+	// Get indexed files which are not in the current list
+	var deletedFiles []string
 
 	err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucketPhotoMetadata))
@@ -485,234 +820,396 @@ func cleanupDeletedFiles(currentFiles map[string]bool) {
 		}
 
 		return b.ForEach(func(k, v []byte) error {
-			filePath := string(k)
-			allIndexedFiles = append(allIndexedFiles, filePath)
+			if filePath := string(k); !currentFiles[filePath] {
+				deletedFiles = append(deletedFiles, filePath)
+			}
 			return nil
 		})
 	})
 
 	if err != nil {
 		log.Printf("Error getting all indexed files: %v", err)
-		return
+		return 0
 	}
 
-	// Check each file and remove those that no longer exist in the current list
-	var deletedCount int
-	for _, filePath := range allIndexedFiles {
-		if !currentFiles[filePath] {
-			// File was deleted, remove it from index
-			err := removePhotoFromIndex(filePath)
-			if err != nil {
-				log.Printf("Error removing deleted file from index: %v", err)
-			} else {
-				deletedCount++
-			}
-		}
+	stats.toRemove.Store(int64(len(deletedFiles)))
+	for start := 0; start < len(deletedFiles); start += metadataBatchSize {
+		removePhotoBatch(deletedFiles[start:min(start+metadataBatchSize, len(deletedFiles))], stats)
 	}
 
+	deletedCount := int(stats.removed.Load())
 	log.Printf("Removed %d deleted files from index", deletedCount)
+	return deletedCount
 }
 
-// removePhotoFromIndex removes photo from all indices
-func removePhotoFromIndex(photoPath string) error {
-	return db.Update(func(tx *bolt.Tx) error {
-		// Get photo metadata
-		bMetadata := tx.Bucket([]byte(bucketPhotoMetadata))
-		if bMetadata == nil {
+// removeIndexedPhotosUnder removes photo at path or all photos in directory path from index.
+// The path no longer exists, so whether it was a file or a directory is only known from the index.
+func removeIndexedPhotosUnder(path string) (int, error) {
+	var paths []string
+	dirPrefix := strings.TrimSuffix(path, string(os.PathSeparator)) + string(os.PathSeparator)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketPhotoMetadata))
+		if b == nil {
 			return fmt.Errorf("bucket %s not found", bucketPhotoMetadata)
 		}
 
-		metadataBytes := bMetadata.Get([]byte(photoPath))
-		if metadataBytes == nil {
-			// Photo not found in index
-			return nil
+		if b.Get([]byte(path)) != nil {
+			paths = append(paths, path)
 		}
 
-		var metadata PhotoMetadata
-		err := json.Unmarshal(metadataBytes, &metadata)
-		if err != nil {
-			return fmt.Errorf("error unmarshaling metadata: %v", err)
-		}
-
-		// Remove from index by date (month-day)
-		bDateIndex := tx.Bucket([]byte(bucketDateIndex))
-		if bDateIndex != nil {
-			dateKey := fmt.Sprintf("%02d-%02d", metadata.Month, metadata.Day)
-			pathsData := bDateIndex.Get([]byte(dateKey))
-			if pathsData != nil {
-				var paths []string
-				err = json.Unmarshal(pathsData, &paths)
-				if err == nil {
-					// Remove path from list
-					var newPaths []string
-					for _, path := range paths {
-						if path != photoPath {
-							newPaths = append(newPaths, path)
-						}
-					}
-
-					// Save updated list
-					if len(newPaths) > 0 {
-						pathsData, err = json.Marshal(newPaths)
-						if err == nil {
-							err = bDateIndex.Put([]byte(dateKey), pathsData)
-							if err != nil {
-								log.Printf("Error updating date index: %v", err)
-							}
-						}
-					} else {
-						// If list is empty, delete key
-						err = bDateIndex.Delete([]byte(dateKey))
-						if err != nil {
-							log.Printf("Error deleting date index key: %v", err)
-						}
-					}
-				}
-			}
+		// Keys are sorted, so photos in the directory follow its prefix
+		c := b.Cursor()
+		for k, _ := c.Seek([]byte(dirPrefix)); k != nil && strings.HasPrefix(string(k), dirPrefix); k, _ = c.Next() {
+			paths = append(paths, string(k))
 		}
+		return nil
+	})
 
-		// Remove from index by year and date (year-month-day)
-		bYearDateIndex := tx.Bucket([]byte(bucketYearDateIndex))
-		if bYearDateIndex != nil {
-			yearDateKey := fmt.Sprintf("%04d-%02d-%02d", metadata.Year, metadata.Month, metadata.Day)
-			pathsData := bYearDateIndex.Get([]byte(yearDateKey))
-			if pathsData != nil {
-				var paths []string
-				err = json.Unmarshal(pathsData, &paths)
-				if err == nil {
-					// Remove path from list
-					var newPaths []string
-					for _, path := range paths {
-						if path != photoPath {
-							newPaths = append(newPaths, path)
-						}
-					}
-
-					// Save updated list
-					if len(newPaths) > 0 {
-						pathsData, err = json.Marshal(newPaths)
-						if err == nil {
-							err = bYearDateIndex.Put([]byte(yearDateKey), pathsData)
-							if err != nil {
-								log.Printf("Error updating year date index: %v", err)
-							}
-						}
-					} else {
-						// If list is empty, delete key
-						err = bYearDateIndex.Delete([]byte(yearDateKey))
-						if err != nil {
-							log.Printf("Error deleting year date index key: %v", err)
-						}
-					}
-				}
-			}
-		}
+	if err != nil {
+		return 0, err
+	}
 
-		// Remove photo metadata
-		err = bMetadata.Delete([]byte(photoPath))
+	for _, photoPath := range paths {
+		err := removePhotoFromIndex(photoPath)
 		if err != nil {
-			return fmt.Errorf("error deleting metadata: %v", err)
+			return 0, fmt.Errorf("error removing %s from index: %v", photoPath, err)
 		}
+	}
 
-		return nil
+	return len(paths), nil
+}
+
+// removePhotoFromIndex removes photo from all indices
+func removePhotoFromIndex(photoPath string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return removePhotoFromIndexTx(tx, photoPath)
 	})
 }
 
-// savePhotoMetadata saves photo metadata to database
+// removePhotoFromIndexTx removes photo from all indices in tx
+func removePhotoFromIndexTx(tx *bolt.Tx, photoPath string) error {
+	// Get photo metadata
+	bMetadata := tx.Bucket([]byte(bucketPhotoMetadata))
+	if bMetadata == nil {
+		return fmt.Errorf("bucket %s not found", bucketPhotoMetadata)
+	}
+
+	metadataBytes := bMetadata.Get([]byte(photoPath))
+	if metadataBytes == nil {
+		// Photo not found in index
+		return nil
+	}
+
+	var metadata PhotoMetadata
+	err := json.Unmarshal(metadataBytes, &metadata)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling metadata: %v", err)
+	}
+
+	// Remove from indices by date
+	err = removeFromDateIndices(tx, &metadata)
+	if err != nil {
+		return err
+	}
+
+	// Remove from photo ID index
+	err = removePhotoID(tx, photoPath)
+	if err != nil {
+		return fmt.Errorf("error removing photo ID: %v", err)
+	}
+
+	// Remove photo metadata
+	err = bMetadata.Delete([]byte(photoPath))
+	if err != nil {
+		return fmt.Errorf("error deleting metadata: %v", err)
+	}
+
+	return nil
+}
+
+// savePhotoMetadata saves photo metadata to database.
+// Index entries of the previously saved metadata are replaced, so a re-indexed photo is listed once
+// and only under its current date.
 func savePhotoMetadata(metadata *PhotoMetadata) error {
 	return db.Update(func(tx *bolt.Tx) error {
-		// Save photo metadata
-		bMetadata := tx.Bucket([]byte(bucketPhotoMetadata))
-		if bMetadata == nil {
-			return fmt.Errorf("bucket %s not found", bucketPhotoMetadata)
-		}
+		return savePhotoMetadataTx(tx, metadata)
+	})
+}
+
+// savePhotoMetadataTx saves photo metadata and its index entries in tx
+func savePhotoMetadataTx(tx *bolt.Tx, metadata *PhotoMetadata) error {
+	// Save photo metadata
+	bMetadata := tx.Bucket([]byte(bucketPhotoMetadata))
+	if bMetadata == nil {
+		return fmt.Errorf("bucket %s not found", bucketPhotoMetadata)
+	}
 
-		// Marshal metadata to JSON
-		data, err := json.Marshal(metadata)
+	// Remove date index entries of the previous metadata, its date may have changed
+	if previousBytes := bMetadata.Get([]byte(metadata.Path)); previousBytes != nil {
+		var previous PhotoMetadata
+		err := json.Unmarshal(previousBytes, &previous)
 		if err != nil {
-			return fmt.Errorf("error marshaling metadata: %v", err)
+			log.Printf("Error unmarshaling previous metadata of %s: %v", metadata.Path, err)
+		} else {
+			err = removeFromDateIndices(tx, &previous)
+			if err != nil {
+				return err
+			}
 		}
+	}
 
-		// Save metadata
-		err = bMetadata.Put([]byte(metadata.Path), data)
+	// Marshal metadata to JSON
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error marshaling metadata: %v", err)
+	}
+
+	// Save metadata
+	err = bMetadata.Put([]byte(metadata.Path), data)
+	if err != nil {
+		return fmt.Errorf("error saving metadata: %v", err)
+	}
+
+	// Update indices by date
+	err = addToDateIndices(tx, metadata)
+	if err != nil {
+		return err
+	}
+
+	// Add to photo ID index used for random selection
+	err = addPhotoID(tx, metadata.Path)
+	if err != nil {
+		return fmt.Errorf("error saving photo ID: %v", err)
+	}
+
+	return nil
+}
+
+// dateIndexKey returns the date index key "month-day"
+func dateIndexKey(metadata *PhotoMetadata) string {
+	return fmt.Sprintf("%02d-%02d", metadata.Month, metadata.Day)
+}
+
+// yearDateIndexKey returns the year and date index key "year-month-day"
+func yearDateIndexKey(metadata *PhotoMetadata) string {
+	return fmt.Sprintf("%04d-%02d-%02d", metadata.Year, metadata.Month, metadata.Day)
+}
+
+// addToDateIndices adds photo to the date and year-date indices
+func addToDateIndices(tx *bolt.Tx, metadata *PhotoMetadata) error {
+	err := addToDateIndex(tx, bucketDateIndex, dateIndexKey(metadata), metadata.Path)
+	if err != nil {
+		return fmt.Errorf("error saving date index: %v", err)
+	}
+
+	err = addToDateIndex(tx, bucketYearDateIndex, yearDateIndexKey(metadata), metadata.Path)
+	if err != nil {
+		return fmt.Errorf("error saving year date index: %v", err)
+	}
+	return nil
+}
+
+// removeFromDateIndices removes photo from the date and year-date indices
+func removeFromDateIndices(tx *bolt.Tx, metadata *PhotoMetadata) error {
+	err := removeFromDateIndex(tx, bucketDateIndex, dateIndexKey(metadata), metadata.Path)
+	if err != nil {
+		return fmt.Errorf("error updating date index: %v", err)
+	}
+
+	err = removeFromDateIndex(tx, bucketYearDateIndex, yearDateIndexKey(metadata), metadata.Path)
+	if err != nil {
+		return fmt.Errorf("error updating year date index: %v", err)
+	}
+	return nil
+}
+
+// photoIDKey encodes a photo ID as a big-endian key, so IDs are sorted numerically
+func photoIDKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// photoIDCount returns the number of photos in the ID index.
+// IDs are dense, so the count is the last ID + 1.
+func photoIDCount(bIDs *bolt.Bucket) uint64 {
+	lastKey, _ := bIDs.Cursor().Last()
+	if lastKey == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(lastKey) + 1
+}
+
+// addPhotoID assigns the next free ID to photoPath if it has none
+func addPhotoID(tx *bolt.Tx, photoPath string) error {
+	bIDs := tx.Bucket([]byte(bucketPhotoIDs))
+	bIDByPath := tx.Bucket([]byte(bucketPhotoIDByPath))
+	if bIDs == nil || bIDByPath == nil {
+		return fmt.Errorf("photo ID buckets not found")
+	}
+
+	if bIDByPath.Get([]byte(photoPath)) != nil {
+		return nil
+	}
+
+	key := photoIDKey(photoIDCount(bIDs))
+	err := bIDs.Put(key, []byte(photoPath))
+	if err != nil {
+		return err
+	}
+	return bIDByPath.Put([]byte(photoPath), key)
+}
+
+// removePhotoID removes photoPath from the ID index.
+// The photo with the last ID takes the freed ID, so IDs stay dense.
+func removePhotoID(tx *bolt.Tx, photoPath string) error {
+	bIDs := tx.Bucket([]byte(bucketPhotoIDs))
+	bIDByPath := tx.Bucket([]byte(bucketPhotoIDByPath))
+	if bIDs == nil || bIDByPath == nil {
+		return fmt.Errorf("photo ID buckets not found")
+	}
+
+	keyData := bIDByPath.Get([]byte(photoPath))
+	if keyData == nil {
+		return nil
+	}
+	// Copy the key, bolt values are only valid until the next modification
+	key := append([]byte(nil), keyData...)
+
+	lastKey, lastPathData := bIDs.Cursor().Last()
+	lastKey = append([]byte(nil), lastKey...)
+	lastPath := string(lastPathData)
+
+	if lastPath != photoPath {
+		// Move the last photo to the freed ID
+		err := bIDs.Put(key, []byte(lastPath))
 		if err != nil {
-			return fmt.Errorf("error saving metadata: %v", err)
+			return err
 		}
-
-		// Update date index (month-day)
-		bDateIndex := tx.Bucket([]byte(bucketDateIndex))
-		if bDateIndex == nil {
-			return fmt.Errorf("bucket %s not found", bucketDateIndex)
+		err = bIDByPath.Put([]byte(lastPath), key)
+		if err != nil {
+			return err
 		}
+	}
+
+	err := bIDs.Delete(lastKey)
+	if err != nil {
+		return err
+	}
+	return bIDByPath.Delete([]byte(photoPath))
+}
 
-		// Date index key: "month-day"
-		dateKey := fmt.Sprintf("%02d-%02d", metadata.Month, metadata.Day)
+// buildPhotoIDIndexIfEmpty fills the ID index from photo metadata if the index is empty
+func buildPhotoIDIndexIfEmpty(tx *bolt.Tx) error {
+	bIDs := tx.Bucket([]byte(bucketPhotoIDs))
+	bMetadata := tx.Bucket([]byte(bucketPhotoMetadata))
+	if bIDs == nil || bMetadata == nil {
+		return fmt.Errorf("photo ID or metadata bucket not found")
+	}
 
-		// Get current paths list for this date
-		var paths []string
-		pathsData := bDateIndex.Get([]byte(dateKey))
-		if pathsData != nil {
-			err = json.Unmarshal(pathsData, &paths)
-			if err != nil {
-				return fmt.Errorf("error unmarshaling paths: %v", err)
-			}
-		}
+	if photoIDCount(bIDs) > 0 {
+		return nil
+	}
 
-		// Add new path
-		paths = append(paths, metadata.Path)
+	// Collect paths first, buckets can't be modified while iterating
+	var paths []string
+	err := bMetadata.ForEach(func(k, v []byte) error {
+		paths = append(paths, string(k))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		// Save updated list
-		pathsData, err = json.Marshal(paths)
+	for _, path := range paths {
+		err := addPhotoID(tx, path)
 		if err != nil {
-			return fmt.Errorf("error marshaling paths: %v", err)
+			return err
 		}
+	}
 
-		err = bDateIndex.Put([]byte(dateKey), pathsData)
-		if err != nil {
-			return fmt.Errorf("error saving date index: %v", err)
+	if len(paths) > 0 {
+		log.Printf("Built photo ID index for %d photos", len(paths))
+	}
+	return nil
+}
+
+// GetRandomIndexedPhotos returns up to count distinct random photo paths from the ID index.
+// It returns nothing if the index is empty.
+func GetRandomIndexedPhotos(count int) ([]string, error) {
+	var photos []string
+
+	err := db.View(func(tx *bolt.Tx) error {
+		bIDs := tx.Bucket([]byte(bucketPhotoIDs))
+		if bIDs == nil {
+			return fmt.Errorf("bucket %s not found", bucketPhotoIDs)
 		}
 
-		// Update year and date index (year-month-day)
-		bYearDateIndex := tx.Bucket([]byte(bucketYearDateIndex))
-		if bYearDateIndex == nil {
-			return fmt.Errorf("bucket %s not found", bucketYearDateIndex)
+		total := photoIDCount(bIDs)
+		if total == 0 {
+			return nil
 		}
 
-		// Date index key: "year-month-day"
-		yearDateKey := fmt.Sprintf("%04d-%02d-%02d", metadata.Year, metadata.Month, metadata.Day)
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		picked := make(map[uint64]bool)
+		for uint64(len(picked)) < total && len(picked) < count {
+			id := uint64(rnd.Int63n(int64(total)))
+			if picked[id] {
+				continue
+			}
+			picked[id] = true
 
-		// Get current paths list for this date and year
-		var yearPaths []string
-		yearPathsData := bYearDateIndex.Get([]byte(yearDateKey))
-		if yearPathsData != nil {
-			err = json.Unmarshal(yearPathsData, &yearPaths)
-			if err != nil {
-				return fmt.Errorf("error unmarshaling year paths: %v", err)
+			path := bIDs.Get(photoIDKey(id))
+			if path != nil {
+				photos = append(photos, string(path))
 			}
 		}
 
-		// Add new path
-		yearPaths = append(yearPaths, metadata.Path)
+		return nil
+	})
 
-		// Save updated list
-		yearPathsData, err = json.Marshal(yearPaths)
-		if err != nil {
-			return fmt.Errorf("error marshaling year paths: %v", err)
-		}
+	if err != nil {
+		return nil, err
+	}
 
-		err = bYearDateIndex.Put([]byte(yearDateKey), yearPathsData)
-		if err != nil {
-			return fmt.Errorf("error saving year date index: %v", err)
+	return photos, nil
+}
+
+// GetIndexedPhotosInFolder returns indexed photos inside folder and its subfolders
+func GetIndexedPhotosInFolder(folder string) ([]string, error) {
+	var photos []string
+	prefix := []byte(filepath.Clean(folder) + string(os.PathSeparator))
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketPhotoMetadata))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketPhotoMetadata)
 		}
 
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			photos = append(photos, string(k))
+		}
 		return nil
 	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return photos, nil
 }
 
 // extractPhotoMetadata extracts metadata from photo
 func extractPhotoMetadata(photoPath string, calculateHash bool) (*PhotoMetadata, error) {
 	// Read EXIF data
-	exif := getPhotoExif(photoPath)
+	exifLimit.acquire()
+	exif, exifErr := readPhotoExif(photoPath)
+	exifLimit.release()
+	if exifErr != nil {
+		log.Printf("Error reading EXIF of %s: %v", photoPath, exifErr)
+	}
 
 	// Get file information
 	fileInfo, err := os.Stat(photoPath)
@@ -720,16 +1217,23 @@ func extractPhotoMetadata(photoPath string, calculateHash bool) (*PhotoMetadata,
 		return nil, fmt.Errorf("error getting file info: %v", err)
 	}
 
+	// Photos without a date in EXIF are dated by the modification time in FM_TIME_ZONE,
+	// as "this day" of memories is
+	modTime := fileInfo.ModTime().In(currentConfig().timeZone())
+
 	metadata := &PhotoMetadata{
 		Path:         photoPath,
 		IndexedAt:    time.Now(),
 		ModifiedTime: fileInfo.ModTime(),
 		FileSize:     fileInfo.Size(),
+		exifError:    exifErr,
 	}
 
 	// Calculate file hash if needed
 	if calculateHash {
+		hashLimit.acquire()
 		hash, err := calculateMD5(photoPath)
+		hashLimit.release()
 		if err == nil {
 			metadata.FileHash = hash
 		} else {
@@ -772,24 +1276,24 @@ func extractPhotoMetadata(photoPath string, calculateHash bool) (*PhotoMetadata,
 				metadata.Day = t.Day()
 			} else {
 				// If unable to parse date from EXIF, use file creation date
-				metadata.TakenDate = fileInfo.ModTime()
-				metadata.Year = fileInfo.ModTime().Year()
-				metadata.Month = int(fileInfo.ModTime().Month())
-				metadata.Day = fileInfo.ModTime().Day()
+				metadata.TakenDate = modTime
+				metadata.Year = modTime.Year()
+				metadata.Month = int(modTime.Month())
+				metadata.Day = modTime.Day()
 			}
 		} else {
 			// If no date in EXIF, use file creation date
-			metadata.TakenDate = fileInfo.ModTime()
-			metadata.Year = fileInfo.ModTime().Year()
-			metadata.Month = int(fileInfo.ModTime().Month())
-			metadata.Day = fileInfo.ModTime().Day()
+			metadata.TakenDate = modTime
+			metadata.Year = modTime.Year()
+			metadata.Month = int(modTime.Month())
+			metadata.Day = modTime.Day()
 		}
 	} else {
 		// If no EXIF data, use file creation date
-		metadata.TakenDate = fileInfo.ModTime()
-		metadata.Year = fileInfo.ModTime().Year()
-		metadata.Month = int(fileInfo.ModTime().Month())
-		metadata.Day = fileInfo.ModTime().Day()
+		metadata.TakenDate = modTime
+		metadata.Year = modTime.Year()
+		metadata.Month = int(modTime.Month())
+		metadata.Day = modTime.Day()
 	}
 
 	return metadata, nil
@@ -811,9 +1315,8 @@ func calculateMD5(filePath string) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// GetPhotosFromPast returns photos taken on this day in previous years
-func GetPhotosFromPast(yearsAgo int, limit int) ([]string, error) {
-	now := time.Now()
+// GetPhotosFromPast returns photos taken on the day of now yearsAgo years before
+func GetPhotosFromPast(now time.Time, yearsAgo int, limit int) ([]string, error) {
 	month := int(now.Month())
 	day := now.Day()
 	year := now.Year() - yearsAgo
@@ -823,25 +1326,10 @@ func GetPhotosFromPast(yearsAgo int, limit int) ([]string, error) {
 
 	var photos []string
 	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketYearDateIndex))
-		if b == nil {
-			return fmt.Errorf("bucket %s not found", bucketYearDateIndex)
-		}
-
-		// Get paths list for this date and year
-		pathsData := b.Get([]byte(yearDateKey))
-		if pathsData == nil {
-			// No photos for this date and year
-			return nil
-		}
-
-		// Unmarshal paths list
-		err := json.Unmarshal(pathsData, &photos)
-		if err != nil {
-			return fmt.Errorf("error unmarshaling paths: %v", err)
-		}
-
-		return nil
+		// Get paths for this date and year
+		var err error
+		photos, err = getDateIndexPaths(tx, bucketYearDateIndex, yearDateKey)
+		return err
 	})
 
 	if err != nil {
@@ -865,9 +1353,8 @@ func GetPhotosFromPast(yearsAgo int, limit int) ([]string, error) {
 	return filteredPhotos, nil
 }
 
-// GetPhotosFromThisDay returns photos taken on this day in different years
-func GetPhotosFromThisDay(limit int) ([]string, error) {
-	now := time.Now()
+// GetPhotosFromThisDay returns photos taken on the day of now in different years
+func GetPhotosFromThisDay(now time.Time, limit int) ([]string, error) {
 	month := int(now.Month())
 	day := now.Day()
 
@@ -876,25 +1363,10 @@ func GetPhotosFromThisDay(limit int) ([]string, error) {
 
 	var photos []string
 	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketDateIndex))
-		if b == nil {
-			return fmt.Errorf("bucket %s not found", bucketDateIndex)
-		}
-
-		// Get paths list for this date
-		pathsData := b.Get([]byte(dateKey))
-		if pathsData == nil {
-			// No photos for this date
-			return nil
-		}
-
-		// Unmarshal paths list
-		err := json.Unmarshal(pathsData, &photos)
-		if err != nil {
-			return fmt.Errorf("error unmarshaling paths: %v", err)
-		}
-
-		return nil
+		// Get paths for this date
+		var err error
+		photos, err = getDateIndexPaths(tx, bucketDateIndex, dateKey)
+		return err
 	})
 
 	if err != nil {
@@ -918,7 +1390,28 @@ func GetPhotosFromThisDay(limit int) ([]string, error) {
 	return filteredPhotos, nil
 }
 
-// GetIndexingStatus returns indexing status
+// getIndexedCount returns the persisted counter of photos saved by the current or last indexing run
+func getIndexedCount() (int, error) {
+	var indexed int
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingStats))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingStats)
+		}
+
+		indexedBytes := b.Get([]byte(keyIndexedCount))
+		if indexedBytes != nil {
+			indexed, _ = strconv.Atoi(string(indexedBytes))
+		}
+		return nil
+	})
+
+	return indexed, err
+}
+
+// GetIndexingStatus returns whether indexing is active, the number of photos in the index
+// and the number of photos found in the library by the last indexing
 func GetIndexingStatus() (bool, int, int, error) {
 	var active bool
 	var indexed, total int
@@ -936,10 +1429,11 @@ func GetIndexingStatus() (bool, int, int, error) {
 		}
 
 		// Get indexed photos count
-		indexedBytes := b.Get([]byte(keyIndexedCount))
-		if indexedBytes != nil {
-			indexed, _ = strconv.Atoi(string(indexedBytes))
+		bIDs := tx.Bucket([]byte(bucketPhotoIDs))
+		if bIDs == nil {
+			return fmt.Errorf("bucket %s not found", bucketPhotoIDs)
 		}
+		indexed = int(photoIDCount(bIDs))
 
 		// Get total photos count
 		totalBytes := b.Get([]byte(keyTotalCount))
@@ -956,9 +1450,7 @@ func GetIndexingStatus() (bool, int, int, error) {
 
 	// If total photos count was not saved, get it
 	if total == 0 {
-		photoPath := cfg.photoPath
-		photos := find(photoPath, []string{".JPG", ".PNG", ".JPEG", ".jpg", ".png", ".jpeg", ".webp", ".WEBP", ".gif",
-			".GIF", ".HEIC", ".heic"})
+		photos := findInRoots(context.Background(), cfg.photoRoots, nil, nil, nil)
 		total = len(photos)
 	}
 
@@ -1033,6 +1525,32 @@ func GetIndexingDuration() (float64, error) {
 	return duration, nil
 }
 
+// GetIndexingThroughput returns photos saved per second by the last indexing and the one before it,
+// zero if unknown
+func GetIndexingThroughput() (float64, float64, error) {
+	var last, previous float64
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketIndexingStats))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketIndexingStats)
+		}
+
+		if lastBytes := b.Get([]byte(keyIndexingThroughput)); lastBytes != nil {
+			last, _ = strconv.ParseFloat(string(lastBytes), 64)
+		}
+		if previousBytes := b.Get([]byte(keyPreviousThroughput)); previousBytes != nil {
+			previous, _ = strconv.ParseFloat(string(previousBytes), 64)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+	return last, previous, nil
+}
+
 // ResetIndexingFlagIfStuck checks if indexing flag is stuck and resets it
 func ResetIndexingFlagIfStuck() error {
 	// Get indexing status
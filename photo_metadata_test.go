@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestInterruptedIndexingIsResumed(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+	e.addPhoto(t, "b.jpg", time.Date(2021, 1, 2, 10, 0, 0, 0, time.Local))
+
+	// Indexing stopped by shutdown keeps the job running and resets the active flag
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := StartDifferentialIndexing(ctx, cfg.photoRoots, 2, triggerUser); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitForIndexing(5 * time.Second) {
+		t.Fatal("indexing did not stop")
+	}
+
+	active, _, _, err := GetIndexingStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active {
+		t.Error("want indexing flag reset after interruption")
+	}
+
+	job, err := getIndexingJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.State != jobRunning || !job.CleanupDeleted {
+		t.Fatalf("want running job of differential indexing, got %+v", job)
+	}
+
+	// Next start resumes indexing and completes the job
+	StartBackgroundIndexing(context.Background(), cfg.photoRoots, 2)
+	if !WaitForIndexing(5 * time.Second) {
+		t.Fatal("indexing did not complete")
+	}
+
+	job, err = getIndexingJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != jobCompleted || !job.CleanupDeleted {
+		t.Errorf("want completed differential job, got %+v", job)
+	}
+
+	_, indexed, _, err := GetIndexingStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 2 {
+		t.Errorf("want 2 indexed photos, got %d", indexed)
+	}
+}
+
+// assertPhotoIDIndex verifies that IDs are 0..N-1 and both buckets point to each other
+func assertPhotoIDIndex(t *testing.T, want []string) {
+	t.Helper()
+
+	err := db.View(func(tx *bolt.Tx) error {
+		bIDs := tx.Bucket([]byte(bucketPhotoIDs))
+		bIDByPath := tx.Bucket([]byte(bucketPhotoIDByPath))
+
+		total := photoIDCount(bIDs)
+		if total != uint64(len(want)) {
+			t.Errorf("want %d IDs, got %d", len(want), total)
+		}
+
+		var got []string
+		for id := uint64(0); id < total; id++ {
+			path := bIDs.Get(photoIDKey(id))
+			if path == nil {
+				t.Errorf("ID %d is missing", id)
+				continue
+			}
+			if key := bIDByPath.Get(path); string(key) != string(photoIDKey(id)) {
+				t.Errorf("reverse index of %s does not point to ID %d", path, id)
+			}
+			got = append(got, string(path))
+		}
+
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("want paths %v, got %v", want, got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPhotoIDIndexStaysDense(t *testing.T) {
+	newTestEnv(t)
+
+	paths := []string{"/a.jpg", "/b.jpg", "/c.jpg", "/d.jpg"}
+	for _, path := range paths {
+		if err := savePhotoMetadata(&PhotoMetadata{Path: path, Year: 2020, Month: 1, Day: 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saving a photo again keeps its ID
+	if err := savePhotoMetadata(&PhotoMetadata{Path: "/b.jpg", Year: 2020, Month: 1, Day: 2}); err != nil {
+		t.Fatal(err)
+	}
+	assertPhotoIDIndex(t, paths)
+
+	// Removing a photo in the middle moves the last one to its ID
+	if err := removePhotoFromIndex("/b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	assertPhotoIDIndex(t, []string{"/a.jpg", "/c.jpg", "/d.jpg"})
+
+	// Removing the last photo
+	if err := removePhotoFromIndex("/c.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	assertPhotoIDIndex(t, []string{"/a.jpg", "/d.jpg"})
+
+	photos, err := GetRandomIndexedPhotos(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photos) != 2 || photos[0] == photos[1] {
+		t.Errorf("want both photos once, got %v", photos)
+	}
+}
+
+func TestPhotoIDIndexIsBuiltForExistingMetadata(t *testing.T) {
+	newTestEnv(t)
+
+	paths := []string{"/a.jpg", "/b.jpg"}
+	for _, path := range paths {
+		if err := savePhotoMetadata(&PhotoMetadata{Path: path, Year: 2020, Month: 1, Day: 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Database indexed by a version without the ID index
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(bucketPhotoIDs)); err != nil {
+			return err
+		}
+		return tx.DeleteBucket([]byte(bucketPhotoIDByPath))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitPhotoMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	assertPhotoIDIndex(t, paths)
+}
+
+func TestRandomPhotosWithoutIndexWalkLibrary(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+	e.addPhoto(t, "b.jpg", time.Date(2021, 1, 2, 10, 0, 0, 0, time.Local))
+
+	photos := selectRandomPhotoPaths(5)
+	if len(photos) != 2 {
+		t.Errorf("want 2 photos from the library, got %v", photos)
+	}
+
+	// Indexed photos deleted from disk are skipped
+	e.index(t)
+	if err := os.Remove(filepath.Join(e.library, "a.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	photos = selectRandomPhotoPaths(5)
+	if len(photos) != 1 || filepath.Base(photos[0]) != "b.jpg" {
+		t.Errorf("want only b.jpg, got %v", photos)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// photoRoot is a folder of the photo library. Photos are filtered by glob patterns
+// matched against the path relative to the root, where * matches any characters including /
+// and ? matches a single character. So */Screenshots/* skips Screenshots folders at any depth.
+type photoRoot struct {
+	label        string
+	path         string
+	includeGlobs []string // Only matching photos are indexed, all photos if empty
+	excludeGlobs []string // Matching photos and folders are skipped
+	enabled      bool
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newPhotoRoot(label string, path string, includeGlobs []string, excludeGlobs []string, enabled bool) photoRoot {
+	root := photoRoot{
+		label:        label,
+		path:         filepath.Clean(path),
+		includeGlobs: includeGlobs,
+		excludeGlobs: excludeGlobs,
+		enabled:      enabled,
+	}
+	for _, glob := range includeGlobs {
+		root.include = append(root.include, globToRegexp(glob))
+	}
+	for _, glob := range excludeGlobs {
+		root.exclude = append(root.exclude, globToRegexp(glob))
+	}
+	return root
+}
+
+// globToRegexp converts a glob pattern to an anchored regular expression
+func globToRegexp(glob string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range filepath.ToSlash(glob) {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+// matchGlobs reports whether the relative path matches any of patterns.
+// The path is also matched with a leading /, so */Screenshots/* matches Screenshots in the root itself.
+func matchGlobs(patterns []*regexp.Regexp, rel string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(rel) || pattern.MatchString("/"+rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// relPath returns path relative to the root with / separators
+func (r *photoRoot) relPath(path string) string {
+	rel, err := filepath.Rel(r.path, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// contains reports whether path is the root or inside it
+func (r *photoRoot) contains(path string) bool {
+	rel, err := filepath.Rel(r.path, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// skipDir reports whether a folder inside the root is excluded
+func (r *photoRoot) skipDir(path string) bool {
+	if path == r.path {
+		return false
+	}
+	return matchGlobs(r.exclude, r.relPath(path)+"/")
+}
+
+// allows reports whether a photo inside the root passes its include and exclude patterns
+func (r *photoRoot) allows(path string) bool {
+	rel := r.relPath(path)
+	if matchGlobs(r.exclude, rel) {
+		return false
+	}
+	return len(r.include) == 0 || matchGlobs(r.include, rel)
+}
+
+// find walks the root and returns its photos, counting them in found and files by type in formats if they are not nil.
+// Each photo waits for throttle, nil doesn't throttle.
+func (r *photoRoot) find(ctx context.Context, found *atomic.Int64, formats *FormatStats,
+	throttle *indexingThrottle) []string {
+	return walkPhotos(ctx, r.path, found, formats, func(path string, isDir bool) bool {
+		if isDir {
+			return r.skipDir(path)
+		}
+		return !r.allows(path)
+	}, throttle)
+}
+
+// photoRootOf returns the root containing path, the innermost one if roots are nested, or nil
+func photoRootOf(roots []photoRoot, path string) *photoRoot {
+	var found *photoRoot
+	for i := range roots {
+		if roots[i].contains(path) && (found == nil || len(roots[i].path) > len(found.path)) {
+			found = &roots[i]
+		}
+	}
+	return found
+}
+
+// photoRootAllows reports whether path belongs to an enabled root and passes its patterns
+func photoRootAllows(roots []photoRoot, path string) bool {
+	root := photoRootOf(roots, path)
+	return root != nil && root.enabled && root.allows(path)
+}
+
+// enabledPhotoRoots returns roots which are indexed
+func enabledPhotoRoots(roots []photoRoot) []photoRoot {
+	var enabled []photoRoot
+	for _, root := range roots {
+		if root.enabled {
+			enabled = append(enabled, root)
+		}
+	}
+	return enabled
+}
+
+// findInRoots walks enabled roots and returns their photos, counting them in found and files by type in formats
+// if they are not nil. A photo in nested roots is returned once, filtered by the innermost root,
+// but it is counted for each root. Each photo waits for throttle, nil doesn't throttle.
+func findInRoots(ctx context.Context, roots []photoRoot, found *atomic.Int64, formats *FormatStats,
+	throttle *indexingThrottle) []string {
+	var photos []string
+	seen := make(map[string]bool)
+	for _, root := range enabledPhotoRoots(roots) {
+		for _, photo := range root.find(ctx, found, formats, throttle) {
+			if seen[photo] || !photoRootAllows(roots, photo) {
+				continue
+			}
+			seen[photo] = true
+			photos = append(photos, photo)
+		}
+	}
+	return photos
+}
+
+// defaultPhotoRootLabel is the label of the library set by FM_PHOTO_PATH
+const defaultPhotoRootLabel = "library"
+
+// parsePhotoRoots parses roots like "family=/photos/family;phone=/photos/phone", a root without label
+// is labeled by its folder name. Patterns and enable flags of each root are read from
+// FM_PHOTO_ROOT_<LABEL>_INCLUDE, _EXCLUDE and _ENABLED. If roots are not set, the library is defaultPath.
+func parsePhotoRoots(p *configParser, defaultPath string) []photoRoot {
+	var roots []photoRoot
+	labels := make(map[string]bool)
+	for _, entry := range strings.Split(p.string(keyPhotoRoots, defaultPhotoRootLabel+"="+defaultPath), ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		label, path, found := strings.Cut(entry, "=")
+		if !found {
+			path = label
+			label = filepath.Base(filepath.Clean(path))
+		}
+		label, path = strings.TrimSpace(label), strings.TrimSpace(path)
+		if label == "" || path == "" {
+			p.fail(keyPhotoRoots, "must be roots like label=path, got %q", entry)
+			continue
+		}
+		if labels[label] {
+			p.fail(keyPhotoRoots, "has duplicate label %q", label)
+			continue
+		}
+		labels[label] = true
+
+		roots = append(roots, newPhotoRoot(label, path,
+			p.list(photoRootEnvKey(label, "INCLUDE")),
+			p.list(photoRootEnvKey(label, "EXCLUDE")),
+			p.bool(photoRootEnvKey(label, "ENABLED"), true)))
+	}
+	if len(labels) == 0 {
+		roots = append(roots, newPhotoRoot(defaultPhotoRootLabel, defaultPath, nil, nil, true))
+	}
+
+	if len(enabledPhotoRoots(roots)) == 0 {
+		log.Println("All photo roots are disabled, no photos will be indexed")
+	}
+	return roots
+}
+
+// photoRootEnvKey returns the name of a root setting, e.g. FM_PHOTO_ROOT_FAMILY_EXCLUDE
+func photoRootEnvKey(label string, setting string) string {
+	return keyPhotoRootPrefix + labelKey(label) + "_" + setting
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParsePhotoRoots(t *testing.T) {
+	t.Setenv("FM_PHOTO_ROOTS", "family photos=/photos/family;/photos/phone;broken=")
+	t.Setenv("FM_PHOTO_ROOT_FAMILY_PHOTOS_EXCLUDE", "*/Screenshots/*; *.gif")
+	t.Setenv("FM_PHOTO_ROOT_PHONE_ENABLED", "false")
+
+	p := &configParser{}
+	roots := parsePhotoRoots(p, "/photoLibrary")
+	if len(roots) != 2 {
+		t.Fatalf("want 2 roots, got %+v", roots)
+	}
+	if roots[0].label != "family photos" || roots[0].path != "/photos/family" || !roots[0].enabled ||
+		len(roots[0].excludeGlobs) != 2 {
+		t.Errorf("want family root with exclude patterns, got %+v", roots[0])
+	}
+	if roots[1].label != "phone" || roots[1].enabled {
+		t.Errorf("want disabled root labeled by its folder, got %+v", roots[1])
+	}
+	if len(p.errs) != 1 {
+		t.Errorf("want the broken root reported, got %v", p.errs)
+	}
+
+	t.Setenv("FM_PHOTO_ROOTS", "")
+	roots = parsePhotoRoots(&configParser{}, "/photoLibrary")
+	if len(roots) != 1 || roots[0].label != defaultPhotoRootLabel || roots[0].path != "/photoLibrary" {
+		t.Errorf("want library of FM_PHOTO_PATH, got %+v", roots)
+	}
+}
+
+func TestPhotoRootPatterns(t *testing.T) {
+	root := newPhotoRoot("r", "/r", []string{"*.jpg"}, []string{"*/Screenshots/*", "*/.thumbnails/*"}, true)
+
+	for path, want := range map[string]bool{
+		"/r/a.jpg":                   true,
+		"/r/2020/a.jpg":              true,
+		"/r/a.png":                   false,
+		"/r/Screenshots/a.jpg":       false,
+		"/r/phone/Screenshots/a.jpg": false,
+		"/r/x/.thumbnails/y/a.jpg":   false,
+	} {
+		if got := root.allows(path); got != want {
+			t.Errorf("allows(%s) = %v, want %v", path, got, want)
+		}
+	}
+
+	if !root.skipDir("/r/phone/Screenshots") || root.skipDir("/r/phone") || root.skipDir("/r") {
+		t.Error("want only excluded folders skipped")
+	}
+
+	// The innermost root decides for nested roots
+	roots := []photoRoot{root, newPhotoRoot("inner", "/r/inner", nil, nil, false)}
+	if photoRootAllows(roots, "/r/inner/a.jpg") || !photoRootAllows(roots, "/r/a.jpg") ||
+		photoRootAllows(roots, "/other/a.jpg") {
+		t.Error("want photos of disabled nested root and outside of roots not allowed")
+	}
+}
+
+func TestIndexingFiltersPhotoRoots(t *testing.T) {
+	e := newTestEnv(t)
+	family := e.addPhoto(t, "family/a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+	screenshot := e.addPhoto(t, "family/Screenshots/s.jpg", time.Date(2020, 1, 3, 10, 0, 0, 0, time.Local))
+	phone := e.addPhoto(t, "phone/b.jpg", time.Date(2021, 1, 2, 10, 0, 0, 0, time.Local))
+
+	cfg.photoRoots = []photoRoot{
+		newPhotoRoot("family", filepath.Join(e.library, "family"), nil, []string{"*/Screenshots/*"}, true),
+		newPhotoRoot("phone", filepath.Join(e.library, "phone"), nil, nil, true),
+	}
+	e.index(t)
+	if !isIndexed(t, family) || !isIndexed(t, phone) || isIndexed(t, screenshot) {
+		t.Fatal("want photos of both roots indexed without screenshots")
+	}
+
+	// Photos of a disabled root are not selected before the next indexing removes them
+	cfg.photoRoots[1].enabled = false
+	for _, photo := range selectRandomPhotoPaths(10) {
+		if photo != family {
+			t.Errorf("want only photos of enabled roots, got %s", photo)
+		}
+	}
+
+	e.index(t)
+	if isIndexed(t, phone) {
+		t.Error("want photos of disabled root removed from index")
+	}
+}
+
+func TestMissingPhotoRootIsSkipped(t *testing.T) {
+	e := newTestEnv(t)
+	family := e.addPhoto(t, "family/a.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+
+	// The library is walked while an unmounted root and a deleted folder give errors
+	roots := []photoRoot{
+		newPhotoRoot("family", filepath.Join(e.library, "family"), nil, nil, true),
+		newPhotoRoot("nas", filepath.Join(e.library, "unmounted"), nil, nil, true),
+	}
+	photos := findInRoots(context.Background(), roots, nil, nil, nil)
+	if len(photos) != 1 || photos[0] != family {
+		t.Errorf("want photos of the available root, got %v", photos)
+	}
+	if photos := findWithContext(context.Background(), filepath.Join(e.library, "deleted"), nil, nil); len(photos) != 0 {
+		t.Errorf("want no photos in a deleted folder, got %v", photos)
+	}
+}
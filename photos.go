@@ -2,15 +2,44 @@ package main
 
 import (
 	"C"
+	"context"
+	"fmt"
 	"github.com/h2non/bimg"
 	"log"
-	"math/rand"
 	"os"
 	"path/filepath"
-	"time"
 )
 
-func getRandomPhotos(count int) []string {
+// SelectedPhoto is a photo chosen for sending
+type SelectedPhoto struct {
+	Original  string // Path in the photo library
+	Processed string // Path of the file to upload, may be the original or a converted copy in the workspace
+}
+
+// sendWorkspace is a temporary directory owned by a single send for converted and compressed photos
+type sendWorkspace struct {
+	dir string
+}
+
+// newSendWorkspace creates a workspace inside tempProcessedPhotoPath
+func newSendWorkspace() (*sendWorkspace, error) {
+	dir, err := os.MkdirTemp(tempProcessedPhotoPath, "send-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating send workspace: %v", err)
+	}
+	return &sendWorkspace{dir: dir}, nil
+}
+
+// Close removes the workspace with all processed photos
+func (w *sendWorkspace) Close() {
+	err := os.RemoveAll(w.dir)
+	if err != nil {
+		log.Printf("Error removing send workspace %s: %v", w.dir, err)
+	}
+}
+
+// getRandomPhotos selects random photos and processes them in workspace
+func getRandomPhotos(count int, workspace *sendWorkspace) []SelectedPhoto {
 	if count < 1 {
 		log.Println("Photo count is less than 1, setting to 1")
 		count = 1
@@ -21,64 +50,98 @@ func getRandomPhotos(count int) []string {
 		count = 10
 	}
 
-	var processedPhotos []string
-	var originalPhotos []string
-	var photosFromAllPaths []string
+	selectedPhotos := processPhotos(selectRandomPhotoPaths(count), workspace)
+	log.Println("Random photos:", selectedPhotos)
 
-	photos := find(cfg.photoPath, []string{".JPG", ".PNG", ".JPEG", ".jpg", ".png", ".jpeg", ".webp", ".WEBP", ".gif",
-		".GIF", ".HEIC", ".heic"})
-	if len(photos) > 0 {
-		photosFromAllPaths = append(photosFromAllPaths, photos...)
-	}
+	return selectedPhotos
+}
 
-	photoLibrarySize := len(photosFromAllPaths)
-	log.Println("found photos:", photoLibrarySize)
+// processPhotos processes photos in workspace, photos which can't be processed are skipped
+func processPhotos(paths []string, workspace *sendWorkspace) []SelectedPhoto {
+	var selectedPhotos []SelectedPhoto
+	for _, path := range paths {
+		compressedPhoto := processPhoto(path, workspace)
 
-	seed := time.Now().UnixNano()
-	source := rand.NewSource(seed)
-	rnd := rand.New(source)
+		if compressedPhoto == nil {
+			continue
+		}
 
-	var random []int
-	var randomPhotoCount int
-	if photoLibrarySize < count {
-		randomPhotoCount = photoLibrarySize
-	} else {
-		randomPhotoCount = count
+		selectedPhotos = append(selectedPhotos, SelectedPhoto{Original: path, Processed: *compressedPhoto})
 	}
+	return selectedPhotos
+}
+
+// randomCandidatesPerPhoto is how many random candidates are taken from the index for each photo to send
+const randomCandidatesPerPhoto = 10
 
-	for _, i := range rnd.Perm(photoLibrarySize)[:randomPhotoCount] {
-		random = append(random, i)
+// selectRandomPhotoPaths picks count random photos from the metadata index.
+// The library is walked only if the index is empty, e.g. before the first indexing completes.
+func selectRandomPhotoPaths(count int) []string {
+	// Take extra candidates: recently shown photos and files deleted since the last indexing are skipped
+	candidates, err := GetRandomIndexedPhotos(count * randomCandidatesPerPhoto)
+	if err != nil {
+		log.Printf("Error selecting photos from index: %v", err)
 	}
 
-	for _, i := range random {
-		var randomPhoto = photosFromAllPaths[i]
-		compressedPhoto := processPhoto(randomPhoto)
+	if len(candidates) > 0 {
+		// Photos of disabled or filtered roots stay in the index until the next differential indexing
+		var allowed []string
+		for _, candidate := range candidates {
+			if photoRootAllows(cfg.photoRoots, candidate) {
+				allowed = append(allowed, candidate)
+			}
+		}
+		photos := selectExistingPhotos(allowed, count)
+		log.Println("Selected photos from index:", len(photos))
+		return photos
+	}
 
-		if compressedPhoto == nil {
+	log.Println("Photo index is empty, searching the library")
+	return randomPhotosFromLibrary(count)
+}
+
+// selectExistingPhotos picks count photos which still exist, those not shown recently first.
+// Missing files are dropped before ordering, so recently shown photos can fill their places.
+func selectExistingPhotos(paths []string, count int) []string {
+	var existing []string
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			log.Printf("Skipping photo %s: %v", path, err)
 			continue
 		}
-
-		processedPhotos = append(processedPhotos, *compressedPhoto)
-		originalPhotos = append(originalPhotos, randomPhoto)
+		existing = append(existing, path)
 	}
 
-	lastPhotos = originalPhotos
+	photos := preferUnseenPhotos(existing, count)
+	if len(photos) > count {
+		photos = photos[:count]
+	}
+	return photos
+}
 
-	log.Println("Random photos:", processedPhotos)
-	log.Println("Original photos:", originalPhotos)
+// randomPhotosFromLibrary walks the library and picks count random photos
+func randomPhotosFromLibrary(count int) []string {
+	photos := findInRoots(context.Background(), cfg.photoRoots, nil, nil, nil)
+	log.Println("found photos:", len(photos))
 
-	return processedPhotos
+	// Never shown photos come first in random order
+	random := preferUnseenPhotos(photos, count)
+	if len(random) > count {
+		random = random[:count]
+	}
+	return random
 }
 
-func processPhoto(path string) (compressedPath *string) {
+// processPhoto converts HEIC and other formats Telegram doesn't show to JPG and compresses large photos into workspace.
+// It returns the path to upload or nil if the photo can't be processed.
+func processPhoto(path string, workspace *sendWorkspace) (compressedPath *string) {
 	log.Println("Checking for compression photo: ", path)
 
 	imageName := filepath.Base(path)
-	imageExt := filepath.Ext(path)
 
-	// convert HEIC to JPG
-	if imageExt == ".heic" || imageExt == ".HEIC" {
-		log.Println("Converting HEIC to JPG")
+	// convert HEIC and other formats Telegram doesn't show to JPG
+	if mediaType := mediaTypes.typeOf(path); mediaType != nil && mediaType.convert {
+		log.Printf("Converting %s to JPG", mediaType.name)
 		heicImage, err := bimg.Read(path)
 		if err != nil {
 			log.Printf("Error reading image: %s. %s", path, err)
@@ -89,7 +152,11 @@ func processPhoto(path string) (compressedPath *string) {
 			log.Printf("Error converting image: %s. %s", path, err)
 		}
 
-		convertedImagePath := tempProcessedPhotoPath + "/" + imageName + ".jpg"
+		convertedImagePath, err := workspace.newFile(imageName + ".jpg")
+		if err != nil {
+			log.Printf("Error creating converted image: %s. %s", path, err)
+			return nil
+		}
 		log.Println("Save converted image to:", convertedImagePath)
 		err = bimg.Write(convertedImagePath, jpgImage)
 		if err != nil {
@@ -133,7 +200,11 @@ func processPhoto(path string) (compressedPath *string) {
 	newSizeInMb := float64(len(compressedImage)) / 1024 / 1024
 	log.Println("Compressed image size: ", newSizeInMb, "Mb")
 
-	compressedImagePath := tempProcessedPhotoPath + "/" + imageName
+	compressedImagePath, err := workspace.newFile(imageName)
+	if err != nil {
+		log.Printf("Error creating compressed image: %s. %s", path, err)
+		return nil
+	}
 	log.Println("Save compressed image to:", compressedImagePath)
 
 	err = bimg.Write(compressedImagePath, compressedImage)
@@ -146,18 +217,34 @@ func processPhoto(path string) (compressedPath *string) {
 	return &compressedImagePath
 }
 
+// newFile reserves a unique file path in the workspace ending with name,
+// photos with the same name from different folders don't overwrite each other
+func (w *sendWorkspace) newFile(name string) (string, error) {
+	file, err := os.CreateTemp(w.dir, "*-"+name)
+	if err != nil {
+		return "", err
+	}
+	err = file.Close()
+	if err != nil {
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// clearCompressedPhotos removes everything left in tempProcessedPhotoPath, e.g. after an unclean stop
 func clearCompressedPhotos() {
 	files, err := os.ReadDir(tempProcessedPhotoPath)
 	if err != nil {
-		log.Fatal(err)
+		log.Println("Error reading compressed photos folder:", err)
+		return
 	}
 	for _, file := range files {
-		filePath := tempProcessedPhotoPath + "/" + file.Name()
+		filePath := filepath.Join(tempProcessedPhotoPath, file.Name())
 		log.Println("Removing file:", filePath)
 
-		err := os.Remove(filePath)
+		err := os.RemoveAll(filePath)
 		if err != nil {
-			log.Fatal(err)
+			log.Println("Error removing file:", err)
 		}
 	}
 }
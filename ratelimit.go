@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// Telegram limits for bots: about 30 messages per second overall,
+// one message per second in a private chat and 20 messages per minute in a group
+const (
+	globalSendRate  = 30.0
+	globalSendBurst = 30
+	privateChatRate = 1.0
+	privateBurst    = 3
+	groupChatRate   = 20.0 / 60.0
+	groupBurst      = 5
+)
+
+// tokenBucket is a token bucket rate limiter
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second
+	burst  float64 // Bucket capacity
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+// Tokens may go negative, so concurrent callers queue up behind each other.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// take takes a token if there is one, otherwise it returns how long to wait for the next token
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// refill adds tokens for the time since the last call, not above burst
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// sendLimiter throttles outbound requests globally and per chat
+type sendLimiter struct {
+	global *tokenBucket
+
+	mu    sync.Mutex
+	chats map[int64]*tokenBucket
+}
+
+func newSendLimiter() *sendLimiter {
+	return &sendLimiter{
+		global: newTokenBucket(globalSendRate, globalSendBurst),
+		chats:  make(map[int64]*tokenBucket),
+	}
+}
+
+// reserve returns how long to wait before sending a request to chatID.
+// chatID 0 means the request is not bound to a chat.
+func (l *sendLimiter) reserve(chatID int64) time.Duration {
+	now := time.Now()
+	delay := l.global.reserve(now)
+
+	if chatID != 0 {
+		l.mu.Lock()
+		bucket, ok := l.chats[chatID]
+		if !ok {
+			// Group and channel chat IDs are negative
+			if chatID < 0 {
+				bucket = newTokenBucket(groupChatRate, groupBurst)
+			} else {
+				bucket = newTokenBucket(privateChatRate, privateBurst)
+			}
+			l.chats[chatID] = bucket
+		}
+		l.mu.Unlock()
+
+		if chatDelay := bucket.reserve(now); chatDelay > delay {
+			delay = chatDelay
+		}
+	}
+
+	return delay
+}
+
+// wait blocks until a request to chatID is allowed
+func (l *sendLimiter) wait(chatID int64) {
+	if delay := l.reserve(chatID); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// throttledSender is a Sender passing all requests through sendLimiter
+type throttledSender struct {
+	Sender
+	limiter *sendLimiter
+}
+
+func newThrottledSender(sender Sender, limiter *sendLimiter) *throttledSender {
+	return &throttledSender{Sender: sender, limiter: limiter}
+}
+
+func (s *throttledSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	s.limiter.wait(chatIDOf(c))
+	return s.Sender.Send(c)
+}
+
+func (s *throttledSender) SendMediaGroup(config tgbotapi.MediaGroupConfig) ([]tgbotapi.Message, error) {
+	s.limiter.wait(config.ChatID)
+	return s.Sender.SendMediaGroup(config)
+}
+
+func (s *throttledSender) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	s.limiter.wait(chatIDOf(c))
+	return s.Sender.Request(c)
+}
+
+// chatIDOf returns the target chat of requests sent by the bot, or 0 if unknown
+func chatIDOf(c tgbotapi.Chattable) int64 {
+	switch config := c.(type) {
+	case tgbotapi.MessageConfig:
+		return config.ChatID
+	case tgbotapi.LocationConfig:
+		return config.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		return config.ChatID
+	case tgbotapi.MediaGroupConfig:
+		return config.ChatID
+	default:
+		return 0
+	}
+}
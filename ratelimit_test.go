@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(1, 2)
+	now := time.Now()
+
+	// Burst is available immediately
+	for i := 0; i < 2; i++ {
+		if delay := bucket.reserve(now); delay != 0 {
+			t.Fatalf("request %d: want no delay, got %s", i, delay)
+		}
+	}
+
+	// Next requests queue up one second apart
+	if delay := bucket.reserve(now); delay != time.Second {
+		t.Errorf("want 1s delay, got %s", delay)
+	}
+	if delay := bucket.reserve(now); delay != 2*time.Second {
+		t.Errorf("want 2s delay, got %s", delay)
+	}
+
+	// Tokens refill over time but not above burst
+	if delay := bucket.reserve(now.Add(time.Hour)); delay != 0 {
+		t.Errorf("want no delay after refill, got %s", delay)
+	}
+}
+
+func TestSendLimiterPerChat(t *testing.T) {
+	limiter := newSendLimiter()
+
+	// Exhaust the private chat burst
+	for i := 0; i < privateBurst; i++ {
+		limiter.reserve(testChatID)
+	}
+	if delay := limiter.reserve(testChatID); delay <= 0 {
+		t.Errorf("want delay for the same chat, got %s", delay)
+	}
+
+	// Other chats are not affected
+	if delay := limiter.reserve(testChatID + 1); delay != 0 {
+		t.Errorf("want no delay for another chat, got %s", delay)
+	}
+
+	// Groups are limited to 20 messages per minute
+	for i := 0; i < groupBurst; i++ {
+		limiter.reserve(-testChatID)
+	}
+	if delay := limiter.reserve(-testChatID); delay < 2*time.Second {
+		t.Errorf("want group delay of about 3s, got %s", delay)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// Role is the access level of a user
+type Role int
+
+const (
+	RoleNone   Role = iota // Not allowed to use the bot
+	RoleViewer             // Requests photos and info
+	RoleAdmin              // Also runs indexing, changes subscriptions, favorites and settings
+	RoleOwner              // Also manages admins and owners, not limited in photo requests
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleAdmin:
+		return "admin"
+	case RoleOwner:
+		return "owner"
+	default:
+		return "none"
+	}
+}
+
+// parseRole parses viewer, admin or owner in any letter case
+func parseRole(value string) (Role, bool) {
+	for _, role := range []Role{RoleViewer, RoleAdmin, RoleOwner} {
+		if strings.EqualFold(value, role.String()) {
+			return role, true
+		}
+	}
+	return RoleNone, false
+}
+
+// MarshalText stores roles by name, so their order can change
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	role, ok := parseRole(string(text))
+	if !ok {
+		return fmt.Errorf("unknown role %q", text)
+	}
+	*r = role
+	return nil
+}
+
+// configRole returns the role of the user from FM_OWNER_USERS_ID, FM_ALLOWED_USERS_ID or FM_VIEWER_USERS_ID
+func (c Config) configRole(userID int64) Role {
+	switch {
+	case containsInt(c.ownerUserIds, userID):
+		return RoleOwner
+	case containsInt(c.allowedUserIds, userID):
+		return RoleAdmin
+	case containsInt(c.viewerUserIds, userID):
+		return RoleViewer
+	default:
+		return RoleNone
+	}
+}
+
+// photoLimit returns photo requests per hour allowed for role, 0 is unlimited
+func (c Config) photoLimit(role Role) int {
+	switch role {
+	case RoleViewer:
+		return c.viewerPhotoLimit
+	case RoleAdmin:
+		return c.adminPhotoLimit
+	default:
+		return 0
+	}
+}
+
+// checkUserRoles reports users with several roles
+func checkUserRoles(p *configParser, c Config) {
+	lists := []struct {
+		key string
+		ids []int64
+	}{{keyOwnerUsers, c.ownerUserIds}, {keyAllowedUsers, c.allowedUserIds}, {keyViewerUsers, c.viewerUserIds}}
+
+	seen := make(map[int64]string)
+	for _, list := range lists {
+		for _, id := range list.ids {
+			if key, ok := seen[id]; ok && key != list.key {
+				p.fail(list.key, "has user %d of %s, a user has one role", id, key)
+				continue
+			}
+			seen[id] = list.key
+		}
+	}
+}
+
+// photoRequests limits photo requests of each user by the limit of their role
+var photoRequests = newRequestLimiter(func(c Config, role Role) int { return c.photoLimit(role) })
+
+// requestLimiter limits requests of each user per hour
+type requestLimiter struct {
+	limit func(c Config, role Role) int // Requests per hour, 0 is unlimited
+
+	mu    sync.Mutex
+	users map[int64]*userRequests
+}
+
+// userRequests are requests of a user with the limit they were counted with
+type userRequests struct {
+	limit  int
+	bucket *tokenBucket
+}
+
+func newRequestLimiter(limit func(c Config, role Role) int) *requestLimiter {
+	return &requestLimiter{limit: limit, users: make(map[int64]*userRequests)}
+}
+
+// allow counts a request of the user and returns how long to wait if the limit is reached
+func (l *requestLimiter) allow(c Config, userID int64) (bool, time.Duration) {
+	limit := l.limit(c, userRole(c, userID))
+	if limit == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	user, ok := l.users[userID]
+	// The limit changes with the role or on reload
+	if !ok || user.limit != limit {
+		user = &userRequests{limit: limit, bucket: newTokenBucket(float64(limit)/time.Hour.Seconds(), limit)}
+		l.users[userID] = user
+	}
+	l.mu.Unlock()
+
+	return user.bucket.take(time.Now())
+}
+
+// allowMessage counts a request of the sender of message and replies when to try again if the limit is reached
+func (l *requestLimiter) allowMessage(bot Sender, message *tgbotapi.Message) bool {
+	ok, wait := l.allow(currentConfig(), message.From.ID)
+	if !ok {
+		log.Printf("User %s: %d reached the request limit", message.From.UserName, message.From.ID)
+		sendSafeReplyText(message.Chat.ID, message.MessageID, bot, fmt.Sprintf("⏳ Too many requests, try again in %s",
+			formatDuration(wait.Round(time.Second).Seconds())))
+	}
+	return ok
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigRoles(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("FM_TG_BOT_TOKEN", "token")
+	t.Setenv("FM_CHAT_ID", "42")
+	t.Setenv("FM_OWNER_USERS_ID", "1")
+	t.Setenv("FM_ALLOWED_USERS_ID", "2")
+	t.Setenv("FM_VIEWER_USERS_ID", "3;4")
+
+	c, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[int64]Role{1: RoleOwner, 2: RoleAdmin, 4: RoleViewer, 5: RoleNone} {
+		if role := c.configRole(id); role != want {
+			t.Errorf("want user %d %s, got %s", id, want, role)
+		}
+	}
+	if c.photoLimit(RoleViewer) != 10 || c.photoLimit(RoleAdmin) != 0 || c.photoLimit(RoleOwner) != 0 {
+		t.Errorf("want only viewers limited by default, got %d and %d", c.viewerPhotoLimit, c.adminPhotoLimit)
+	}
+
+	t.Setenv("FM_VIEWER_USERS_ID", "2")
+	_, err = loadConfig("")
+	if err == nil || !strings.Contains(err.Error(), "FM_VIEWER_USERS_ID has user 2 of FM_ALLOWED_USERS_ID") {
+		t.Errorf("want user with two roles reported, got %v", err)
+	}
+}
+
+func TestViewerPermissionsAndPhotoLimit(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "a.jpg", time.Date(2020, 1, 1, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	const viewerID, strangerID = 5001, 5002
+	cfg.viewerUserIds = []int64{viewerID}
+	cfg.viewerPhotoLimit = 1
+
+	// Viewers request photos and info, destructive commands and details of the library are for admins
+	e.send(t, viewerID, "/history")
+	for _, command := range []string{"/history reset", "/reindex full", "/indexing", "/formats", "/subscriptions"} {
+		e.send(t, viewerID, command)
+	}
+	sendJobs.Flush()
+
+	replies := texts(e.telegram.Calls("sendMessage"))
+	if !containsText(replies, "History of sent photos") {
+		t.Errorf("want history shown to the viewer, got %q", replies)
+	}
+	if n := strings.Count(strings.Join(replies, "\n"), "Only admins can do this"); n != 5 {
+		t.Errorf("want admin commands denied, got %q", replies)
+	}
+
+	// The second photo request within an hour is over the limit of viewers
+	e.telegram.Reset()
+	e.send(t, viewerID, "/photo 1")
+	e.send(t, viewerID, "1")
+	sendJobs.Flush()
+
+	if len(e.telegram.Calls("sendMediaGroup")) != 1 {
+		t.Errorf("want one sending, got %d", len(e.telegram.Calls("sendMediaGroup")))
+	}
+	if !containsText(texts(e.telegram.Calls("sendMessage")), "Too many requests, try again in") {
+		t.Errorf("want limit reached, got %q", texts(e.telegram.Calls("sendMessage")))
+	}
+
+	// Admins are not limited by default and users without a role are ignored
+	e.telegram.Reset()
+	e.send(t, testUserID, "/photo 1")
+	e.send(t, testUserID, "/photo 1")
+	e.send(t, strangerID, "/reindex full")
+	sendJobs.Flush()
+
+	if len(e.telegram.Calls("sendMediaGroup")) != 2 || containsText(texts(e.telegram.Calls("sendMessage")), "Only admins") {
+		t.Errorf("want two sendings of the admin and no reply to the stranger, got %q",
+			texts(e.telegram.Calls("sendMessage")))
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// Permission is the access level required to run a command
+type Permission int
+
+const (
+	PermissionPublic Permission = iota // Anyone can run the command
+	PermissionViewer                   // Users with any role
+	PermissionAdmin                    // Admins and owners
+)
+
+// ArgsParser parses command arguments. The returned error is sent to the user as a reply.
+type ArgsParser func(message *tgbotapi.Message) (any, error)
+
+// CommandHandler handles a command with arguments returned by its ArgsParser
+type CommandHandler func(bot Sender, update tgbotapi.Update, args any)
+
+// Command describes a bot command registered in CommandRouter
+type Command struct {
+	Name           string                    // Command name without slash
+	Description    string                    // Short description for the Telegram menu
+	Help           string                    // Usage lines for /help
+	Permission     Permission                // Required access level
+	ParseArgs      ArgsParser                // Optional arguments parser
+	ArgsPermission func(args any) Permission // Optional access level required by the parsed arguments
+	Limit          *requestLimiter           // Optional limit of requests of each user
+	Handle         CommandHandler            // Command handler
+	Hidden         bool                      // Hide the command from the Telegram menu
+}
+
+// CommandRouter dispatches commands to registered handlers
+type CommandRouter struct {
+	commands map[string]*Command
+	order    []string // Registration order for the menu and help
+}
+
+// NewCommandRouter creates an empty command router
+func NewCommandRouter() *CommandRouter {
+	return &CommandRouter{
+		commands: make(map[string]*Command),
+	}
+}
+
+// Register adds command to the router. Registering the same name twice panics.
+func (r *CommandRouter) Register(cmd Command) {
+	name := strings.ToLower(cmd.Name)
+	if _, exists := r.commands[name]; exists {
+		log.Panicf("command %s is already registered", name)
+	}
+	if cmd.Handle == nil {
+		log.Panicf("command %s has no handler", name)
+	}
+
+	cmd.Name = name
+	r.commands[name] = &cmd
+	r.order = append(r.order, name)
+}
+
+// Lookup returns the command registered with name
+func (r *CommandRouter) Lookup(name string) (*Command, bool) {
+	cmd, ok := r.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// BotCommands returns commands for the Telegram menu
+func (r *CommandRouter) BotCommands() []tgbotapi.BotCommand {
+	var commands []tgbotapi.BotCommand
+	for _, name := range r.order {
+		cmd := r.commands[name]
+		if cmd.Hidden {
+			continue
+		}
+		commands = append(commands, tgbotapi.BotCommand{Command: cmd.Name, Description: cmd.Description})
+	}
+	return commands
+}
+
+// HelpText returns help for all registered commands
+func (r *CommandRouter) HelpText() string {
+	var sb strings.Builder
+	sb.WriteString("Available commands:\n")
+	for _, name := range r.order {
+		cmd := r.commands[name]
+		help := cmd.Help
+		if help == "" {
+			help = "/" + cmd.Name + " - " + cmd.Description
+		}
+		sb.WriteString("\n" + help + "\n")
+	}
+	return sb.String()
+}
+
+// Dispatch runs the handler for the command in update.
+// It returns false if the update does not contain a registered command.
+func (r *CommandRouter) Dispatch(bot Sender, update tgbotapi.Update) bool {
+	message := update.Message
+	if message == nil || !message.IsCommand() {
+		return false
+	}
+
+	cmd, ok := r.Lookup(message.Command())
+	if !ok {
+		return false
+	}
+
+	if !checkPermission(bot, message, cmd, cmd.Permission) {
+		return true
+	}
+
+	var args any
+	if cmd.ParseArgs != nil {
+		var err error
+		args, err = cmd.ParseArgs(message)
+		if err != nil {
+			sendSafeReplyText(message.Chat.ID, message.MessageID, bot, err.Error())
+			return true
+		}
+	}
+	if cmd.ArgsPermission != nil && !checkPermission(bot, message, cmd, cmd.ArgsPermission(args)) {
+		return true
+	}
+
+	if cmd.Limit != nil && !cmd.Limit.allowMessage(bot, message) {
+		return true
+	}
+
+	cmd.Handle(bot, update, args)
+	return true
+}
+
+// checkPermission checks if the sender of message has permission to run cmd.
+// Users with a role are told that admins can do it, others are ignored.
+func checkPermission(bot Sender, message *tgbotapi.Message, cmd *Command, permission Permission) bool {
+	if hasPermission(message.From, permission) {
+		return true
+	}
+
+	log.Printf("User %s: %d is not allowed to run /%s", message.From.UserName, message.From.ID, cmd.Name)
+	if hasPermission(message.From, PermissionViewer) {
+		sendSafeReplyText(message.Chat.ID, message.MessageID, bot, "🔒 Only admins can do this")
+	}
+	return false
+}
+
+// hasPermission checks if user has the required permission
+func hasPermission(user *tgbotapi.User, permission Permission) bool {
+	if permission == PermissionPublic {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+
+	role := userRole(currentConfig(), user.ID)
+	switch permission {
+	case PermissionViewer:
+		return role >= RoleViewer
+	case PermissionAdmin:
+		return role >= RoleAdmin
+	default:
+		return false
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/robfig/cron/v3"
+)
+
+// scheduledJobs runs cron jobs of the config, set up in main
+var scheduledJobs *scheduler
+
+// scheduler runs cron jobs of the config. Jobs are re-registered when their specs change on reload,
+// other settings are read by jobs when they run.
+type scheduler struct {
+	cron   *cron.Cron
+	sender Sender
+
+	mu      sync.Mutex
+	entries map[string]scheduledEntry // Job name -> registered cron entry
+}
+
+type scheduledEntry struct {
+	spec string
+	id   cron.EntryID
+}
+
+// scheduledJob is a cron job of the config
+type scheduledJob struct {
+	name string
+	spec string
+	run  func()
+}
+
+func newScheduler(c *cron.Cron, sender Sender) *scheduler {
+	return &scheduler{cron: c, sender: sender, entries: make(map[string]scheduledEntry)}
+}
+
+// jobs returns cron jobs of the config
+func (s *scheduler) jobs(c Config) []scheduledJob {
+	var jobs []scheduledJob
+	for _, schedule := range c.schedules {
+		name := schedule.name
+		// A schedule runs in the time zone of its chat
+		spec := c.chatCronSpec(schedule.chatId, schedule.cronSpec)
+		jobs = append(jobs, scheduledJob{name: "schedule " + name, spec: spec, run: func() {
+			// Chat, count and source are read when the job runs, so reloaded ones are used
+			current, ok := currentConfig().schedule(name)
+			if !ok {
+				return
+			}
+			queueSend("schedule "+name, nil, s.sender, func() {
+				current.send(s.sender)
+			})
+		}})
+	}
+
+	jobs = append(jobs, s.subscriptionJobs(c)...)
+
+	// Automatic reindexing
+	jobs = append(jobs, scheduledJob{name: "reindex", spec: c.reindexCronSpec, run: func() {
+		log.Println("Starting scheduled differential reindexing")
+		err := StartDifferentialIndexing(appCtx, cfg.photoRoots, cfg.indexWorkers, triggerCron)
+		if err != nil {
+			log.Printf("Error during scheduled reindexing: %v", err)
+		}
+	}})
+	return jobs
+}
+
+// apply registers jobs of the config whose specs changed and removes jobs which are gone.
+// If a job can't be registered, the running jobs are kept.
+func (s *scheduler) apply(c Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := s.jobs(c)
+
+	// Changed jobs are added first, so they replace the running ones only if all of them are valid
+	added := make(map[string]scheduledEntry)
+	for _, job := range jobs {
+		if entry, ok := s.entries[job.name]; ok && entry.spec == job.spec {
+			continue
+		}
+		id, err := s.cron.AddFunc(job.spec, job.run)
+		if err != nil {
+			for _, entry := range added {
+				s.cron.Remove(entry.id)
+			}
+			return fmt.Errorf("error scheduling %s job: %v", job.name, err)
+		}
+		added[job.name] = scheduledEntry{spec: job.spec, id: id}
+	}
+
+	names := make(map[string]bool)
+	for _, job := range jobs {
+		names[job.name] = true
+	}
+	for name, entry := range s.entries {
+		if _, replaced := added[name]; replaced || !names[name] {
+			s.cron.Remove(entry.id)
+			delete(s.entries, name)
+		}
+	}
+	for name, entry := range added {
+		s.entries[name] = entry
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// Sources of photos sent by schedules
+const (
+	scheduleSourceRandom    = "random"
+	scheduleSourceToday     = "today"     // Photos taken on this day in different years
+	scheduleSourceYearsAgo  = "years_ago" // Photos taken on this day a number of years ago
+	scheduleSourceFavorites = "favorites"
+	scheduleSourceFolder    = "folder"
+)
+
+// photoSchedule sends photos of a source to a chat on a cron schedule
+type photoSchedule struct {
+	name     string
+	cronSpec string
+	chatId   int64
+	count    int    // Photos per sending, for memories the total across years
+	source   string // One of scheduleSource values
+	yearsAgo int    // Years of the years_ago source
+	folder   string // Folder of the folder source
+}
+
+// parseSchedules parses schedules named in FM_SCHEDULES, e.g. "family;evening". Settings of each schedule are read
+// from FM_SCHEDULE_<NAME>_CRON, _CHAT_ID, _COUNT, _SOURCE, _YEARS and _FOLDER. If schedules are not set,
+// random photos and memories are sent to FM_CHAT_ID by FM_SEND_PHOTO_CRON_SPEC and FM_MEMORIES_CRON_SPEC.
+func parseSchedules(p *configParser, c Config) []photoSchedule {
+	names := p.list(keySchedules)
+	if len(names) == 0 {
+		return []photoSchedule{
+			{name: "photos", cronSpec: c.cronSpec, chatId: c.chatId, count: c.photoCount, source: scheduleSourceRandom},
+			{name: "memories", cronSpec: c.memoriesCronSpec, chatId: c.chatId, count: c.memoriesPhotoCount,
+				source: scheduleSourceToday},
+		}
+	}
+
+	var schedules []photoSchedule
+	keys := make(map[string]bool)
+	for _, name := range names {
+		if keys[labelKey(name)] {
+			p.fail(keySchedules, "has duplicate name %q", name)
+			continue
+		}
+		keys[labelKey(name)] = true
+
+		s := photoSchedule{name: name}
+		s.cronSpec = p.cron(scheduleEnvKey(name, "CRON"), "")
+		s.chatId = p.id(scheduleEnvKey(name, "CHAT_ID"), c.chatId)
+		s.source = p.oneOf(scheduleEnvKey(name, "SOURCE"), scheduleSourceRandom, scheduleSourceRandom,
+			scheduleSourceToday, scheduleSourceYearsAgo, scheduleSourceFavorites, scheduleSourceFolder)
+
+		switch s.source {
+		case scheduleSourceToday, scheduleSourceYearsAgo:
+			s.count = p.int(scheduleEnvKey(name, "COUNT"), c.memoriesPhotoCount, 1, math.MaxInt)
+		default:
+			s.count = p.int(scheduleEnvKey(name, "COUNT"), c.photoCount, 1, 10) // One media group
+		}
+
+		switch s.source {
+		case scheduleSourceYearsAgo:
+			s.yearsAgo = p.int(scheduleEnvKey(name, "YEARS"), 1, 1, 200)
+		case scheduleSourceFolder:
+			s.folder = p.required(scheduleEnvKey(name, "FOLDER"))
+			if s.folder != "" && photoRootOf(c.photoRoots, s.folder) == nil {
+				p.fail(scheduleEnvKey(name, "FOLDER"), "must be inside a photo root, got %q", s.folder)
+			}
+		}
+
+		schedules = append(schedules, s)
+	}
+	return schedules
+}
+
+// scheduleEnvKey returns the name of a schedule setting, e.g. FM_SCHEDULE_FAMILY_CRON
+func scheduleEnvKey(name string, setting string) string {
+	return keySchedulePrefix + labelKey(name) + "_" + setting
+}
+
+// schedule returns the schedule of the config with name
+func (c Config) schedule(name string) (photoSchedule, bool) {
+	for _, s := range c.schedules {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return photoSchedule{}, false
+}
+
+// send sends photos of the schedule to its chat
+func (s photoSchedule) send(bot Sender) {
+	log.Printf("Sending %s photos of schedule %s to chat %d", s.source, s.name, s.chatId)
+
+	switch s.source {
+	case scheduleSourceRandom:
+		sendPhotosMessage(s.chatId, nil, "📷 Sending random photos...", bot, func(workspace *sendWorkspace) []SelectedPhoto {
+			return getRandomPhotos(s.count, workspace)
+		})
+	case scheduleSourceToday:
+		sendMemoryPhotosTo(s.chatId, 0, s.count, RequestTypeToday, 0, bot)
+	case scheduleSourceYearsAgo:
+		sendMemoryPhotosTo(s.chatId, 0, s.count, RequestTypeMemories, s.yearsAgo, bot)
+	case scheduleSourceFavorites:
+		sendPhotosMessage(s.chatId, nil, "⭐️ Sending favorite photos...", bot, func(workspace *sendWorkspace) []SelectedPhoto {
+			return processPhotos(selectFavoritePhotoPaths(s.count), workspace)
+		})
+	case scheduleSourceFolder:
+		notice := fmt.Sprintf("📁 Sending photos from %s...", s.folder)
+		sendPhotosMessage(s.chatId, nil, notice, bot, func(workspace *sendWorkspace) []SelectedPhoto {
+			return processPhotos(selectFolderPhotoPaths(s.folder, s.count), workspace)
+		})
+	}
+}
+
+// selectFolderPhotoPaths picks count indexed photos of folder, those not shown recently first
+func selectFolderPhotoPaths(folder string, count int) []string {
+	indexed, err := GetIndexedPhotosInFolder(folder)
+	if err != nil {
+		log.Printf("Error getting photos of %s: %v", folder, err)
+		return nil
+	}
+
+	var photos []string
+	for _, photo := range indexed {
+		if photoRootAllows(cfg.photoRoots, photo) {
+			photos = append(photos, photo)
+		}
+	}
+	return selectExistingPhotos(photos, count)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigSchedules(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, `
+tg_bot_token: token
+chat_id: 42
+photo_roots:
+  - label: family
+    path: /photos/family
+schedules:
+  - name: family noon
+    cron: "0 12 * * *"
+    chat_id: -100500
+    source: today
+  - name: evening
+    cron: "0 20 * * *"
+    count: 3
+  - name: trip
+    cron: "@weekly"
+    source: folder
+    folder: /photos/family/trip
+`)
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.schedules) != 3 {
+		t.Fatalf("want 3 schedules, got %+v", c.schedules)
+	}
+	if s := c.schedules[0]; s.name != "family noon" || s.chatId != -100500 || s.source != scheduleSourceToday || s.count != 5 {
+		t.Errorf("want memories to the family chat, got %+v", s)
+	}
+	if s := c.schedules[1]; s.chatId != 42 || s.source != scheduleSourceRandom || s.count != 3 {
+		t.Errorf("want random photos to FM_CHAT_ID, got %+v", s)
+	}
+	if s := c.schedules[2]; s.folder != "/photos/family/trip" || s.cronSpec != "@weekly" {
+		t.Errorf("want folder schedule, got %+v", s)
+	}
+
+	// Without schedules, random photos and memories are sent to FM_CHAT_ID
+	t.Setenv("FM_SCHEDULES", ";")
+	c, err = loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.schedules) != 2 || c.schedules[0].cronSpec != "0 10 * * *" || c.schedules[1].source != scheduleSourceToday {
+		t.Errorf("want default schedules, got %+v", c.schedules)
+	}
+}
+
+func TestLoadConfigReportsInvalidSchedules(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, `
+tg_bot_token: token
+chat_id: 42
+schedules:
+  - name: broken
+    source: someday
+  - name: outside
+    cron: "0 9 * * *"
+    source: folder
+    folder: /elsewhere
+`)
+
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("want invalid schedules")
+	}
+	for _, want := range []string{"FM_SCHEDULE_BROKEN_CRON is required", "FM_SCHEDULE_BROKEN_SOURCE must be one of",
+		"FM_SCHEDULE_OUTSIDE_FOLDER must be inside a photo root"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want %q in errors, got %v", want, err)
+		}
+	}
+}
+
+func TestScheduleSendsFavoritesAndFolders(t *testing.T) {
+	e := newTestEnv(t)
+	favorite := e.addPhoto(t, "favorite.jpg", time.Date(2020, 5, 1, 10, 0, 0, 0, time.Local))
+	e.addPhoto(t, "trip/beach.jpg", time.Date(2021, 7, 1, 10, 0, 0, 0, time.Local))
+	e.addPhoto(t, "trip/sea.jpg", time.Date(2021, 7, 2, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	if added, err := toggleFavorite(favorite); err != nil || !added {
+		t.Fatalf("want photo added to favorites, got %v %v", added, err)
+	}
+
+	const familyChatID = -100500
+	photoSchedule{name: "favorites", chatId: familyChatID, count: 5, source: scheduleSourceFavorites}.send(e.bot)
+	photoSchedule{name: "trip", chatId: familyChatID, count: 5, source: scheduleSourceFolder,
+		folder: filepath.Join(e.library, "trip")}.send(e.bot)
+
+	groups := e.telegram.Calls("sendMediaGroup")
+	if len(groups) != 2 {
+		t.Fatalf("want 2 media groups, got %d", len(groups))
+	}
+	for i, want := range []int{1, 2} {
+		if groups[i].Params.Get("chat_id") != "-100500" || mediaCount(t, groups[i]) != want {
+			t.Errorf("want %d photos to the family chat, got %v", want, groups[i].Params)
+		}
+	}
+
+	// The favorite can be removed by its number in the first sending
+	e.telegram.Reset()
+	if added, err := toggleFavorite(favorite); err != nil || added {
+		t.Fatalf("want photo removed from favorites, got %v %v", added, err)
+	}
+	e.send(t, testUserID, "/favorite 1")
+	if !containsText(texts(e.telegram.Calls("sendMessage")), "Added to favorites: "+filepath.Join(e.library, "trip")) {
+		t.Errorf("want photo of the last sending added, got %q", texts(e.telegram.Calls("sendMessage")))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// sendQueueSize is the number of sends that can wait for a free worker
+const sendQueueSize = 100
+
+// sendJob is a single photo send
+type sendJob struct {
+	name string
+	run  func()
+}
+
+// sendQueue runs photo sends in a fixed number of workers.
+// With one worker sends are serialized; each send owns its workspace, so more workers are safe too.
+type sendQueue struct {
+	jobs    chan sendJob
+	workers sync.WaitGroup
+	pending sync.WaitGroup // Submitted jobs that are not finished yet
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// sendJobs is the queue used for all photo sends, created in main
+var sendJobs *sendQueue
+
+func newSendQueue(workerCount int) *sendQueue {
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
+	q := &sendQueue{jobs: make(chan sendJob, sendQueueSize)}
+	for i := 0; i < workerCount; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+func (q *sendQueue) work() {
+	defer q.workers.Done()
+	for job := range q.jobs {
+		start := time.Now()
+		job.run()
+		log.Printf("Send job %q finished in %s", job.name, time.Since(start).Round(time.Millisecond))
+		q.pending.Done()
+	}
+}
+
+// Submit queues a send. It returns false if the queue is full or closed.
+func (q *sendQueue) Submit(name string, run func()) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		log.Printf("Send job %q rejected: queue is closed", name)
+		return false
+	}
+
+	q.pending.Add(1)
+	select {
+	case q.jobs <- sendJob{name: name, run: run}:
+		return true
+	default:
+		q.pending.Done()
+		log.Printf("Send job %q rejected: queue is full", name)
+		return false
+	}
+}
+
+// Flush waits until all submitted jobs are finished
+func (q *sendQueue) Flush() {
+	q.pending.Wait()
+}
+
+// Close stops accepting jobs and waits for queued ones to finish. It returns false on timeout.
+func (q *sendQueue) Close(timeout time.Duration) bool {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// queueSend submits a photo send to sendJobs and tells the user if the bot is too busy to accept it.
+// update is nil for scheduled sends.
+func queueSend(name string, update *tgbotapi.Update, bot Sender, run func()) {
+	if sendJobs.Submit(name, run) {
+		return
+	}
+
+	if update != nil {
+		sendSafeReplyText(update.Message.Chat.ID, update.Message.MessageID, bot,
+			"Too many photo requests right now, please try again later")
+	}
+}
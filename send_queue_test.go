@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendQueueRejectsWhenClosed(t *testing.T) {
+	q := newSendQueue(2)
+
+	var done atomic.Int32
+	for i := 0; i < 5; i++ {
+		if !q.Submit("job", func() { done.Add(1) }) {
+			t.Fatal("want job accepted")
+		}
+	}
+	if !q.Close(time.Second) {
+		t.Fatal("queue did not drain in time")
+	}
+	if got := done.Load(); got != 5 {
+		t.Errorf("want 5 finished jobs, got %d", got)
+	}
+	if q.Submit("late", func() {}) {
+		t.Error("want job rejected after close")
+	}
+}
+
+func TestSendQueueRejectsWhenFull(t *testing.T) {
+	q := newSendQueue(1)
+	release := make(chan struct{})
+
+	accepted := 0
+	for i := 0; i < sendQueueSize+5; i++ {
+		if q.Submit("job", func() { <-release }) {
+			accepted++
+		}
+	}
+	close(release)
+	q.Close(time.Second)
+
+	// One job is taken by the worker, the rest wait in the queue
+	if accepted > sendQueueSize+1 {
+		t.Errorf("want at most %d accepted jobs, got %d", sendQueueSize+1, accepted)
+	}
+}
+
+// Concurrent sends must not mix up photos: every stored photo record
+// points to the file uploaded in the same message
+func TestConcurrentSendsKeepPhotosApart(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 12; i++ {
+		e.addPhoto(t, fmt.Sprintf("photo-%02d.jpg", i), time.Date(2020, 1, i+1, 10, 0, 0, 0, time.Local))
+	}
+	e.index(t)
+	e.telegram.Reset()
+
+	// Several workers run sends at the same time
+	sendJobs.Close(time.Second)
+	queue := newSendQueue(4)
+	sendJobs = queue
+	t.Cleanup(func() { queue.Close(10 * time.Second) })
+
+	const sends = 8
+	for i := 0; i < sends; i++ {
+		if !sendJobs.Submit("photo", func() { sendRandomPhoto(3, nil, e.bot) }) {
+			t.Fatal("want send accepted")
+		}
+	}
+	sendJobs.Flush()
+
+	groups := e.telegram.Calls("sendMediaGroup")
+	if len(groups) != sends {
+		t.Fatalf("want %d media groups, got %d", sends, len(groups))
+	}
+
+	sendingNumbers := make(map[int]bool)
+	for _, group := range groups {
+		var media []struct {
+			Media string `json:"media"`
+		}
+		if err := json.Unmarshal([]byte(group.Params.Get("media")), &media); err != nil {
+			t.Fatalf("parse media: %v", err)
+		}
+		if len(media) != 3 || len(group.MessageIDs) != 3 {
+			t.Fatalf("want 3 photos and messages, got %d and %d", len(media), len(group.MessageIDs))
+		}
+
+		for i, item := range media {
+			uploaded := group.FileNames[strings.TrimPrefix(item.Media, "attach://")]
+
+			meta, err := getPhotoMsgMetaById(group.MessageIDs[i])
+			if err != nil {
+				t.Fatalf("get photo meta: %v", err)
+			}
+			if filepath.Base(meta.PhotoPath) != uploaded {
+				t.Errorf("message %d: stored %s, uploaded %s", group.MessageIDs[i], meta.PhotoPath, uploaded)
+			}
+			if meta.PhotoIndex != i+1 {
+				t.Errorf("message %d: want photo index %d, got %d", group.MessageIDs[i], i+1, meta.PhotoIndex)
+			}
+			sendingNumbers[meta.SendingNumber] = true
+		}
+	}
+
+	if len(sendingNumbers) != sends {
+		t.Errorf("want %d distinct sendings, got %d", sends, len(sendingNumbers))
+	}
+
+	// Workspaces are removed after each send
+	entries, err := os.ReadDir(tempProcessedPhotoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("want empty temp folder, got %d entries", len(entries))
+	}
+}
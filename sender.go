@@ -0,0 +1,13 @@
+package main
+
+import (
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// Sender is the part of the Telegram Bot API used to send messages.
+// It is implemented by *tgbotapi.BotAPI.
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	SendMediaGroup(config tgbotapi.MediaGroupConfig) ([]tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
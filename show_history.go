@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const bucketShowHistory = "PhotoShowHistory" // path -> PhotoShowRecord
+
+// PhotoShowRecord is how often and when a photo was sent
+type PhotoShowRecord struct {
+	LastShown  time.Time `json:"lastShown"`
+	TimesShown int       `json:"timesShown"`
+}
+
+// recordPhotosShown updates show history of sent photos, called in the storeSending transaction
+func recordPhotosShown(tx *bolt.Tx, photos []PhotoRecord, shownAt time.Time) error {
+	b := tx.Bucket([]byte(bucketShowHistory))
+	if b == nil {
+		return fmt.Errorf("bucket %q does not exist", bucketShowHistory)
+	}
+
+	for _, photo := range photos {
+		var record PhotoShowRecord
+		if data := b.Get([]byte(photo.Path)); data != nil {
+			err := json.Unmarshal(data, &record)
+			if err != nil {
+				log.Printf("Error unmarshaling show history of %s: %v", photo.Path, err)
+			}
+		}
+
+		record.LastShown = shownAt
+		record.TimesShown++
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte(photo.Path), data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getShowHistory returns show records of the given photos, photos never shown are absent
+func getShowHistory(paths []string) (map[string]PhotoShowRecord, error) {
+	history := make(map[string]PhotoShowRecord)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketShowHistory))
+		if b == nil {
+			return fmt.Errorf("bucket %q does not exist", bucketShowHistory)
+		}
+
+		for _, path := range paths {
+			data := b.Get([]byte(path))
+			if data == nil {
+				continue
+			}
+
+			var record PhotoShowRecord
+			err := json.Unmarshal(data, &record)
+			if err != nil {
+				return fmt.Errorf("error unmarshaling show history of %s: %v", path, err)
+			}
+			history[path] = record
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// ShowHistoryStats is a summary of the show history for /history
+type ShowHistoryStats struct {
+	ShownPhotos    int             // Photos shown at least once
+	RecentlyShown  int             // Photos shown within the repeat window
+	MostShown      []PhotoShowStat // Most often shown photos
+	LastShownPhoto *PhotoShowStat
+}
+
+// PhotoShowStat is a show record of a single photo
+type PhotoShowStat struct {
+	Path string
+	PhotoShowRecord
+}
+
+// GetShowHistoryStats summarizes the show history, top is the number of most shown photos to return
+func GetShowHistoryStats(top int) (*ShowHistoryStats, error) {
+	stats := &ShowHistoryStats{}
+	var all []PhotoShowStat
+	windowStart := repeatWindowStart(time.Now())
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketShowHistory))
+		if b == nil {
+			return fmt.Errorf("bucket %q does not exist", bucketShowHistory)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var record PhotoShowRecord
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return fmt.Errorf("error unmarshaling show history of %s: %v", k, err)
+			}
+
+			stat := PhotoShowStat{Path: string(k), PhotoShowRecord: record}
+			all = append(all, stat)
+
+			if record.LastShown.After(windowStart) {
+				stats.RecentlyShown++
+			}
+			if stats.LastShownPhoto == nil || record.LastShown.After(stats.LastShownPhoto.LastShown) {
+				stats.LastShownPhoto = &stat
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ShownPhotos = len(all)
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].TimesShown != all[j].TimesShown {
+			return all[i].TimesShown > all[j].TimesShown
+		}
+		return all[i].LastShown.After(all[j].LastShown)
+	})
+	if len(all) > top {
+		all = all[:top]
+	}
+	stats.MostShown = all
+
+	return stats, nil
+}
+
+// ResetShowHistory forgets which photos were shown
+func ResetShowHistory() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(bucketShowHistory))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return fmt.Errorf("error deleting bucket %s: %v", bucketShowHistory, err)
+		}
+
+		_, err = tx.CreateBucket([]byte(bucketShowHistory))
+		if err != nil {
+			return fmt.Errorf("error creating bucket %s: %v", bucketShowHistory, err)
+		}
+		return nil
+	})
+}
+
+// repeatWindowStart returns the time before which shown photos may be sent again
+func repeatWindowStart(now time.Time) time.Time {
+	return now.AddDate(0, 0, -currentConfig().repeatWindowDays)
+}
+
+// preferUnseenPhotos orders photos for selection: never shown photos first in random order,
+// then photos shown before the repeat window, least recently shown first.
+// Photos shown within the window are left out, unless there are fewer than need other photos;
+// then the least recently shown of them fill the gap so small libraries still get sends.
+func preferUnseenPhotos(paths []string, need int) []string {
+	history, err := getShowHistory(paths)
+	if err != nil {
+		// Without history all photos are treated as never shown
+		log.Printf("Error reading show history: %v", err)
+	}
+
+	var neverShown, shownBefore, shownRecently []string
+	windowStart := repeatWindowStart(time.Now())
+	for _, path := range paths {
+		record, shown := history[path]
+		switch {
+		case !shown:
+			neverShown = append(neverShown, path)
+		case record.LastShown.After(windowStart):
+			shownRecently = append(shownRecently, path)
+		default:
+			shownBefore = append(shownBefore, path)
+		}
+	}
+
+	rand.Shuffle(len(neverShown), func(i, j int) {
+		neverShown[i], neverShown[j] = neverShown[j], neverShown[i]
+	})
+	byLastShown := func(photos []string) {
+		sort.SliceStable(photos, func(i, j int) bool {
+			return history[photos[i]].LastShown.Before(history[photos[j]].LastShown)
+		})
+	}
+	byLastShown(shownBefore)
+	byLastShown(shownRecently)
+
+	ordered := append(neverShown, shownBefore...)
+	if len(ordered) < need {
+		missing := need - len(ordered)
+		if missing > len(shownRecently) {
+			missing = len(shownRecently)
+		}
+		if missing > 0 {
+			log.Printf("Not enough photos outside the repeat window, reusing %d recently shown", missing)
+			ordered = append(ordered, shownRecently[:missing]...)
+		}
+	}
+	return ordered
+}
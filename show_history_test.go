@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// markShown records photo as last shown at shownAt
+func markShown(t *testing.T, path string, shownAt time.Time) {
+	t.Helper()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		return recordPhotosShown(tx, []PhotoRecord{{Number: 1, Path: path}}, shownAt)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreSendingRecordsShowHistory(t *testing.T) {
+	newTestEnv(t)
+
+	storeSending(PhotoSending{NumberOfSending: 1, MessageId: 10, Photos: []PhotoRecord{{1, "/a.jpg"}, {2, "/b.jpg"}}})
+	storeSending(PhotoSending{NumberOfSending: 2, MessageId: 20, Photos: []PhotoRecord{{1, "/a.jpg"}}})
+
+	history, err := getShowHistory([]string{"/a.jpg", "/b.jpg", "/c.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history["/a.jpg"].TimesShown != 2 || history["/b.jpg"].TimesShown != 1 {
+		t.Errorf("want a shown 2 times and b once, got %+v", history)
+	}
+	if _, ok := history["/c.jpg"]; ok {
+		t.Error("want no history for photo never sent")
+	}
+
+	if err := ResetShowHistory(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := GetShowHistoryStats(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ShownPhotos != 0 {
+		t.Errorf("want empty history after reset, got %d photos", stats.ShownPhotos)
+	}
+}
+
+func TestPreferUnseenPhotos(t *testing.T) {
+	newTestEnv(t)
+	cfg.repeatWindowDays = 30
+
+	now := time.Now()
+	markShown(t, "/recent.jpg", now.AddDate(0, 0, -1))
+	markShown(t, "/old.jpg", now.AddDate(0, 0, -100))
+	markShown(t, "/older.jpg", now.AddDate(0, 0, -200))
+
+	paths := []string{"/recent.jpg", "/old.jpg", "/new.jpg", "/older.jpg"}
+
+	got := preferUnseenPhotos(paths, 2)
+	want := []string{"/new.jpg", "/older.jpg", "/old.jpg"}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+
+	// Recently shown photos are only used when nothing else is left
+	got = preferUnseenPhotos(paths, 4)
+	if len(got) != 4 || got[3] != "/recent.jpg" {
+		t.Errorf("want recently shown photo last, got %v", got)
+	}
+
+	// Without a window only the bias to never shown photos remains
+	cfg.repeatWindowDays = 0
+	got = preferUnseenPhotos(paths, 1)
+	if len(got) != 4 || got[0] != "/new.jpg" || got[1] != "/older.jpg" {
+		t.Errorf("want never shown, then least recently shown, got %v", got)
+	}
+}
+
+func TestRecentlyShownPhotosReplaceDeletedOnes(t *testing.T) {
+	e := newTestEnv(t)
+	cfg.repeatWindowDays = 30
+
+	first := e.addPhoto(t, "first.jpg", time.Date(2020, 1, 1, 10, 0, 0, 0, time.Local))
+	second := e.addPhoto(t, "second.jpg", time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local))
+	deleted := e.addPhoto(t, "deleted.jpg", time.Date(2020, 1, 3, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	now := time.Now()
+	markShown(t, first, now.AddDate(0, 0, -1))
+	markShown(t, second, now.AddDate(0, 0, -2))
+	// The never shown photo is deleted after indexing, it must not take the place of a shown one
+	if err := os.Remove(deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	if photos := selectRandomPhotoPaths(2); len(photos) != 2 {
+		t.Errorf("want both existing photos, got %v", photos)
+	}
+}
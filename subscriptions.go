@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+	bolt "go.etcd.io/bbolt"
+)
+
+const bucketSubscriptions = "Subscriptions" // "<chat id>:<kind>" -> Subscription
+
+// Kinds of subscriptions
+const (
+	subscriptionRandom   = "random"
+	subscriptionMemories = "memories" // Photos taken on this day in different years
+)
+
+// Subscription is a chat receiving scheduled photos
+type Subscription struct {
+	ChatID       int64     `json:"chatId"`
+	ChatTitle    string    `json:"chatTitle"`
+	Kind         string    `json:"kind"`
+	Time         string    `json:"time"` // HH:MM in the time zone of the chat, empty for the time of FM_SEND_PHOTO_CRON_SPEC or FM_MEMORIES_CRON_SPEC
+	SubscribedBy int64     `json:"subscribedBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func subscriptionKey(chatID int64, kind string) []byte {
+	return []byte(fmt.Sprintf("%d:%s", chatID, kind))
+}
+
+// cronSpec returns when the subscription is sent
+func (s Subscription) cronSpec(c Config) string {
+	if s.Time != "" {
+		t, err := time.Parse("15:04", s.Time)
+		if err == nil {
+			return c.chatCronSpec(s.ChatID, fmt.Sprintf("%d %d * * *", t.Minute(), t.Hour()))
+		}
+		log.Printf("Invalid time %q of subscription %s, using the default", s.Time, subscriptionKey(s.ChatID, s.Kind))
+	}
+	if s.Kind == subscriptionMemories {
+		return c.memoriesCronSpec
+	}
+	return c.cronSpec
+}
+
+// scheduleSource returns the schedule source sending the same photos as the subscription
+func (s Subscription) scheduleSource() string {
+	if s.Kind == subscriptionMemories {
+		return scheduleSourceToday
+	}
+	return scheduleSourceRandom
+}
+
+// send sends photos of the subscription to its chat
+func (s Subscription) send(bot Sender, c Config) {
+	log.Printf("Sending %s photos to subscribed chat %d", s.Kind, s.ChatID)
+
+	if s.Kind == subscriptionMemories {
+		sendMemoryPhotosTo(s.ChatID, 0, c.memoriesPhotoCount, RequestTypeToday, 0, bot)
+		return
+	}
+	sendPhotosMessage(s.ChatID, nil, "📷 Sending random photos...", bot, func(workspace *sendWorkspace) []SelectedPhoto {
+		return getRandomPhotos(c.photoCount, workspace)
+	})
+}
+
+// saveSubscription adds the subscription or replaces the subscription of the chat with the same kind
+func saveSubscription(s Subscription) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("error marshaling subscription: %v", err)
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSubscriptions))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketSubscriptions)
+		}
+		return b.Put(subscriptionKey(s.ChatID, s.Kind), data)
+	})
+}
+
+// deleteSubscriptions removes subscriptions of the chat with one of kinds and returns how many were removed
+func deleteSubscriptions(chatID int64, kinds ...string) (int, error) {
+	removed := 0
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSubscriptions))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketSubscriptions)
+		}
+
+		for _, kind := range kinds {
+			key := subscriptionKey(chatID, kind)
+			if b.Get(key) == nil {
+				continue
+			}
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// getSubscriptions returns all subscriptions ordered by chat and kind
+func getSubscriptions() ([]Subscription, error) {
+	var subscriptions []Subscription
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSubscriptions))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketSubscriptions)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var s Subscription
+			if err := json.Unmarshal(v, &s); err != nil {
+				log.Printf("Error unmarshaling subscription %s: %v", k, err)
+				return nil
+			}
+			subscriptions = append(subscriptions, s)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if subscriptions[i].ChatID != subscriptions[j].ChatID {
+			return subscriptions[i].ChatID < subscriptions[j].ChatID
+		}
+		return subscriptions[i].Kind < subscriptions[j].Kind
+	})
+	return subscriptions, nil
+}
+
+// subscriptionJobs returns a cron job for each time subscriptions are sent at, it fans out to all subscribed chats
+func (s *scheduler) subscriptionJobs(c Config) []scheduledJob {
+	subscriptions, err := getSubscriptions()
+	if err != nil {
+		log.Printf("Error getting subscriptions: %v", err)
+		return nil
+	}
+
+	var jobs []scheduledJob
+	added := make(map[string]bool)
+	for _, subscription := range subscriptions {
+		kind, spec := subscription.Kind, subscription.cronSpec(c)
+		name := fmt.Sprintf("subscriptions %s %s", kind, spec)
+		if added[name] {
+			continue
+		}
+		added[name] = true
+
+		jobs = append(jobs, scheduledJob{name: name, spec: spec, run: func() {
+			s.sendSubscriptions(kind, spec)
+		}})
+	}
+	return jobs
+}
+
+// sendSubscriptions sends photos to chats subscribed to kind at spec
+func (s *scheduler) sendSubscriptions(kind string, spec string) {
+	subscriptions, err := getSubscriptions()
+	if err != nil {
+		log.Printf("Error getting subscriptions: %v", err)
+		return
+	}
+
+	c := currentConfig()
+	for _, subscription := range subscriptions {
+		if subscription.Kind != kind || subscription.cronSpec(c) != spec {
+			continue
+		}
+		// The chat already gets the same photos at this time by a schedule of the config
+		if scheduledByConfig(c, subscription.ChatID, subscription.scheduleSource(), spec) {
+			continue
+		}
+
+		subscription := subscription
+		queueSend("subscription "+kind, nil, s.sender, func() {
+			subscription.send(s.sender, c)
+		})
+	}
+}
+
+// scheduledByConfig reports whether a schedule of the config sends photos of source to the chat at spec
+func scheduledByConfig(c Config, chatID int64, source string, spec string) bool {
+	for _, schedule := range c.schedules {
+		if schedule.chatId == chatID && schedule.source == source && c.chatCronSpec(chatID, schedule.cronSpec) == spec {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshScheduledJobs registers cron jobs of changed subscriptions
+func refreshScheduledJobs() {
+	if scheduledJobs == nil {
+		return
+	}
+	if err := scheduledJobs.apply(currentConfig()); err != nil {
+		log.Printf("Error scheduling subscriptions: %v", err)
+	}
+}
+
+// subscribeArgs are arguments of the /subscribe command
+type subscribeArgs struct {
+	kind string
+	time string // HH:MM or empty
+}
+
+func parseSubscribeArgs(message *tgbotapi.Message) (any, error) {
+	usage := errors.New("Usage: /subscribe random|memories [HH:MM]\n" +
+		"random - random photos, memories - photos taken on this day in different years.\n" +
+		"Without time photos are sent at the time of the main schedule.")
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) < 1 || len(args) > 2 {
+		return nil, usage
+	}
+
+	kind := strings.ToLower(args[0])
+	if kind != subscriptionRandom && kind != subscriptionMemories {
+		return nil, usage
+	}
+
+	var sendTime string
+	if len(args) == 2 {
+		t, err := time.Parse("15:04", args[1])
+		if err != nil {
+			return nil, errors.New("Please specify time as HH:MM, e.g. /subscribe memories 08:30")
+		}
+		sendTime = t.Format("15:04")
+	}
+	return subscribeArgs{kind: kind, time: sendTime}, nil
+}
+
+func handleSubscribeCommand(bot Sender, update tgbotapi.Update, args any) {
+	chatID, messageID := update.Message.Chat.ID, update.Message.MessageID
+	subscribe := args.(subscribeArgs)
+
+	subscription := Subscription{
+		ChatID:       chatID,
+		ChatTitle:    update.Message.Chat.Title,
+		Kind:         subscribe.kind,
+		Time:         subscribe.time,
+		SubscribedBy: update.Message.From.ID,
+		CreatedAt:    time.Now(),
+	}
+	if err := saveSubscription(subscription); err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error saving subscription: %v", err))
+		return
+	}
+	refreshScheduledJobs()
+
+	sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("🔔 This chat is subscribed to %s photos %s",
+		subscription.Kind, describeSubscriptionTime(subscription, currentConfig())))
+}
+
+func parseUnsubscribeArgs(message *tgbotapi.Message) (any, error) {
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	switch arg {
+	case "":
+		return []string{subscriptionRandom, subscriptionMemories}, nil
+	case subscriptionRandom, subscriptionMemories:
+		return []string{arg}, nil
+	default:
+		return nil, errors.New("Usage: /unsubscribe [random|memories], without argument all subscriptions of the chat are removed")
+	}
+}
+
+func handleUnsubscribeCommand(bot Sender, update tgbotapi.Update, args any) {
+	chatID, messageID := update.Message.Chat.ID, update.Message.MessageID
+
+	removed, err := deleteSubscriptions(chatID, args.([]string)...)
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error removing subscriptions: %v", err))
+		return
+	}
+	if removed == 0 {
+		sendSafeReplyText(chatID, messageID, bot, "This chat has no such subscriptions")
+		return
+	}
+	refreshScheduledJobs()
+
+	sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("🔕 Removed %d subscriptions of this chat", removed))
+}
+
+// parseSubscriptionsArgs returns true for /subscriptions all
+func parseSubscriptionsArgs(message *tgbotapi.Message) (any, error) {
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "":
+		return false, nil
+	case "all":
+		return true, nil
+	default:
+		return nil, errors.New("Usage: /subscriptions [all], without argument subscriptions of this chat are shown")
+	}
+}
+
+func handleSubscriptionsCommand(bot Sender, update tgbotapi.Update, args any) {
+	chatID, messageID := update.Message.Chat.ID, update.Message.MessageID
+
+	subscriptions, err := getSubscriptions()
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error getting subscriptions: %v", err))
+		return
+	}
+
+	// Other chats are shown only with /subscriptions all
+	if all := args.(bool); !all {
+		var own []Subscription
+		for _, subscription := range subscriptions {
+			if subscription.ChatID == chatID {
+				own = append(own, subscription)
+			}
+		}
+		if len(own) == 0 {
+			sendSafeReplyText(chatID, messageID, bot,
+				"This chat has no subscriptions. Use /subscribe random|memories [HH:MM] to subscribe it")
+			return
+		}
+		subscriptions = own
+	}
+	sendSafeReplyText(chatID, messageID, bot, formatSubscriptions(subscriptions, chatID, currentConfig()))
+}
+
+// formatSubscriptions lists subscriptions, marking the current chat
+func formatSubscriptions(subscriptions []Subscription, currentChatID int64, c Config) string {
+	if len(subscriptions) == 0 {
+		return "No chats are subscribed. Use /subscribe random|memories [HH:MM] in a chat to subscribe it"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🔔 Subscriptions\n")
+	for _, s := range subscriptions {
+		chat := s.ChatTitle
+		if chat == "" {
+			chat = fmt.Sprintf("chat %d", s.ChatID)
+		}
+		if s.ChatID == currentChatID {
+			chat += " (this chat)"
+		}
+		sb.WriteString(fmt.Sprintf("\n%s: %s photos %s", chat, s.Kind, describeSubscriptionTime(s, c)))
+	}
+	return sb.String()
+}
+
+// describeSubscriptionTime describes when photos of the subscription are sent
+func describeSubscriptionTime(s Subscription, c Config) string {
+	if s.Time != "" {
+		return fmt.Sprintf("every day at %s (%s)", s.Time, c.chatLocation(s.ChatID))
+	}
+	return "by the main schedule " + s.cronSpec(c)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestSubscriptionsFanOut(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "a.jpg", time.Date(2020, 1, 1, 10, 0, 0, 0, time.Local))
+	e.index(t)
+	cfg.cronSpec = "0 10 * * *"
+	cfg.memoriesCronSpec = "0 12 * * *"
+	cfg.reindexCronSpec = "0 0 * * 0"
+
+	jobs := newScheduler(cron.New(), e.bot)
+	scheduledJobs = jobs
+	t.Cleanup(func() { scheduledJobs = nil })
+
+	// A group chat where an allowed user added the bot and the private chat subscribe at the same time
+	const groupChatID = -100500
+	e.sendInChat(t, groupChatID, testUserID, "/subscribe random")
+	e.send(t, testUserID, "/subscribe random 10:00")
+	e.send(t, testUserID, "/subscribe memories 8:30")
+
+	if !containsText(texts(e.telegram.Calls("sendMessage")), "subscribed to memories photos every day at 08:30") {
+		t.Errorf("want subscription confirmed, got %q", texts(e.telegram.Calls("sendMessage")))
+	}
+	if _, ok := jobs.entries["subscriptions random 0 10 * * *"]; !ok {
+		t.Errorf("want fan-out job of random photos, got %v", jobs.entries)
+	}
+	if _, ok := jobs.entries["subscriptions memories 30 8 * * *"]; !ok {
+		t.Errorf("want job of memories at 08:30, got %v", jobs.entries)
+	}
+
+	e.telegram.Reset()
+	jobs.sendSubscriptions(subscriptionRandom, "0 10 * * *")
+	sendJobs.Flush()
+
+	chats := make(map[string]bool)
+	for _, call := range e.telegram.Calls("sendMediaGroup") {
+		chats[call.Params.Get("chat_id")] = true
+	}
+	if len(chats) != 2 || !chats["-100500"] {
+		t.Errorf("want photos sent to both chats, got %v", chats)
+	}
+
+	// The private chat unsubscribes, the group stays
+	e.telegram.Reset()
+	e.send(t, testUserID, "/unsubscribe")
+	e.send(t, testUserID, "/subscriptions")
+	e.send(t, testUserID, "/subscriptions all")
+
+	replies := texts(e.telegram.Calls("sendMessage"))
+	if !containsText(replies, "Removed 2 subscriptions") || !containsText(replies, "This chat has no subscriptions") {
+		t.Errorf("want subscriptions of this chat removed, got %q", replies)
+	}
+	list := replies[len(replies)-1]
+	if !strings.Contains(list, "chat -100500: random photos by the main schedule 0 10 * * *") ||
+		strings.Contains(list, "this chat") {
+		t.Errorf("want only the group subscription, got %q", list)
+	}
+	if _, ok := jobs.entries["subscriptions memories 30 8 * * *"]; ok {
+		t.Errorf("want job of memories removed, got %v", jobs.entries)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Time zones are available in containers without tzdata
+)
+
+// parseChatTimeZones reads FM_CHAT_TIME_ZONES of entries "chat id=time zone" separated by ;
+func parseChatTimeZones(p *configParser) map[int64]*time.Location {
+	locations := make(map[int64]*time.Location)
+	for _, entry := range p.list(keyChatTimeZones) {
+		chat, zone, ok := strings.Cut(entry, "=")
+		chatID, err := strconv.ParseInt(strings.TrimSpace(chat), 10, 64)
+		if !ok || err != nil || chatID == 0 {
+			p.fail(keyChatTimeZones, "must be entries \"chat id=time zone\" separated by ;, got %q", entry)
+			continue
+		}
+		loc, err := time.LoadLocation(strings.TrimSpace(zone))
+		if err != nil {
+			p.fail(keyChatTimeZones, "must have IANA time zones like Europe/Berlin, got %q", entry)
+			continue
+		}
+		if _, ok := locations[chatID]; ok {
+			p.fail(keyChatTimeZones, "has chat %d twice", chatID)
+			continue
+		}
+		locations[chatID] = loc
+	}
+	return locations
+}
+
+// chatLocation returns the time zone of the chat, FM_TIME_ZONE if the chat has no own time zone
+func (c Config) chatLocation(chatID int64) *time.Location {
+	if loc, ok := c.chatLocations[chatID]; ok {
+		return loc
+	}
+	return c.timeZone()
+}
+
+// timeZone returns FM_TIME_ZONE, the local time zone if it is not set
+func (c Config) timeZone() *time.Location {
+	if c.location == nil {
+		return time.Local
+	}
+	return c.location
+}
+
+// chatTime returns t in the time zone of the chat
+func chatTime(chatID int64, t time.Time) time.Time {
+	return t.In(currentConfig().chatLocation(chatID))
+}
+
+// chatCronSpec returns spec run in the time zone of the chat if it differs from FM_TIME_ZONE of the scheduler
+func (c Config) chatCronSpec(chatID int64, spec string) string {
+	loc, ok := c.chatLocations[chatID]
+	if !ok || strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		return spec
+	}
+	return "CRON_TZ=" + loc.String() + " " + spec
+}
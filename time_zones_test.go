@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestLoadConfigTimeZones(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, `
+tg_bot_token: token
+chat_id: 42
+time_zone: Europe/Berlin
+chat_time_zones:
+  - -100500=Asia/Tokyo
+`)
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.chatLocation(42).String() != "Europe/Berlin" || c.chatLocation(-100500).String() != "Asia/Tokyo" {
+		t.Errorf("want Berlin for FM_CHAT_ID and Tokyo for the group, got %v and %v",
+			c.chatLocation(42), c.chatLocation(-100500))
+	}
+
+	t.Setenv("FM_TIME_ZONE", "Mars/Olympus")
+	t.Setenv("FM_CHAT_TIME_ZONES", "family=Asia/Tokyo")
+	_, err = loadConfig(path)
+	if err == nil {
+		t.Fatal("want invalid time zones")
+	}
+	for _, want := range []string{"FM_TIME_ZONE must be an IANA time zone", "FM_CHAT_TIME_ZONES must be entries"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want %q in errors, got %v", want, err)
+		}
+	}
+}
+
+func TestMemoriesUseDateOfTimeZone(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "march.jpg", time.Date(2020, 3, 1, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	// 20:00 UTC on the last day of February is already the 1st of March in Tokyo
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 2, 28, 20, 0, 0, 0, time.UTC)
+
+	if photos, err := GetPhotosFromThisDay(now, 10); err != nil || len(photos) != 0 {
+		t.Errorf("want no photos on the 28th of February, got %v %v", photos, err)
+	}
+	if photos, err := GetPhotosFromThisDay(now.In(tokyo), 10); err != nil || len(photos) != 1 {
+		t.Errorf("want the photo of the 1st of March in Tokyo, got %v %v", photos, err)
+	}
+	if photos, err := GetPhotosFromPast(now.In(tokyo), 5, 10); err != nil || len(photos) != 1 {
+		t.Errorf("want the photo of 5 years ago in Tokyo, got %v %v", photos, err)
+	}
+}
+
+func TestSubscriptionTimeInChatTimeZone(t *testing.T) {
+	e := newTestEnv(t)
+	cfg.cronSpec = "0 10 * * *"
+	cfg.memoriesCronSpec = "0 12 * * *"
+	cfg.reindexCronSpec = "0 0 * * 0"
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.chatLocations = map[int64]*time.Location{testChatID: tokyo}
+
+	jobs := newScheduler(cron.New(), e.bot)
+	scheduledJobs = jobs
+	t.Cleanup(func() { scheduledJobs = nil })
+
+	e.send(t, testUserID, "/subscribe memories 08:30")
+
+	if !containsText(texts(e.telegram.Calls("sendMessage")), "every day at 08:30 (Asia/Tokyo)") {
+		t.Errorf("want time zone of the chat confirmed, got %q", texts(e.telegram.Calls("sendMessage")))
+	}
+	if _, ok := jobs.entries["subscriptions memories CRON_TZ=Asia/Tokyo 30 8 * * *"]; !ok {
+		t.Errorf("want job at 08:30 in Tokyo, got %v", jobs.entries)
+	}
+}
+
+func TestPhotosWithoutExifAreDatedInTimeZone(t *testing.T) {
+	e := newTestEnv(t)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.location = tokyo
+
+	// Modified at 00:30 on the 1st of March in Tokyo, still the 29th of February in UTC
+	e.addPhoto(t, "march.jpg", time.Date(2020, 3, 1, 0, 30, 0, 0, tokyo))
+	e.index(t)
+
+	photos, err := GetPhotosFromThisDay(time.Date(2025, 3, 1, 12, 0, 0, 0, tokyo), 10)
+	if err != nil || len(photos) != 1 {
+		t.Errorf("want the photo dated the 1st of March in Tokyo, got %v %v", photos, err)
+	}
+}
+
+func TestScheduleRunsInChatTimeZone(t *testing.T) {
+	e := newTestEnv(t)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const familyChatID = -100500
+	cfg.reindexCronSpec = "0 0 * * 0"
+	cfg.chatLocations = map[int64]*time.Location{familyChatID: tokyo}
+	cfg.schedules = []photoSchedule{
+		{name: "family", cronSpec: "0 9 * * *", chatId: familyChatID, count: 5, source: scheduleSourceRandom},
+		{name: "main", cronSpec: "0 10 * * *", chatId: testChatID, count: 5, source: scheduleSourceRandom},
+	}
+
+	jobs := newScheduler(cron.New(), e.bot)
+	if err := jobs.apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if spec := jobs.entries["schedule family"].spec; spec != "CRON_TZ=Asia/Tokyo 0 9 * * *" {
+		t.Errorf("want the family schedule in Tokyo, got %q", spec)
+	}
+	if spec := jobs.entries["schedule main"].spec; spec != "0 10 * * *" {
+		t.Errorf("want the main schedule in FM_TIME_ZONE, got %q", spec)
+	}
+}
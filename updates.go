@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+const (
+	updateModePolling = "polling"
+	updateModeWebhook = "webhook"
+
+	// Header Telegram uses to pass the secret token configured in setWebhook
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// webhookShutdownTimeout is how long stopping waits for running webhook requests
+var webhookShutdownTimeout = 10 * time.Second
+
+// Telegram allows only 1-256 characters A-Z, a-z, 0-9, _ and - in the secret token
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// startReceivingUpdates starts receiving updates in the configured mode.
+// It returns the channel with updates and a function that stops receiving
+// updates and closes the channel.
+func startReceivingUpdates(bot *tgbotapi.BotAPI) (tgbotapi.UpdatesChannel, func(), error) {
+	switch cfg.updateMode {
+	case updateModePolling:
+		return startPolling(bot)
+	case updateModeWebhook:
+		return startWebhook(bot)
+	default:
+		return nil, nil, fmt.Errorf("unknown update mode %q, use '%s' or '%s'",
+			cfg.updateMode, updateModePolling, updateModeWebhook)
+	}
+}
+
+// startPolling receives updates with long polling
+func startPolling(bot *tgbotapi.BotAPI) (tgbotapi.UpdatesChannel, func(), error) {
+	// Telegram refuses getUpdates while a webhook is set, e.g. after switching from webhook mode
+	_, err := bot.Request(tgbotapi.DeleteWebhookConfig{})
+	if err != nil {
+		log.Printf("Error deleting webhook: %v", err)
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+
+	log.Println("Receiving updates with long polling")
+	return bot.GetUpdatesChan(u), bot.StopReceivingUpdates, nil
+}
+
+// startWebhook registers the webhook in Telegram and starts an HTTP server receiving updates
+func startWebhook(bot *tgbotapi.BotAPI) (tgbotapi.UpdatesChannel, func(), error) {
+	// The URL, secret and TLS files are validated with the config
+	webhookURL, err := url.Parse(cfg.webhookURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid webhook URL: %v", err)
+	}
+
+	webhookPath := webhookURL.Path
+	if webhookPath == "" {
+		webhookPath = "/"
+	}
+
+	ch := make(chan tgbotapi.Update, bot.Buffer)
+
+	// Stopping closes done, so handlers stop waiting to send, then closes ch once no handler sends to it.
+	// Shutdown may time out with handlers still running, they must not send to the closed channel.
+	done := make(chan struct{})
+	var sending sync.RWMutex
+	closed := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(webhookPath, func(w http.ResponseWriter, r *http.Request) {
+		if cfg.webhookSecret != "" {
+			secret := r.Header.Get(webhookSecretHeader)
+			if subtle.ConstantTimeCompare([]byte(secret), []byte(cfg.webhookSecret)) != 1 {
+				log.Printf("Rejected webhook request from %s: invalid secret token", r.RemoteAddr)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		update, err := bot.HandleUpdate(r)
+		if err != nil {
+			log.Printf("Error parsing webhook update: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		sending.RLock()
+		defer sending.RUnlock()
+		if closed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		select {
+		case ch <- *update:
+		case <-done:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	server := &http.Server{
+		Addr:              cfg.webhookListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		var err error
+		if cfg.webhookCertFile != "" {
+			log.Printf("Listening for webhook updates on %s (TLS)", cfg.webhookListenAddr)
+			err = server.ListenAndServeTLS(cfg.webhookCertFile, cfg.webhookKeyFile)
+		} else {
+			log.Printf("Listening for webhook updates on %s", cfg.webhookListenAddr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Panicf("Webhook server failed: %v", err)
+		}
+	}()
+
+	webhook := tgbotapi.WebhookConfig{
+		URL:         webhookURL,
+		SecretToken: cfg.webhookSecret,
+	}
+	// Upload the certificate so Telegram can trust a self-signed one
+	if cfg.webhookCertFile != "" {
+		webhook.Certificate = tgbotapi.FilePath(cfg.webhookCertFile)
+	}
+
+	_, err = bot.Request(webhook)
+	if err != nil {
+		_ = server.Close()
+		return nil, nil, fmt.Errorf("error setting webhook: %v", err)
+	}
+
+	info, err := bot.GetWebhookInfo()
+	if err != nil {
+		log.Printf("Error getting webhook info: %v", err)
+	} else if info.LastErrorDate != 0 {
+		log.Printf("Telegram reported webhook error: %s", info.LastErrorMessage)
+	}
+
+	safeURL := *webhookURL
+	safeURL.RawQuery = ""
+	log.Printf("Webhook set to %s", safeURL.String())
+
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			_, err := bot.Request(tgbotapi.DeleteWebhookConfig{})
+			if err != nil {
+				log.Printf("Error deleting webhook: %v", err)
+			} else {
+				log.Println("Webhook deleted")
+			}
+
+			close(done)
+			ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+			defer cancel()
+			err = server.Shutdown(ctx)
+			if err != nil {
+				log.Printf("Error stopping webhook server: %v", err)
+			}
+
+			// Handlers stopped waiting on done, so the lock is taken once the running ones return
+			sending.Lock()
+			closed = true
+			close(ch)
+			sending.Unlock()
+		})
+	}
+
+	return ch, stop, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestWebhook starts receiving updates with a webhook on a free local port and returns its URL
+func startTestWebhook(t *testing.T, secret string) (string, <-chan bool, func()) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	telegram := newFakeTelegram(t)
+	bot := telegram.newBot()
+	bot.Buffer = 0 // Updates are delivered only while they are read
+
+	cfg.updateMode = updateModeWebhook
+	cfg.webhookListenAddr = addr
+	cfg.webhookURL = "https://example.com/hook"
+	cfg.webhookSecret = secret
+	ch, stop, err := startReceivingUpdates(bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Updates received after stopping must not be delivered
+	delivered := make(chan bool, 1)
+	go func() {
+		count := 0
+		for range ch {
+			count++
+		}
+		delivered <- count > 0
+	}()
+
+	url := "http://" + addr + "/hook"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return url, delivered, stop
+}
+
+func TestWebhookRejectsInvalidSecret(t *testing.T) {
+	newTestEnv(t)
+	url, delivered, stop := startTestWebhook(t, "secret")
+
+	request, err := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"update_id": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(webhookSecretHeader, "wrong")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("want unauthorized, got %d", response.StatusCode)
+	}
+
+	stop()
+	if <-delivered {
+		t.Error("want update with invalid secret not delivered")
+	}
+}
+
+func TestWebhookRequestAfterShutdownTimeout(t *testing.T) {
+	newTestEnv(t)
+	webhookShutdownTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { webhookShutdownTimeout = 10 * time.Second })
+	url, delivered, stop := startTestWebhook(t, "")
+
+	// The request is still sending its body when the shutdown times out
+	body, writer := io.Pipe()
+	responses := make(chan int, 1)
+	go func() {
+		response, err := http.Post(url, "application/json", body)
+		if err != nil {
+			responses <- 0
+			return
+		}
+		_ = response.Body.Close()
+		responses <- response.StatusCode
+	}()
+	if _, err := fmt.Fprint(writer, `{"update_id": 1, `); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopping did not time out")
+	}
+
+	// The handler finishes after the channel is closed
+	if _, err := fmt.Fprint(writer, `"message": {"message_id": 1, "chat": {"id": 100}, "text": "hi"}}`); err != nil {
+		t.Fatal(err)
+	}
+	_ = writer.Close()
+
+	if status := <-responses; status != http.StatusServiceUnavailable {
+		t.Errorf("want the late update refused, got %d", status)
+	}
+	if <-delivered {
+		t.Error("want no update delivered after stopping")
+	}
+}
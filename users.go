@@ -0,0 +1,668 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	bucketUsers   = "Users"   // User id -> User added by an invite
+	bucketInvites = "Invites" // Invite code -> Invite
+)
+
+const defaultInviteExpiry = 24 * time.Hour
+
+var (
+	errInviteNotFound = errors.New("invite is not found")
+	errInviteExpired  = errors.New("invite has expired")
+)
+
+// User is a user added by an invite. Users of FM_OWNER_USERS_ID, FM_ALLOWED_USERS_ID and FM_VIEWER_USERS_ID
+// are not stored, their roles are set by the config.
+type User struct {
+	ID        int64     `json:"id"`
+	UserName  string    `json:"userName"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	InvitedBy int64     `json:"invitedBy"`
+	AddedAt   time.Time `json:"addedAt"`
+}
+
+// Invite is a one-time code adding a user with the role
+type Invite struct {
+	Code      string    `json:"code"`
+	Role      Role      `json:"role"`
+	CreatedBy int64     `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// describe returns the user as @username (id) or the name if the user has no username
+func (u User) describe() string {
+	switch {
+	case u.UserName != "":
+		return fmt.Sprintf("@%s (%d)", u.UserName, u.ID)
+	case u.Name != "":
+		return fmt.Sprintf("%s (%d)", u.Name, u.ID)
+	default:
+		return strconv.FormatInt(u.ID, 10)
+	}
+}
+
+// userRole returns the role of the user from the config or, for users added by invites, from the database
+func userRole(c Config, userID int64) Role {
+	if role := c.configRole(userID); role != RoleNone {
+		return role
+	}
+
+	user, err := getUser(userID)
+	if err != nil {
+		log.Printf("Error getting user %d: %v", userID, err)
+		return RoleNone
+	}
+	if user == nil {
+		return RoleNone
+	}
+	return user.Role
+}
+
+// canManage reports whether a user with role actor can change the role of a user from current to next.
+// Admins manage viewers and make admins, owners manage everyone.
+func canManage(actor Role, current Role, next Role) bool {
+	if actor == RoleOwner {
+		return true
+	}
+	return actor == RoleAdmin && current < RoleAdmin && next <= RoleAdmin
+}
+
+// configRoleKey returns the setting listing users with the role
+func configRoleKey(role Role) string {
+	switch role {
+	case RoleOwner:
+		return keyOwnerUsers
+	case RoleAdmin:
+		return keyAllowedUsers
+	default:
+		return keyViewerUsers
+	}
+}
+
+func userKey(id int64) []byte {
+	return []byte(strconv.FormatInt(id, 10))
+}
+
+// getUser returns the user added by an invite or nil if there is no such user
+func getUser(id int64) (*User, error) {
+	var user *User
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketUsers))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketUsers)
+		}
+
+		data := b.Get(userKey(id))
+		if data == nil {
+			return nil
+		}
+		user = &User{}
+		return json.Unmarshal(data, user)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// getUsers returns users added by invites ordered by role and id
+func getUsers() ([]User, error) {
+	var users []User
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketUsers))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketUsers)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var user User
+			if err := json.Unmarshal(v, &user); err != nil {
+				log.Printf("Error unmarshaling user %s: %v", k, err)
+				return nil
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Role != users[j].Role {
+			return users[i].Role > users[j].Role
+		}
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+// findUser returns the user added by an invite by id or @username, nil if there is no such user
+func findUser(arg string) (*User, error) {
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		return getUser(id)
+	}
+
+	users, err := getUsers()
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimPrefix(arg, "@")
+	for _, user := range users {
+		if user.UserName != "" && strings.EqualFold(user.UserName, name) {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+func saveUser(user User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("error marshaling user: %v", err)
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketUsers))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketUsers)
+		}
+		return b.Put(userKey(user.ID), data)
+	})
+}
+
+func deleteUser(id int64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketUsers))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketUsers)
+		}
+		return b.Delete(userKey(id))
+	})
+}
+
+// newInviteCode returns a random code which can't be guessed
+func newInviteCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// saveInvite saves the invite and removes expired ones
+func saveInvite(invite Invite, now time.Time) error {
+	data, err := json.Marshal(invite)
+	if err != nil {
+		return fmt.Errorf("error marshaling invite: %v", err)
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketInvites))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketInvites)
+		}
+
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var old Invite
+			if err := json.Unmarshal(v, &old); err != nil || now.After(old.ExpiresAt) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return b.Put([]byte(invite.Code), data)
+	})
+}
+
+// getInvites returns invites which have not expired ordered by expiry
+func getInvites(now time.Time) ([]Invite, error) {
+	var invites []Invite
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketInvites))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketInvites)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var invite Invite
+			if err := json.Unmarshal(v, &invite); err != nil {
+				log.Printf("Error unmarshaling invite %s: %v", k, err)
+				return nil
+			}
+			if !now.After(invite.ExpiresAt) {
+				invites = append(invites, invite)
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(invites, func(i, j int) bool { return invites[i].ExpiresAt.Before(invites[j].ExpiresAt) })
+	return invites, nil
+}
+
+// getInvite returns the invite or nil if there is no such invite
+func getInvite(code string) (*Invite, error) {
+	var invite *Invite
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketInvites))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketInvites)
+		}
+
+		data := b.Get([]byte(code))
+		if data == nil {
+			return nil
+		}
+		invite = &Invite{}
+		return json.Unmarshal(data, invite)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// deleteInvite removes the invite and reports whether it existed
+func deleteInvite(code string) (bool, error) {
+	deleted := false
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketInvites))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucketInvites)
+		}
+		if b.Get([]byte(code)) == nil {
+			return nil
+		}
+		deleted = true
+		return b.Delete([]byte(code))
+	})
+
+	if err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+// redeemInvite adds the user with the role of the invite and removes the invite, so it is used once
+func redeemInvite(code string, user User, now time.Time) (Invite, error) {
+	var invite Invite
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		invites := tx.Bucket([]byte(bucketInvites))
+		users := tx.Bucket([]byte(bucketUsers))
+		if invites == nil || users == nil {
+			return fmt.Errorf("buckets %s and %s not found", bucketInvites, bucketUsers)
+		}
+
+		data := invites.Get([]byte(code))
+		if data == nil {
+			return errInviteNotFound
+		}
+		if err := json.Unmarshal(data, &invite); err != nil {
+			return fmt.Errorf("error unmarshaling invite: %v", err)
+		}
+		if err := invites.Delete([]byte(code)); err != nil {
+			return err
+		}
+		if now.After(invite.ExpiresAt) {
+			return nil // The expired invite is removed
+		}
+
+		user.Role = invite.Role
+		user.InvitedBy = invite.CreatedBy
+		user.AddedAt = now
+		userData, err := json.Marshal(user)
+		if err != nil {
+			return fmt.Errorf("error marshaling user: %v", err)
+		}
+		return users.Put(userKey(user.ID), userData)
+	})
+
+	if err != nil {
+		return Invite{}, err
+	}
+	if now.After(invite.ExpiresAt) {
+		return Invite{}, errInviteExpired
+	}
+	return invite, nil
+}
+
+func parseStartArgs(message *tgbotapi.Message) (any, error) {
+	return strings.ToLower(strings.TrimSpace(message.CommandArguments())), nil
+}
+
+func handleStartCommand(bot Sender, update tgbotapi.Update, args any) {
+	code := args.(string)
+	if code == "" {
+		msg := tgbotapi.NewMessage(update.Message.Chat.ID, startMessage)
+		if _, err := sendMessageWithRetry(bot, msg); err != nil {
+			log.Println("Failed send start msg after all retries:", err)
+		}
+		return
+	}
+
+	chatID, messageID := update.Message.Chat.ID, update.Message.MessageID
+	from := update.Message.From
+	if role := userRole(currentConfig(), from.ID); role != RoleNone {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("You already have the %s role", role))
+		return
+	}
+
+	user := User{ID: from.ID, UserName: from.UserName, Name: strings.TrimSpace(from.FirstName + " " + from.LastName)}
+	invite, err := redeemInvite(code, user, time.Now())
+	switch {
+	case errors.Is(err, errInviteNotFound):
+		log.Printf("User %s: %d sent an unknown invite code", from.UserName, from.ID)
+		sendSafeReplyText(chatID, messageID, bot, "This invite code is not valid or was already used")
+		return
+	case errors.Is(err, errInviteExpired):
+		sendSafeReplyText(chatID, messageID, bot, "This invite code has expired, please ask for a new one")
+		return
+	case err != nil:
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error redeeming invite: %v", err))
+		return
+	}
+
+	log.Printf("User %s: %d joined as %s by invite of %d", from.UserName, from.ID, invite.Role, invite.CreatedBy)
+	sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("✅ Welcome! You have the %s role now\n\n%s",
+		invite.Role, startMessage))
+	sendSafeReplyText(invite.CreatedBy, 0, bot, fmt.Sprintf("🎟 %s joined with the %s role by your invite",
+		user.describe(), invite.Role))
+}
+
+// inviteArgs are arguments of the /invite command
+type inviteArgs struct {
+	role   Role
+	expiry time.Duration
+}
+
+func parseInviteArgs(message *tgbotapi.Message) (any, error) {
+	usage := errors.New("Usage: /invite [viewer|admin|owner] [expiry], e.g. /invite viewer 7d\n" +
+		"Default role is viewer, the code expires in 24h.")
+
+	args := inviteArgs{role: RoleViewer, expiry: defaultInviteExpiry}
+	fields := strings.Fields(message.CommandArguments())
+	if len(fields) > 2 {
+		return nil, usage
+	}
+	for _, field := range fields {
+		if role, ok := parseRole(field); ok {
+			args.role = role
+			continue
+		}
+		expiry, err := parseExpiry(field)
+		if err != nil {
+			return nil, usage
+		}
+		args.expiry = expiry
+	}
+	return args, nil
+}
+
+// parseExpiry parses durations like 30m, 12h or 7d
+func parseExpiry(value string) (time.Duration, error) {
+	var expiry time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		expiry = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if expiry, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	}
+	if expiry <= 0 {
+		return 0, errors.New("expiry must be positive")
+	}
+	return expiry, nil
+}
+
+func handleInviteCommand(bot Sender, update tgbotapi.Update, args any) {
+	chatID, messageID := update.Message.Chat.ID, update.Message.MessageID
+	invite := args.(inviteArgs)
+
+	if !canManage(userRole(currentConfig(), update.Message.From.ID), RoleNone, invite.role) {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("🔒 Only owners can invite a user with the %s role",
+			invite.role))
+		return
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error creating invite: %v", err))
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(invite.expiry)
+	err = saveInvite(Invite{Code: code, Role: invite.role, CreatedBy: update.Message.From.ID, CreatedAt: now,
+		ExpiresAt: expiresAt}, now)
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error saving invite: %v", err))
+		return
+	}
+
+	sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("🎟 Invite with the %s role, valid once until %s\n"+
+		"The new user sends this command to the bot:\n/start %s",
+		invite.role, chatTime(chatID, expiresAt).Format("02.01.2006 15:04"), code))
+}
+
+func handleUsersCommand(bot Sender, update tgbotapi.Update, _ any) {
+	chatID, messageID := update.Message.Chat.ID, update.Message.MessageID
+
+	users, err := getUsers()
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error getting users: %v", err))
+		return
+	}
+	invites, err := getInvites(time.Now())
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error getting invites: %v", err))
+		return
+	}
+	sendSafeReplyText(chatID, messageID, bot, formatUsers(currentConfig(), users, invites, chatID,
+		update.Message.From.ID))
+}
+
+// formatUsers lists users of the config and users added by invites with pending invites.
+// Codes are shown to their creators and owners, a code is never shown to a user who can't grant its role.
+func formatUsers(c Config, users []User, invites []Invite, chatID int64, actorID int64) string {
+	var sb strings.Builder
+	sb.WriteString("👥 Users\n")
+	for _, role := range []Role{RoleOwner, RoleAdmin, RoleViewer} {
+		for _, id := range c.roleUserIds(role) {
+			sb.WriteString(fmt.Sprintf("\n%s: %d (%s)", role, id, configRoleKey(role)))
+		}
+	}
+	for _, user := range users {
+		sb.WriteString(fmt.Sprintf("\n%s: %s, invited by %d", user.Role, user.describe(), user.InvitedBy))
+	}
+
+	if len(invites) > 0 {
+		sb.WriteString("\n\n🎟 Invites\n")
+		actor := userRole(c, actorID)
+		for _, invite := range invites {
+			code := "(hidden)"
+			if canManage(actor, RoleViewer, invite.Role) && (invite.CreatedBy == actorID || actor == RoleOwner) {
+				code = invite.Code
+			}
+			sb.WriteString(fmt.Sprintf("\n%s: %s by %d until %s", code, invite.Role, invite.CreatedBy,
+				chatTime(chatID, invite.ExpiresAt).Format("02.01.2006 15:04")))
+		}
+	}
+	return sb.String()
+}
+
+// roleUserIds returns users with the role in the config
+func (c Config) roleUserIds(role Role) []int64 {
+	switch role {
+	case RoleOwner:
+		return c.ownerUserIds
+	case RoleAdmin:
+		return c.allowedUserIds
+	case RoleViewer:
+		return c.viewerUserIds
+	default:
+		return nil
+	}
+}
+
+// promoteArgs are arguments of the /promote command
+type promoteArgs struct {
+	user string // Id or @username
+	role Role
+}
+
+func parsePromoteArgs(message *tgbotapi.Message) (any, error) {
+	fields := strings.Fields(message.CommandArguments())
+	if len(fields) != 2 {
+		return nil, errors.New("Usage: /promote <user id|@username> viewer|admin|owner")
+	}
+	role, ok := parseRole(fields[1])
+	if !ok {
+		return nil, errors.New("Usage: /promote <user id|@username> viewer|admin|owner")
+	}
+	return promoteArgs{user: fields[0], role: role}, nil
+}
+
+func handlePromoteCommand(bot Sender, update tgbotapi.Update, args any) {
+	chatID, messageID := update.Message.Chat.ID, update.Message.MessageID
+	promote := args.(promoteArgs)
+
+	user, ok := managedUser(bot, update.Message, promote.user)
+	if !ok {
+		return
+	}
+	if !canManage(userRole(currentConfig(), update.Message.From.ID), user.Role, promote.role) {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("🔒 Only owners can change the role of %s to %s",
+			user.describe(), promote.role))
+		return
+	}
+
+	user.Role = promote.role
+	if err := saveUser(*user); err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error saving user: %v", err))
+		return
+	}
+	sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("✅ %s has the %s role now", user.describe(), user.Role))
+}
+
+func parseRevokeArgs(message *tgbotapi.Message) (any, error) {
+	arg := strings.TrimSpace(message.CommandArguments())
+	if arg == "" || strings.ContainsAny(arg, " \t\n") {
+		return nil, errors.New("Usage: /revoke <user id|@username|invite code>")
+	}
+	return arg, nil
+}
+
+func handleRevokeCommand(bot Sender, update tgbotapi.Update, args any) {
+	chatID, messageID := update.Message.Chat.ID, update.Message.MessageID
+	arg := args.(string)
+
+	// Invite codes are revoked before they are used
+	code := strings.ToLower(arg)
+	invite, err := getInvite(code)
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error getting invite: %v", err))
+		return
+	}
+	if invite != nil {
+		c, actorID := currentConfig(), update.Message.From.ID
+		actor := userRole(c, actorID)
+		// Admins revoke their own invites and invites of viewers, owners revoke all
+		if !canManage(actor, RoleViewer, invite.Role) ||
+			invite.CreatedBy != actorID && !canManage(actor, userRole(c, invite.CreatedBy), RoleNone) {
+			sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("🔒 Only owners can revoke invite %s", code))
+			return
+		}
+		if _, err := deleteInvite(code); err != nil {
+			sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error revoking invite: %v", err))
+			return
+		}
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("🚫 Invite %s is revoked", code))
+		return
+	}
+
+	user, ok := managedUser(bot, update.Message, arg)
+	if !ok {
+		return
+	}
+	if !canManage(userRole(currentConfig(), update.Message.From.ID), user.Role, RoleNone) {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("🔒 Only owners can revoke access of %s", user.describe()))
+		return
+	}
+
+	if err := deleteUser(user.ID); err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error removing user: %v", err))
+		return
+	}
+	log.Printf("User %d revoked access of %s", update.Message.From.ID, user.describe())
+	sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("🚫 %s has no access now", user.describe()))
+}
+
+// managedUser returns the user added by an invite by id or @username, replying if the user can't be managed
+func managedUser(bot Sender, message *tgbotapi.Message, arg string) (*User, bool) {
+	chatID, messageID := message.Chat.ID, message.MessageID
+
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		if role := currentConfig().configRole(id); role != RoleNone {
+			sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("User %d has the %s role by %s, change it in the config",
+				id, role, configRoleKey(role)))
+			return nil, false
+		}
+	}
+
+	user, err := findUser(arg)
+	if err != nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("Error getting user: %v", err))
+		return nil, false
+	}
+	if user == nil {
+		sendSafeReplyText(chatID, messageID, bot, fmt.Sprintf("User %s is not found, add them with /invite", arg))
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInviteAndManageUsers(t *testing.T) {
+	e := newTestEnv(t)
+	e.addPhoto(t, "a.jpg", time.Date(2020, 1, 1, 10, 0, 0, 0, time.Local))
+	e.index(t)
+
+	const newUserID, otherUserID = 6001, 6002
+
+	// An admin of FM_ALLOWED_USERS_ID invites a viewer, who joins with the code
+	e.send(t, testUserID, "/invite viewer 7d")
+	reply := texts(e.telegram.Calls("sendMessage"))[0]
+	_, code, ok := strings.Cut(reply, "/start ")
+	if !strings.Contains(reply, "Invite with the viewer role") || !ok || len(code) != 16 {
+		t.Fatalf("want invite code, got %q", reply)
+	}
+
+	e.telegram.Reset()
+	e.send(t, newUserID, "/photo 1")
+	e.send(t, newUserID, "/start "+code)
+	e.send(t, otherUserID, "/start "+code)
+	e.send(t, newUserID, "/photo 1")
+	sendJobs.Flush()
+
+	replies := texts(e.telegram.Calls("sendMessage"))
+	if !containsText(replies, "Welcome! You have the viewer role now") ||
+		!containsText(replies, "@user6001 (6001) joined with the viewer role by your invite") {
+		t.Errorf("want user joined and the admin notified, got %q", replies)
+	}
+	if !containsText(replies, "not valid or was already used") {
+		t.Errorf("want the code used once, got %q", replies)
+	}
+	if len(e.telegram.Calls("sendMediaGroup")) != 1 {
+		t.Errorf("want photos only after joining, got %d sendings", len(e.telegram.Calls("sendMediaGroup")))
+	}
+
+	// Viewers don't manage users, admins can't make owners
+	e.telegram.Reset()
+	e.send(t, newUserID, "/invite")
+	e.send(t, testUserID, "/promote 6001 owner")
+	e.send(t, testUserID, "/promote 6001 admin")
+	e.send(t, testUserID, "/revoke 12345")
+	e.send(t, testUserID, "/users")
+
+	replies = texts(e.telegram.Calls("sendMessage"))
+	for _, want := range []string{"Only admins can do this",
+		"Only owners can change the role of @user6001 (6001) to owner",
+		"@user6001 (6001) has the admin role now", "User 12345 is not found"} {
+		if !containsText(replies, want) {
+			t.Errorf("want %q, got %q", want, replies)
+		}
+	}
+	users := replies[len(replies)-1]
+	if !strings.Contains(users, "admin: 200 (FM_ALLOWED_USERS_ID)") ||
+		!strings.Contains(users, "admin: @user6001 (6001), invited by 200") {
+		t.Errorf("want users of the config and invited users, got %q", users)
+	}
+	if role := userRole(cfg, newUserID); role != RoleAdmin {
+		t.Errorf("want promoted user admin, got %s", role)
+	}
+
+	// Users of the config are changed in the config, only owners revoke admins
+	const ownerID = 300
+	cfg.ownerUserIds = []int64{ownerID}
+	e.telegram.Reset()
+	e.send(t, ownerID, "/revoke 200")
+	e.send(t, testUserID, "/revoke @User6001")
+	e.send(t, ownerID, "/revoke @User6001")
+	e.send(t, newUserID, "/photo 1")
+	sendJobs.Flush()
+
+	replies = texts(e.telegram.Calls("sendMessage"))
+	for _, want := range []string{"change it in the config", "Only owners can revoke access of @user6001 (6001)",
+		"@user6001 (6001) has no access now"} {
+		if !containsText(replies, want) {
+			t.Errorf("want %q, got %q", want, replies)
+		}
+	}
+	if len(e.telegram.Calls("sendMediaGroup")) != 0 || userRole(cfg, newUserID) != RoleNone {
+		t.Errorf("want revoked user ignored")
+	}
+}
+
+func TestExpiredInvite(t *testing.T) {
+	e := newTestEnv(t)
+
+	now := time.Now()
+	invite := Invite{Code: "expired", Role: RoleViewer, CreatedBy: testUserID, CreatedAt: now.Add(-2 * time.Hour),
+		ExpiresAt: now.Add(-time.Hour)}
+	if err := saveInvite(invite, invite.CreatedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	e.send(t, 6001, "/start expired")
+	if !containsText(texts(e.telegram.Calls("sendMessage")), "This invite code has expired") {
+		t.Errorf("want expired invite, got %q", texts(e.telegram.Calls("sendMessage")))
+	}
+	if invites, err := getInvites(invite.CreatedAt); err != nil || len(invites) != 0 {
+		t.Errorf("want expired invite removed, got %v %v", invites, err)
+	}
+}
+
+func TestUsersShowsOnlyInviteCodesTheUserCanGrant(t *testing.T) {
+	e := newTestEnv(t)
+	const ownerID = 300
+	cfg.ownerUserIds = []int64{ownerID}
+
+	inviteCode := func(userID int64, args string) string {
+		e.telegram.Reset()
+		e.send(t, userID, "/invite "+args)
+		_, code, ok := strings.Cut(texts(e.telegram.Calls("sendMessage"))[0], "/start ")
+		if !ok {
+			t.Fatalf("want invite code, got %q", texts(e.telegram.Calls("sendMessage")))
+		}
+		return code
+	}
+	ownerCode := inviteCode(ownerID, "owner")
+	adminCode := inviteCode(testUserID, "viewer")
+
+	// The admin sees the own code, but not the code of the owner invite
+	e.telegram.Reset()
+	e.send(t, testUserID, "/users")
+	list := texts(e.telegram.Calls("sendMessage"))[0]
+	if !strings.Contains(list, adminCode) || strings.Contains(list, ownerCode) || !strings.Contains(list, "(hidden): owner") {
+		t.Errorf("want only the code of the admin, got %q", list)
+	}
+
+	// Owners see all codes
+	e.telegram.Reset()
+	e.send(t, ownerID, "/users")
+	list = texts(e.telegram.Calls("sendMessage"))[0]
+	if !strings.Contains(list, adminCode) || !strings.Contains(list, ownerCode) {
+		t.Errorf("want all codes shown to the owner, got %q", list)
+	}
+}
+
+func TestRevokeInviteChecksRoles(t *testing.T) {
+	e := newTestEnv(t)
+	const ownerID = 300
+	cfg.ownerUserIds = []int64{ownerID}
+
+	now := time.Now()
+	for _, invite := range []Invite{
+		{Code: "owner-role", Role: RoleOwner, CreatedBy: ownerID},
+		{Code: "by-owner", Role: RoleViewer, CreatedBy: ownerID},
+		{Code: "by-admin", Role: RoleViewer, CreatedBy: testUserID},
+	} {
+		invite.CreatedAt, invite.ExpiresAt = now, now.Add(time.Hour)
+		if err := saveInvite(invite, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e.send(t, testUserID, "/revoke owner-role")
+	e.send(t, testUserID, "/revoke by-owner")
+	e.send(t, testUserID, "/revoke by-admin")
+
+	replies := texts(e.telegram.Calls("sendMessage"))
+	for _, want := range []string{"Only owners can revoke invite owner-role", "Only owners can revoke invite by-owner",
+		"Invite by-admin is revoked"} {
+		if !containsText(replies, want) {
+			t.Errorf("want %q, got %q", want, replies)
+		}
+	}
+	invites, err := getInvites(now)
+	if err != nil || len(invites) != 2 {
+		t.Errorf("want invites of the owner kept, got %v %v", invites, err)
+	}
+}
@@ -1,34 +1,83 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
+	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
 )
 
-func find(root string, ext []string) []string {
+// findWithContext walks root for photos until ctx is cancelled.
+// Photos found so far are counted in found if it is not nil. Each photo waits for throttle, nil doesn't throttle.
+func findWithContext(ctx context.Context, root string, found *atomic.Int64, throttle *indexingThrottle) []string {
+	return walkPhotos(ctx, root, found, nil, nil, throttle)
+}
+
+// walkPhotos is findWithContext which doesn't return paths for which skip returns true,
+// skipped folders are not walked. Files are counted by type in formats if it is not nil.
+func walkPhotos(ctx context.Context, root string, found *atomic.Int64, formats *FormatStats,
+	skip func(path string, isDir bool) bool, throttle *indexingThrottle) []string {
 	log.Print("searching for photos in ", root)
 
 	var a []string
 
 	_ = filepath.WalkDir(root, func(s string, d fs.DirEntry, e error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+
+		// The folder may be deleted, unmounted or unreadable, the rest of the library is still walked
+		if e != nil {
+			log.Printf("Error searching for photos in %s: %v", s, e)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		//skip synology @eadir folder
 		if d.IsDir() && d.Name() == "@eaDir" {
 			return filepath.SkipDir
 		}
 
-		if e != nil {
-			log.Panic(e)
+		if d.IsDir() {
+			if skip != nil && skip(s, true) {
+				if formats != nil {
+					formats.ExcludedFolders++
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		mediaType := mediaTypes.typeOf(s)
+		if skip != nil && skip(s, false) {
+			if formats != nil && mediaType != nil {
+				formats.ExcludedFiles++
+			}
 			return nil
 		}
-		if !d.IsDir() && contains(ext, filepath.Ext(d.Name())) {
+		if formats != nil {
+			formats.count(s, mediaType)
+		}
+		if mediaType != nil {
+			// In low priority mode the walk is limited like reading photos
+			if throttle.wait(ctx) != nil {
+				return filepath.SkipAll
+			}
 			a = append(a, s)
+			if found != nil {
+				found.Add(1)
+			}
 		}
 		return nil
 	})
@@ -114,70 +163,106 @@ func formatDuration(seconds float64) string {
 	return fmt.Sprintf("%d sec", secs)
 }
 
+// Retry settings for sending requests to Telegram
+const (
+	sendMaxRetries        = 5
+	sendInitialRetryDelay = 2 * time.Second
+)
+
 // sendMediaGroupWithRetry attempts to send a media group with retries
 // in case of network or API errors
-func sendMediaGroupWithRetry(bot *tgbotapi.BotAPI, mediaMsg tgbotapi.MediaGroupConfig) ([]tgbotapi.Message, error) {
-	maxRetries := 5
-	initialRetryDelay := 2 * time.Second
+func sendMediaGroupWithRetry(bot Sender, mediaMsg tgbotapi.MediaGroupConfig) ([]tgbotapi.Message, error) {
+	return sendWithRetry("media group", func() ([]tgbotapi.Message, error) {
+		return bot.SendMediaGroup(mediaMsg)
+	})
+}
+
+// sendMessageWithRetry attempts to send a message with retries
+// in case of network or API errors
+func sendMessageWithRetry(bot Sender, msg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return sendWithRetry("message", func() (tgbotapi.Message, error) {
+		return bot.Send(msg)
+	})
+}
 
-	var sentMessages []tgbotapi.Message
+// sendWithRetry calls send until it succeeds, fails with a permanent error or runs out of attempts.
+// On flood control it waits as long as Telegram asks in retry_after.
+func sendWithRetry[T any](what string, send func() (T, error)) (T, error) {
+	var result T
 	var err error
 
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		sentMessages, err = bot.SendMediaGroup(mediaMsg)
+	for attempt := 0; attempt < sendMaxRetries; attempt++ {
+		result, err = send()
 		if err == nil {
-			return sentMessages, nil
+			return result, nil
 		}
 
 		// Log the error
-		log.Printf("Error sending media group (attempt %d/%d): %v",
-			attempt+1, maxRetries, err)
+		log.Printf("Error sending %s (attempt %d/%d): %v",
+			what, attempt+1, sendMaxRetries, err)
+
+		// Retrying will not help if the request itself is wrong or the bot can't write to the chat
+		if isPermanentSendError(err) {
+			var zero T
+			return zero, fmt.Errorf("failed to send %s: %w", what, err)
+		}
 
 		// If this was the last attempt, return the error
-		if attempt >= maxRetries-1 {
-			return nil, fmt.Errorf("failed to send media group after %d attempts: %w",
-				maxRetries, err)
+		if attempt >= sendMaxRetries-1 {
+			break
 		}
 
-		// Calculate exponential backoff delay: 2s, 4s, 8s, 16s...
-		retryDelay := initialRetryDelay * time.Duration(1<<attempt)
+		retryDelay := sendRetryDelay(err, attempt)
 		log.Printf("Retrying in %s...", retryDelay)
-		time.Sleep(retryDelay)
+
+		// Don't hold up shutdown waiting for the next attempt
+		select {
+		case <-time.After(retryDelay):
+		case <-appCtx.Done():
+			var zero T
+			return zero, fmt.Errorf("failed to send %s, stopping: %w", what, err)
+		}
 	}
 
-	return nil, err // This should never happen due to the return in the loop
+	var zero T
+	return zero, fmt.Errorf("failed to send %s after %d attempts: %w", what, sendMaxRetries, err)
 }
 
-// sendMessageWithRetry attempts to send a message with retries
-// in case of network or API errors
-func sendMessageWithRetry(bot *tgbotapi.BotAPI, msg tgbotapi.Chattable) (tgbotapi.Message, error) {
-	maxRetries := 5
-	initialRetryDelay := 2 * time.Second
+// sendRetryDelay returns the delay before the next attempt: retry_after from Telegram
+// if present, otherwise exponential backoff 2s, 4s, 8s, 16s...
+func sendRetryDelay(err error, attempt int) time.Duration {
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+		return time.Duration(tgErr.RetryAfter) * time.Second
+	}
+	return sendInitialRetryDelay * time.Duration(1<<attempt)
+}
 
-	var sentMessage tgbotapi.Message
-	var err error
+// isPermanentSendError reports whether Telegram rejected the request in a way retrying can't fix:
+// bad request, unauthorized bot, chat not found, bot blocked or kicked
+func isPermanentSendError(err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) {
+		// Network errors are transient
+		return false
+	}
 
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		sentMessage, err = bot.Send(msg)
-		if err == nil {
-			return sentMessage, nil
-		}
+	if tgErr.RetryAfter > 0 {
+		return false
+	}
 
-		// Log the error
-		log.Printf("Error sending message (attempt %d/%d): %v",
-			attempt+1, maxRetries, err)
+	switch tgErr.Code {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	case http.StatusTooManyRequests:
+		return false
+	}
 
-		// If this was the last attempt, return the error
-		if attempt >= maxRetries-1 {
-			return tgbotapi.Message{}, fmt.Errorf("failed to send message after %d attempts: %w",
-				maxRetries, err)
+	// Upload requests don't fill the error code, only the description
+	for _, prefix := range []string{"Bad Request", "Unauthorized", "Forbidden", "Not Found"} {
+		if strings.HasPrefix(tgErr.Message, prefix) {
+			return true
 		}
-
-		// Calculate exponential backoff delay: 2s, 4s, 8s, 16s...
-		retryDelay := initialRetryDelay * time.Duration(1<<attempt)
-		log.Printf("Retrying in %s...", retryDelay)
-		time.Sleep(retryDelay)
 	}
-
-	return tgbotapi.Message{}, err // This should never happen due to the return in the loop
+	return false
 }
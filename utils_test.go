@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestSendWithRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		failures  []fakeFailure
+		wantErr   bool
+		wantCalls int
+		minDelay  time.Duration
+	}{
+		{
+			name:      "success",
+			method:    "sendMessage",
+			wantCalls: 1,
+		},
+		{
+			name:      "flood control waits retry_after",
+			method:    "sendMessage",
+			failures:  []fakeFailure{{http.StatusTooManyRequests, "Too Many Requests: retry after 1", 1}},
+			wantCalls: 2,
+			minDelay:  time.Second,
+		},
+		{
+			name:      "blocked by user is not retried",
+			method:    "sendMessage",
+			failures:  []fakeFailure{{http.StatusForbidden, "Forbidden: bot was blocked by the user", 0}},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "chat not found is not retried",
+			method:    "sendMessage",
+			failures:  []fakeFailure{{http.StatusBadRequest, "Bad Request: chat not found", 0}},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "bad request on upload is not retried",
+			method:    "sendMediaGroup",
+			failures:  []fakeFailure{{http.StatusBadRequest, "Bad Request: wrong file identifier", 0}},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "flood control on upload waits retry_after",
+			method:    "sendMediaGroup",
+			failures:  []fakeFailure{{http.StatusTooManyRequests, "Too Many Requests: retry after 1", 1}},
+			wantCalls: 2,
+			minDelay:  time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			telegram := newFakeTelegram(t)
+			bot := telegram.newBot()
+			for _, failure := range tt.failures {
+				telegram.failNext(tt.method, failure.code, failure.description, failure.retryAfter)
+			}
+
+			start := time.Now()
+			var err error
+			if tt.method == "sendMediaGroup" {
+				media := []interface{}{tgbotapi.NewInputMediaPhoto(tgbotapi.FileBytes{Name: "a.jpg", Bytes: []byte{1}})}
+				_, err = sendMediaGroupWithRetry(bot, tgbotapi.NewMediaGroup(testChatID, media))
+			} else {
+				_, err = sendMessageWithRetry(bot, tgbotapi.NewMessage(testChatID, "hello"))
+			}
+			elapsed := time.Since(start)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if got := len(telegram.Calls(tt.method)); got != tt.wantCalls {
+				t.Errorf("want %d calls, got %d", tt.wantCalls, got)
+			}
+			if elapsed < tt.minDelay {
+				t.Errorf("want delay of at least %s, got %s", tt.minDelay, elapsed)
+			}
+		})
+	}
+}
+
+func TestIsPermanentSendError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network error", errors.New("connection reset by peer"), false},
+		{"too many requests", &tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 5",
+			ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5}}, false},
+		{"server error", &tgbotapi.Error{Code: 502, Message: "Bad Gateway"}, false},
+		{"bad request", &tgbotapi.Error{Code: 400, Message: "Bad Request: message text is empty"}, true},
+		{"kicked", &tgbotapi.Error{Code: 403, Message: "Forbidden: bot was kicked from the group chat"}, true},
+		{"upload without code", &tgbotapi.Error{Message: "Forbidden: bot was blocked by the user"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPermanentSendError(tt.err); got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch modes
+const (
+	watchModeAuto = "auto" // inotify, polling if inotify can't be set up
+	watchModePoll = "poll" // Differential indexing every FM_WATCH_POLL_INTERVAL, for network mounts
+	watchModeOff  = "off"
+)
+
+// watchMaxDelayFactor limits how long changes wait, in debounce intervals, when new events keep coming
+const watchMaxDelayFactor = 10
+
+// StartLibraryWatcher keeps the index up to date with changes in enabled roots until ctx is cancelled.
+// Shutdown waits for the watcher like for indexing, because it writes to the index.
+func StartLibraryWatcher(ctx context.Context, roots []photoRoot) {
+	switch cfg.watchMode {
+	case watchModeOff:
+		log.Println("Library watcher is disabled")
+		return
+
+	case watchModePoll:
+		startLibraryPolling(ctx, roots, cfg.watchPollInterval)
+		return
+	}
+
+	watcher, err := newLibraryWatcher(roots, cfg.watchDebounce)
+	if err != nil {
+		log.Printf("Cannot watch library with inotify, polling every %s instead: %v", cfg.watchPollInterval, err)
+		startLibraryPolling(ctx, roots, cfg.watchPollInterval)
+		return
+	}
+
+	indexingWG.Add(1)
+	go func() {
+		defer indexingWG.Done()
+		watcher.run(ctx)
+	}()
+}
+
+// startLibraryPolling runs differential indexing every interval
+func startLibraryPolling(ctx context.Context, roots []photoRoot, interval time.Duration) {
+	log.Printf("Polling library for changes every %s", interval)
+
+	indexingWG.Add(1)
+	go func() {
+		defer indexingWG.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := StartDifferentialIndexing(ctx, roots, cfg.indexWorkers, triggerWatcher)
+				if err != nil {
+					log.Printf("Skipping library poll: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// watcherApplying is closed when the library watcher finishes applying changes, nil when it doesn't apply them.
+// It is protected by indexingMutex, indexing started meanwhile waits for it.
+var watcherApplying chan struct{}
+
+// libraryWatcher applies file system events to the index in batches
+type libraryWatcher struct {
+	roots    []photoRoot
+	debounce time.Duration
+	watcher  *fsnotify.Watcher
+	pending  map[string]bool // Changed paths waiting for the debounce timer
+}
+
+// newLibraryWatcher registers inotify watches on all directories of enabled roots
+func newLibraryWatcher(roots []photoRoot, debounce time.Duration) (*libraryWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &libraryWatcher{roots: roots, debounce: debounce, watcher: watcher, pending: make(map[string]bool)}
+	for _, root := range enabledPhotoRoots(roots) {
+		count, err := w.watchTree(root.path)
+		if err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+		log.Printf("Watching %d folders in %s (%s) for changes", count, root.path, root.label)
+	}
+	return w, nil
+}
+
+// watchTree adds watches on dir and its subdirectories, skipping @eaDir and excluded folders like find does
+func (w *libraryWatcher) watchTree(dir string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory may be removed while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == "@eaDir" || w.skipDir(path) {
+			return filepath.SkipDir
+		}
+
+		err = w.watcher.Add(path)
+		if err != nil {
+			return fmt.Errorf("cannot watch %s: %v", path, err)
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func (w *libraryWatcher) run(ctx context.Context) {
+	defer w.watcher.Close()
+
+	var flush <-chan time.Time
+	var firstPending time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			if len(w.pending) > 0 {
+				log.Printf("Library watcher stopped, %d changes will be picked up by the next indexing", len(w.pending))
+			}
+			return
+
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !w.handleEvent(event) {
+				continue
+			}
+
+			// Wait until changes settle, but not forever while files keep coming
+			now := time.Now()
+			if firstPending.IsZero() {
+				firstPending = now
+			}
+			if now.Sub(firstPending) < w.debounce*watchMaxDelayFactor {
+				flush = time.After(w.debounce)
+			}
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Library watcher error: %v", err)
+
+			// Events were lost, only a full scan can catch up
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				err := StartDifferentialIndexing(ctx, w.roots, cfg.indexWorkers, triggerWatcher)
+				if err != nil {
+					log.Printf("Cannot start indexing after lost events: %v", err)
+				}
+			}
+
+		case <-flush:
+			if !w.applyPending(ctx) {
+				// Indexing is running, try again later
+				flush = time.After(w.debounce)
+				continue
+			}
+			flush = nil
+			firstPending = time.Time{}
+		}
+	}
+}
+
+// handleEvent remembers the path changed by event. It returns false if the event is ignored.
+func (w *libraryWatcher) handleEvent(event fsnotify.Event) bool {
+	if filepath.Base(event.Name) == "@eaDir" || containsPathElement(event.Name, "@eaDir") {
+		return false
+	}
+
+	// Permission changes don't change photos
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+
+	if event.Has(fsnotify.Create) {
+		info, err := os.Stat(event.Name)
+		if err == nil && info.IsDir() {
+			if w.skipDir(event.Name) {
+				return false
+			}
+			// New folders are not watched yet, files may already be copied into them
+			_, err := w.watchTree(event.Name)
+			if err != nil {
+				log.Printf("Library watcher: %v", err)
+			}
+			w.pending[event.Name] = true
+			return true
+		}
+	}
+
+	// Removed and renamed paths may be folders, keep them for cleanup
+	if !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) &&
+		(!isPhotoFile(event.Name) || !photoRootAllows(w.roots, event.Name)) {
+		return false
+	}
+
+	w.pending[event.Name] = true
+	return true
+}
+
+// applyPending indexes new and changed photos and removes deleted ones.
+// It returns false without applying anything while indexing is active.
+func (w *libraryWatcher) applyPending(ctx context.Context) bool {
+	// The mutex is held only to claim the index, /reindex and /indexing must not wait for photos to be read
+	indexingMutex.Lock()
+	if activeRun != nil {
+		indexingMutex.Unlock()
+		log.Printf("Indexing is running, postponing %d library changes", len(w.pending))
+		return false
+	}
+	applying := make(chan struct{})
+	watcherApplying = applying
+	indexingMutex.Unlock()
+
+	defer func() {
+		indexingMutex.Lock()
+		watcherApplying = nil
+		indexingMutex.Unlock()
+		close(applying)
+	}()
+
+	lastIndexedTime, err := GetLastIndexedTime()
+	if err != nil {
+		lastIndexedTime = time.Time{}
+	}
+	calculateHashes, err := IsFileHashingEnabled()
+	if err != nil {
+		log.Printf("Error checking file hashing flag: %v", err)
+	}
+
+	report := newIndexingReport(indexTypeWatch, triggerWatcher)
+	var indexed, removed int
+	for path := range w.pending {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			// Deleted or renamed away, path may be a photo or a whole folder
+			count, err := removeIndexedPhotosUnder(path)
+			if err != nil {
+				log.Printf("Error removing %s from index: %v", path, err)
+			}
+			removed += count
+			report.removed(count)
+
+		case info.IsDir():
+			for _, photo := range findWithContext(ctx, path, nil, indexThrottle) {
+				if !photoRootAllows(w.roots, photo) {
+					continue
+				}
+				if w.indexPhoto(ctx, photo, lastIndexedTime, calculateHashes, report) {
+					indexed++
+				}
+			}
+
+		case !photoRootAllows(w.roots, path):
+			// A renamed photo or folder which is filtered out
+
+		default:
+			if w.indexPhoto(ctx, path, lastIndexedTime, calculateHashes, report) {
+				indexed++
+			}
+		}
+	}
+
+	log.Printf("Library watcher applied %d changes: %d photos indexed, %d removed", len(w.pending), indexed, removed)
+	saveIndexingReport(report, jobCompleted)
+	w.pending = make(map[string]bool)
+	return true
+}
+
+func (w *libraryWatcher) indexPhoto(ctx context.Context, path string, lastIndexedTime time.Time,
+	calculateHashes bool, report *IndexingReport) bool {
+	indexed, err := indexPhoto(ctx, path, false, lastIndexedTime, calculateHashes, report)
+	if err != nil {
+		log.Printf("Error indexing %s: %v", path, err)
+	}
+	return indexed
+}
+
+// skipDir reports whether dir is an excluded folder of its root
+func (w *libraryWatcher) skipDir(dir string) bool {
+	root := photoRootOf(w.roots, dir)
+	return root != nil && root.skipDir(dir)
+}
+
+// containsPathElement reports whether any element of path equals name
+func containsPathElement(path string, name string) bool {
+	for dir := filepath.Dir(path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if filepath.Base(dir) == name {
+			return true
+		}
+	}
+	return false
+}
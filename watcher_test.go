@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// isIndexed reports whether path has metadata in the index
+func isIndexed(t *testing.T, path string) bool {
+	t.Helper()
+
+	var found bool
+	err := db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte(bucketPhotoMetadata)).Get([]byte(path)) != nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+// waitIndexed waits until path is indexed or removed from the index
+func waitIndexed(t *testing.T, path string, want bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if isIndexed(t, path) == want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("want %s indexed=%v", path, want)
+}
+
+// startTestWatcher starts the library watcher of the test library, it is stopped on test cleanup
+func startTestWatcher(t *testing.T, e *testEnv, mode string) {
+	t.Helper()
+
+	cfg.watchMode = mode
+	cfg.watchDebounce = 50 * time.Millisecond
+	cfg.watchPollInterval = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartLibraryWatcher(ctx, cfg.photoRoots)
+	t.Cleanup(func() {
+		cancel()
+		WaitForIndexing(5 * time.Second)
+	})
+}
+
+func TestLibraryWatcherIndexesChanges(t *testing.T) {
+	e := newTestEnv(t)
+	old := e.addPhoto(t, "old.jpg", time.Date(2019, 3, 4, 10, 0, 0, 0, time.Local))
+	e.index(t)
+	startTestWatcher(t, e, watchModeAuto)
+
+	// New photo in a new nested folder
+	added := e.addPhoto(t, "2024/trip/new.jpg", time.Date(2024, 5, 6, 10, 0, 0, 0, time.Local))
+	waitIndexed(t, added, true)
+
+	// Photo in a new folder of the new folder is watched too
+	nested := e.addPhoto(t, "2024/trip/day2/nested.jpg", time.Date(2024, 5, 7, 10, 0, 0, 0, time.Local))
+	waitIndexed(t, nested, true)
+
+	// Synology thumbnails are skipped
+	thumbnail := e.addPhoto(t, "2024/@eaDir/thumb.jpg", time.Date(2024, 5, 6, 10, 0, 0, 0, time.Local))
+
+	// Renamed photo is moved in the index
+	renamed := filepath.Join(e.library, "renamed.jpg")
+	if err := os.Rename(old, renamed); err != nil {
+		t.Fatal(err)
+	}
+	waitIndexed(t, renamed, true)
+	waitIndexed(t, old, false)
+
+	// Deleted folder removes all its photos
+	if err := os.RemoveAll(filepath.Join(e.library, "2024/trip")); err != nil {
+		t.Fatal(err)
+	}
+	waitIndexed(t, added, false)
+	waitIndexed(t, nested, false)
+
+	if isIndexed(t, thumbnail) {
+		t.Error("want @eaDir skipped")
+	}
+}
+
+func TestLibraryPollingIndexesChanges(t *testing.T) {
+	e := newTestEnv(t)
+	old := e.addPhoto(t, "old.jpg", time.Date(2019, 3, 4, 10, 0, 0, 0, time.Local))
+	e.index(t)
+	startTestWatcher(t, e, watchModePoll)
+
+	added := e.addPhoto(t, "new.jpg", time.Date(2024, 5, 6, 10, 0, 0, 0, time.Local))
+	waitIndexed(t, added, true)
+
+	if err := os.Remove(old); err != nil {
+		t.Fatal(err)
+	}
+	waitIndexed(t, old, false)
+}
+
+func TestIndexingIsNotBlockedByWatcherChanges(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 5; i++ {
+		e.addPhoto(t, filepath.Join("copied", fmt.Sprintf("%d.jpg", i)), time.Date(2020, 1, i+1, 10, 0, 0, 0, time.Local))
+	}
+	indexThrottle = newIndexingThrottle(5, 0, 0)
+	t.Cleanup(func() { indexThrottle = nil })
+
+	// The watcher reads the copied folder slowly
+	w := &libraryWatcher{roots: cfg.photoRoots, pending: map[string]bool{filepath.Join(e.library, "copied"): true}}
+	applied := make(chan bool)
+	go func() { applied <- w.applyPending(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// Indexing is started and paused without waiting for the watcher, it runs after the watcher
+	started := time.Now()
+	if err := StartDifferentialIndexing(context.Background(), cfg.photoRoots, 2, triggerUser); err != nil {
+		t.Fatal(err)
+	}
+	if err := PauseIndexing(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > 200*time.Millisecond {
+		t.Errorf("want indexing commands not blocked by the watcher, took %s", elapsed)
+	}
+
+	if !<-applied {
+		t.Error("want changes applied")
+	}
+	if !WaitForIndexing(5 * time.Second) {
+		t.Fatal("indexing did not stop")
+	}
+	if job, err := getIndexingJob(); err != nil || job.State != jobPaused {
+		t.Errorf("want indexing paused while waiting for the watcher, got %+v %v", job, err)
+	}
+}